@@ -22,8 +22,10 @@ import (
 	"log"
 	"path/filepath"
 
+	"github.com/mattmoor/mink/pkg/builds/apko"
 	"github.com/mattmoor/mink/pkg/builds/buildpacks"
 	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/mattmoor/mink/pkg/builds/jib"
 )
 
 var where = flag.String("where", "./examples", "The directory into which we should write examples.")
@@ -36,6 +38,8 @@ func main() {
 	outputs := map[string]string{
 		"kaniko.yaml":    dockerfile.KanikoTaskString,
 		"buildpack.yaml": buildpacks.BuildpackTaskString,
+		"jib.yaml":       jib.JibTaskString,
+		"apko.yaml":      apko.ApkoTaskString,
 	}
 
 	for k, v := range outputs {
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/mattmoor/mink/pkg/bundles/kontext"
@@ -24,9 +25,15 @@ import (
 )
 
 func main() {
+	parallelism := flag.Int("parallelism", kontext.DefaultParallelism, "The number of files to expand concurrently.")
+	flag.Parse()
+	if *parallelism < 1 {
+		log.Fatalf("--parallelism must be at least 1, got %d", *parallelism)
+	}
+
 	ctx := signals.NewContext()
 
-	if err := kontext.Expand(ctx); err != nil {
+	if err := kontext.Expand(ctx, kontext.ExpandOptions{Parallelism: *parallelism}); err != nil {
 		log.Fatal("Expand() =", err)
 	}
 }
@@ -81,6 +81,8 @@ func init() {
 	rootCmd.AddCommand(command.NewBundleCommand(ctx))
 	rootCmd.AddCommand(command.NewBuildCommand(ctx))
 	rootCmd.AddCommand(command.NewBuildpackCommand(ctx))
+	rootCmd.AddCommand(command.NewKoCommand(ctx))
+	rootCmd.AddCommand(command.NewBuilderCommands(ctx)...)
 	rootCmd.AddCommand(command.NewRunCommand(ctx))
 
 	rootCmd.AddCommand(command.NewResolveCommand(ctx))
@@ -17,13 +17,18 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/spf13/pflag"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
 )
 
 type kv struct {
@@ -31,44 +36,250 @@ type kv struct {
 	Value string `toml:"value"`
 }
 
+type buildpack struct {
+	ID      string `toml:"id"`
+	Version string `toml:"version"`
+	URI     string `toml:"uri"`
+}
+
 type build struct {
-	Env []kv `toml:"env"`
+	Include    []string    `toml:"include"`
+	Exclude    []string    `toml:"exclude"`
+	Buildpacks []buildpack `toml:"buildpacks"`
+	Env        []kv        `toml:"env"`
+}
+
+type metadata struct {
+	ID        string `toml:"id"`
+	Name      string `toml:"name"`
+	Version   string `toml:"version"`
+	SourceURL string `toml:"source-url"`
 }
 
 type project struct {
-	Build build `toml:"build"`
+	Project metadata `toml:"project"`
+	Build   build    `toml:"build"`
 }
 
-const platformDir = "/platform/env"
+const (
+	// envDir is where, within the platform directory, the lifecycle reads
+	// the build-time environment variables, one file per variable.
+	envDir = "env"
 
-func handleTOML(filename string) {
+	// buildpacksFile holds the buildpacks to run, one ID[@VERSION] per line,
+	// within the platform directory.  The create step turns this into an
+	// order.toml, resolving missing versions against the buildpacks in the
+	// builder.
+	buildpacksFile = "mink/buildpacks"
+
+	// projectMetadataFile is where, within the layers directory, the
+	// lifecycle looks for the project metadata that it records in the
+	// io.buildpacks.project.metadata label.
+	projectMetadataFile = "project-metadata.toml"
+)
+
+func readTOML(filename string) project {
+	var metadata project
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		// No project.toml is fine!
-		return
+		return metadata
 	}
 
-	var metadata project
 	if _, err := toml.Decode(string(content), &metadata); err != nil {
 		log.Fatal("Malformed project.toml: ", err)
 	}
-	for _, elt := range metadata.Build.Env {
-		if err := ioutil.WriteFile(filepath.Join(platformDir, elt.Name), []byte(elt.Value), os.ModePerm); err != nil {
-			log.Fatalf("Unable to write %q: %v", elt.Name, err)
+	return metadata
+}
+
+// writeEnv writes the environment variable to the file of its name within
+// dir, so names that would refer to anything else are rejected.
+func writeEnv(dir, name, value string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid environment variable name %q", name)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to write %q: %w", name, err)
+	}
+	log.Printf("%s=%q", name, value)
+	return nil
+}
+
+// handleEnv writes the build-time environment variables of the project
+// descriptor and the --env flags beneath the platform directory.
+func handleEnv(p project, env []string, platformDir string) error {
+	dir := filepath.Join(platformDir, envDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create %q: %w", dir, err)
+	}
+	for _, elt := range p.Build.Env {
+		if err := writeEnv(dir, elt.Name, elt.Value); err != nil {
+			return err
+		}
+	}
+	// The --env flags take precedence over the project descriptor.
+	for _, elt := range env {
+		parts := strings.SplitN(elt, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("malformed --env %q, wanted NAME=VALUE", elt)
+		}
+		if err := writeEnv(dir, parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleBuildpacks writes the buildpacks to run, from the --buildpack flags
+// or else the project descriptor, beneath the platform directory.
+func handleBuildpacks(p project, bps []string, platformDir string) error {
+	// The --buildpack flags take precedence over the project descriptor.
+	if len(bps) == 0 {
+		for _, bp := range p.Build.Buildpacks {
+			if bp.ID == "" {
+				return fmt.Errorf("unsupported buildpack %q, buildpacks must be referenced by id from the builder", bp.URI)
+			}
+			if bp.Version != "" {
+				bps = append(bps, bp.ID+"@"+bp.Version)
+			} else {
+				bps = append(bps, bp.ID)
+			}
+		}
+	}
+	if len(bps) == 0 {
+		// Use the builder's default order.
+		return nil
+	}
+
+	file := filepath.Join(platformDir, buildpacksFile)
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create %q: %w", filepath.Dir(file), err)
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(bps, "\n")+"\n"), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to write %q: %w", file, err)
+	}
+	log.Printf("Buildpacks: %s", strings.Join(bps, ", "))
+	return nil
+}
+
+// handleProject writes the project metadata of the project descriptor
+// beneath the layers directory, from which the lifecycle labels the image.
+func handleProject(p project, layersDir string) error {
+	if p.Project == (metadata{}) {
+		return nil
+	}
+
+	var pm struct {
+		Source struct {
+			Type     string            `toml:"type"`
+			Version  map[string]string `toml:"version,omitempty"`
+			Metadata map[string]string `toml:"metadata,omitempty"`
+		} `toml:"source"`
+	}
+	pm.Source.Type = "project"
+	if p.Project.Version != "" {
+		pm.Source.Version = map[string]string{"declared": p.Project.Version}
+	}
+	md := make(map[string]string, 3)
+	if p.Project.ID != "" {
+		md["id"] = p.Project.ID
+	}
+	if p.Project.Name != "" {
+		md["name"] = p.Project.Name
+	}
+	if p.Project.SourceURL != "" {
+		md["url"] = p.Project.SourceURL
+	}
+	if len(md) != 0 {
+		pm.Source.Metadata = md
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(pm); err != nil {
+		return fmt.Errorf("unable to encode project metadata: %w", err)
+	}
+	file := filepath.Join(layersDir, projectMetadataFile)
+	if err := ioutil.WriteFile(file, buf.Bytes(), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to write %q: %w", file, err)
+	}
+	return nil
+}
+
+func patterns(ps []string) []gitignore.Pattern {
+	res := make([]gitignore.Pattern, 0, len(ps))
+	for _, p := range ps {
+		res = append(res, gitignore.ParsePattern(p, nil))
+	}
+	return res
+}
+
+// handleFiles removes the files from the application directory that the
+// project descriptor's include or exclude patterns leave out of the build.
+func handleFiles(p project, appDir string) error {
+	switch {
+	case len(p.Build.Include) != 0 && len(p.Build.Exclude) != 0:
+		return errors.New("malformed project.toml: build.include and build.exclude are mutually exclusive")
+	case len(p.Build.Include) == 0 && len(p.Build.Exclude) == 0:
+		return nil
+	}
+	include := gitignore.NewMatcher(patterns(p.Build.Include))
+	exclude := gitignore.NewMatcher(patterns(p.Build.Exclude))
+
+	err := filepath.Walk(appDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(appDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
 		}
-		log.Printf("%s=%q", elt.Name, elt.Value)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		switch {
+		case len(p.Build.Exclude) != 0 && exclude.Match(parts, info.IsDir()):
+		case len(p.Build.Include) != 0 && !info.IsDir() && !include.Match(parts, false):
+		default:
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("removing %q: %w", rel, err)
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to apply the project.toml include/exclude patterns: %w", err)
 	}
+	return nil
 }
 
 var (
 	descriptor = pflag.String("descriptor", "project.toml", "The path the project descriptor (aka project.toml)")
+	app        = pflag.String("app", "/workspace", "The path to the application directory")
+	platform   = pflag.String("platform", "/platform", "The path to the platform directory of the lifecycle")
+	layers     = pflag.String("layers", "/layers", "The path to the layers directory of the lifecycle")
+	env        = pflag.StringArray("env", nil, "Build-time environment variables as NAME=VALUE, which take precedence over the project descriptor")
+	buildpacks = pflag.StringSlice("buildpack", nil, "The buildpacks to run as ID[@VERSION], which take precedence over the project descriptor")
 )
 
 func main() {
 	pflag.Parse()
 
-	if err := os.MkdirAll(platformDir, os.ModePerm); err != nil {
-		log.Fatalf("Unable to create %q: %v", platformDir, err)
+	p := readTOML(*descriptor)
+	if err := handleEnv(p, *env, *platform); err != nil {
+		log.Fatal(err)
+	}
+	if err := handleBuildpacks(p, *buildpacks, *platform); err != nil {
+		log.Fatal(err)
+	}
+	if err := handleProject(p, *layers); err != nil {
+		log.Fatal(err)
+	}
+	if err := handleFiles(p, *app); err != nil {
+		log.Fatal(err)
 	}
-	handleTOML(*descriptor)
 }
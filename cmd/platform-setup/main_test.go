@@ -0,0 +1,348 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readDir returns the contents of the regular files beneath dir, keyed by
+// their slash-separated paths relative to it.
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Walk() =", err)
+	}
+	return got
+}
+
+func TestHandleEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		project project
+		env     []string
+		want    map[string]string
+		wantErr bool
+	}{{
+		name: "none",
+		want: map[string]string{},
+	}, {
+		name: "project descriptor",
+		project: project{Build: build{Env: []kv{
+			{Name: "BP_GO_TARGETS", Value: "./cmd/foo"},
+			{Name: "EMPTY", Value: ""},
+		}}},
+		want: map[string]string{
+			"env/BP_GO_TARGETS": "./cmd/foo",
+			"env/EMPTY":         "",
+		},
+	}, {
+		name: "flags take precedence",
+		project: project{Build: build{Env: []kv{
+			{Name: "BP_GO_TARGETS", Value: "./cmd/foo"},
+			{Name: "BP_KEEP", Value: "yes"},
+		}}},
+		env: []string{"BP_GO_TARGETS=./cmd/bar", "EQUATION=a=b"},
+		want: map[string]string{
+			"env/BP_GO_TARGETS": "./cmd/bar",
+			"env/BP_KEEP":       "yes",
+			"env/EQUATION":      "a=b",
+		},
+	}, {
+		name:    "missing value",
+		env:     []string{"BP_GO_TARGETS"},
+		wantErr: true,
+	}, {
+		name:    "missing name",
+		env:     []string{"=./cmd/bar"},
+		wantErr: true,
+	}, {
+		name:    "path name",
+		env:     []string{"../mink/buildpacks=paketo-buildpacks/go"},
+		wantErr: true,
+	}, {
+		name:    "dot name",
+		env:     []string{"..=x"},
+		wantErr: true,
+	}, {
+		name:    "path name in the project descriptor",
+		project: project{Build: build{Env: []kv{{Name: "a/b", Value: "x"}}}},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := handleEnv(test.project, test.env, dir)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("handleEnv() = nil, wanted error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("handleEnv() =", err)
+			}
+			if diff := cmp.Diff(test.want, readDir(t, dir)); diff != "" {
+				t.Errorf("handleEnv (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleBuildpacks(t *testing.T) {
+	tests := []struct {
+		name       string
+		project    project
+		buildpacks []string
+		want       map[string]string
+		wantErr    bool
+	}{{
+		name: "builder order",
+		want: map[string]string{},
+	}, {
+		name: "project descriptor",
+		project: project{Build: build{Buildpacks: []buildpack{
+			{ID: "paketo-buildpacks/go-dist", Version: "1.2.3"},
+			{ID: "paketo-buildpacks/go-build"},
+		}}},
+		want: map[string]string{
+			"mink/buildpacks": "paketo-buildpacks/go-dist@1.2.3\npaketo-buildpacks/go-build\n",
+		},
+	}, {
+		name: "flags take precedence",
+		project: project{Build: build{Buildpacks: []buildpack{
+			{ID: "paketo-buildpacks/go-dist"},
+		}}},
+		buildpacks: []string{"paketo-buildpacks/nodejs@2.0.0"},
+		want: map[string]string{
+			"mink/buildpacks": "paketo-buildpacks/nodejs@2.0.0\n",
+		},
+	}, {
+		name: "buildpacks by uri",
+		project: project{Build: build{Buildpacks: []buildpack{
+			{URI: "docker://ghcr.io/mattmoor/buildpack"},
+		}}},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := handleBuildpacks(test.project, test.buildpacks, dir)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("handleBuildpacks() = nil, wanted error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("handleBuildpacks() =", err)
+			}
+			if diff := cmp.Diff(test.want, readDir(t, dir)); diff != "" {
+				t.Errorf("handleBuildpacks (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleProject(t *testing.T) {
+	tests := []struct {
+		name    string
+		project project
+		want    map[string]string
+	}{{
+		name: "no metadata",
+		want: map[string]string{},
+	}, {
+		name:    "id and name",
+		project: project{Project: metadata{ID: "foo", Name: "Foo"}},
+		want: map[string]string{
+			"project-metadata.toml": "[source]\n  type = \"project\"\n  [source.metadata]\n    id = \"foo\"\n    name = \"Foo\"\n",
+		},
+	}, {
+		name:    "version",
+		project: project{Project: metadata{Version: "1.2.3"}},
+		want: map[string]string{
+			"project-metadata.toml": "[source]\n  type = \"project\"\n  [source.version]\n    declared = \"1.2.3\"\n",
+		},
+	}, {
+		name:    "version and source",
+		project: project{Project: metadata{Version: "1.2.3", SourceURL: "https://github.com/mattmoor/mink"}},
+		want: map[string]string{
+			"project-metadata.toml": "[source]\n  type = \"project\"\n  [source.version]\n    declared = \"1.2.3\"\n" +
+				"  [source.metadata]\n    url = \"https://github.com/mattmoor/mink\"\n",
+		},
+	}, {
+		name: "everything",
+		project: project{Project: metadata{
+			ID:        "mink",
+			Name:      "mink",
+			Version:   "1.2.3",
+			SourceURL: "https://github.com/mattmoor/mink",
+		}},
+		want: map[string]string{
+			"project-metadata.toml": "[source]\n  type = \"project\"\n  [source.version]\n    declared = \"1.2.3\"\n" +
+				"  [source.metadata]\n    id = \"mink\"\n    name = \"mink\"\n    url = \"https://github.com/mattmoor/mink\"\n",
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := handleProject(test.project, dir); err != nil {
+				t.Fatal("handleProject() =", err)
+			}
+			if diff := cmp.Diff(test.want, readDir(t, dir)); diff != "" {
+				t.Errorf("handleProject (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestHandleFiles(t *testing.T) {
+	files := []string{
+		"go.mod",
+		"main.go",
+		"README.md",
+		"docs/index.md",
+		"cmd/foo/main.go",
+		"cmd/foo/testdata/golden.txt",
+		"vendor/example.com/lib/lib.go",
+	}
+
+	tests := []struct {
+		name    string
+		build   build
+		want    []string
+		wantErr bool
+	}{{
+		name: "everything",
+		want: files,
+	}, {
+		name:  "exclude",
+		build: build{Exclude: []string{"*.md", "testdata/", "/vendor"}},
+		want:  []string{"go.mod", "main.go", "cmd/foo/main.go"},
+	}, {
+		name:  "include",
+		build: build{Include: []string{"*.go", "go.mod"}},
+		want:  []string{"go.mod", "main.go", "cmd/foo/main.go", "vendor/example.com/lib/lib.go"},
+	}, {
+		name:  "include a directory",
+		build: build{Include: []string{"/cmd/"}},
+		want:  []string{"cmd/foo/main.go", "cmd/foo/testdata/golden.txt"},
+	}, {
+		name:    "include and exclude",
+		build:   build{Include: []string{"*.go"}, Exclude: []string{"*.md"}},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range files {
+				path := filepath.Join(dir, filepath.FromSlash(f))
+				if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+					t.Fatal("MkdirAll() =", err)
+				}
+				if err := ioutil.WriteFile(path, []byte(f), 0644); err != nil {
+					t.Fatal("WriteFile() =", err)
+				}
+			}
+
+			err := handleFiles(project{Build: test.build}, dir)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("handleFiles() = nil, wanted error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("handleFiles() =", err)
+			}
+			got := []string{}
+			for f := range readDir(t, dir) {
+				got = append(got, f)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), test.want...)
+			sort.Strings(want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("handleFiles (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestReadTOML(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "project.toml")
+	content := strings.Join([]string{
+		"[project]",
+		`version = "1.2.3"`,
+		"[build]",
+		`exclude = ["*.md"]`,
+		"[[build.buildpacks]]",
+		`id = "paketo-buildpacks/go"`,
+		"[[build.env]]",
+		`name = "BP_GO_TARGETS"`,
+		`value = "./cmd/foo"`,
+	}, "\n")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal("WriteFile() =", err)
+	}
+
+	want := project{
+		Project: metadata{Version: "1.2.3"},
+		Build: build{
+			Exclude:    []string{"*.md"},
+			Buildpacks: []buildpack{{ID: "paketo-buildpacks/go"}},
+			Env:        []kv{{Name: "BP_GO_TARGETS", Value: "./cmd/foo"}},
+		},
+	}
+	if diff := cmp.Diff(want, readTOML(file)); diff != "" {
+		t.Errorf("readTOML (-want, +got): %s", diff)
+	}
+
+	// A missing project descriptor is fine.
+	if diff := cmp.Diff(project{}, readTOML(filepath.Join(dir, "missing.toml"))); diff != "" {
+		t.Errorf("readTOML (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apko
+
+import (
+	"context"
+	"path"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/constants"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+)
+
+var (
+	// ApkoTaskString holds the raw definition of the apko task.
+	// We export this into ./examples/apko.yaml
+	ApkoTaskString = `
+apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: apko
+spec:
+  description: |
+    An example apko task, which builds a minimal image from the declarative
+    configuration (packages, accounts, entrypoint) of an apko.yaml.
+
+    apko attaches the SBOMs of the image to it, and the task surfaces where
+    (or nothing, when apko generated none).
+  params:
+    - name: dev.mink.sources.bundle
+      description: A self-extracting container image of source
+    - name: dev.mink.images.target
+      description: Where to publish an image.
+    - name: config
+      description: The path to the apko configuration.
+      default: apko.yaml
+    - name: archs
+      description: |
+        The architectures for which to build, each as --arch=ARCH, by
+        default those of the configuration.
+      type: array
+      default: []
+    - name: apko-args
+      description: Extra arguments to supply to apko publish.
+      type: array
+      default: []
+
+  results:
+    - name: dev.mink.images.digest
+      description: The digest of the resulting image.
+    - name: dev.mink.images.sbom
+      description: |
+        The reference of the SBOM that apko attaches to the image, which is
+        empty when apko generated none (e.g. with --sbom=false).
+
+  steps:
+    - name: extract-bundle
+      image: $(params["dev.mink.sources.bundle"])
+      workingDir: /workspace
+
+    - name: publish
+      image: ghcr.io/chainguard-dev/apko:v0.6.0
+      workingDir: /workspace
+      env:
+      - name: DOCKER_CONFIG
+        value: /tekton/home/.docker
+      args:
+      - publish
+      - /workspace/$(params.config)
+      - $(params["dev.mink.images.target"])
+      - --image-refs=/apko/refs
+      - --sbom-path=/apko
+      - $(params.archs)
+      - $(params.apko-args)
+      volumeMounts:
+      - name: apko
+        mountPath: /apko
+
+    - name: extract-digest
+      image: gcr.io/distroless/base:debug
+      workingDir: /workspace
+      script: |
+        #!/busybox/sh
+        set -o errexit
+        REF="$(head -n 1 /apko/refs)"
+        case "${REF}" in
+          *@sha256:*) ;;
+          *)
+            echo "apko publish did not produce an image digest, got: ${REF}" >&2
+            exit 1
+            ;;
+        esac
+        DIGEST="${REF##*@}"
+        REPO="${REF%@*}"
+        case "${REPO##*/}" in
+          *:*) REPO="${REPO%:*}" ;;
+        esac
+        echo -n "${DIGEST}" > /tekton/results/dev.mink.images.digest
+        # apko writes the SBOMs that it attaches to the image to --sbom-path.
+        SBOM=""
+        set -- /apko/sbom-*
+        if [ -e "$1" ]; then
+          SBOM="${REPO}:sha256-${DIGEST#sha256:}.sbom"
+        fi
+        echo -n "${SBOM}" > /tekton/results/dev.mink.images.sbom
+      volumeMounts:
+      - name: apko
+        mountPath: /apko
+
+  volumes:
+    - name: apko
+      emptyDir: {}
+`
+	// ApkoTask is the parsed form of ApkoTaskString.
+	ApkoTask tknv1beta1.Task
+)
+
+func init() {
+	if err := yaml.Unmarshal([]byte(ApkoTaskString), &ApkoTask); err != nil {
+		panic(err)
+	}
+}
+
+// Options holds configuration options specific to apko builds
+type Options struct {
+	// Config is the path to the apko configuration within the bundle.
+	Config string
+
+	// Archs holds the architectures for which to build (e.g. amd64),
+	// by default those of the configuration.
+	Archs []string
+
+	// ApkoArgs holds extra arguments for apko publish.
+	ApkoArgs []string
+}
+
+// prefixed returns the values, each prefixed by the given flag.
+func prefixed(flag string, values []string) []string {
+	args := make([]string, 0, len(values))
+	for _, v := range values {
+		args = append(args, flag+"="+v)
+	}
+	return args
+}
+
+// Build returns a TaskRun suitable for performing an apko build over the
+// provided source and publishing to the target tag.
+func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) *tknv1beta1.TaskRun {
+	return &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "apko-",
+		},
+		Spec: tknv1beta1.TaskRunSpec{
+			PodTemplate: &tknv1beta1.PodTemplate{
+				EnableServiceLinks: ptr.Bool(false),
+			},
+			TaskSpec: ApkoTask.Spec.DeepCopy(),
+			Params: []tknv1beta1.Param{{
+				Name:  constants.SourceBundleParam,
+				Value: *tknv1beta1.NewArrayOrString(source.String()),
+			}, {
+				Name:  constants.ImageTargetParam,
+				Value: *tknv1beta1.NewArrayOrString(target.String()),
+			}, {
+				Name:  "config",
+				Value: *tknv1beta1.NewArrayOrString(path.Clean(opt.Config)),
+			}, {
+				Name: "archs",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: prefixed("--arch", opt.Archs),
+				},
+			}, {
+				Name: "apko-args",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: opt.ApkoArgs,
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apko
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+func TestPrefixed(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{{
+		name: "none",
+		want: []string{},
+	}, {
+		name:   "one",
+		values: []string{"amd64"},
+		want:   []string{"--arch=amd64"},
+	}, {
+		name:   "several",
+		values: []string{"amd64", "arm64", "arm/v7"},
+		want:   []string{"--arch=amd64", "--arch=arm64", "--arch=arm/v7"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := prefixed("--arch", test.values)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("prefixed (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	target, err := name.NewTag("ghcr.io/mattmoor/image:latest")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+	tr := Build(context.Background(), source, target, Options{
+		Config:   "base/./apko.yaml",
+		Archs:    []string{"amd64", "arm64"},
+		ApkoArgs: []string{"--debug"},
+	})
+
+	got := make(map[string]tknv1beta1.ArrayOrString, len(tr.Spec.Params))
+	for _, p := range tr.Spec.Params {
+		got[p.Name] = p.Value
+	}
+	want := map[string]tknv1beta1.ArrayOrString{
+		"dev.mink.sources.bundle": *tknv1beta1.NewArrayOrString(source.String()),
+		"dev.mink.images.target":  *tknv1beta1.NewArrayOrString(target.String()),
+		"config":                  *tknv1beta1.NewArrayOrString("base/apko.yaml"),
+		"archs": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--arch=amd64", "--arch=arm64"},
+		},
+		"apko-args": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--debug"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Params (-want, +got): %s", diff)
+	}
+
+	// Every parameter is declared by the task, which surfaces the digest and
+	// the SBOM of the image.
+	for _, p := range tr.Spec.Params {
+		found := false
+		for _, ps := range tr.Spec.TaskSpec.Params {
+			found = found || ps.Name == p.Name
+		}
+		if !found {
+			t.Errorf("parameter %q is not declared by the task", p.Name)
+		}
+	}
+	results := make([]string, 0, len(tr.Spec.TaskSpec.Results))
+	for _, r := range tr.Spec.TaskSpec.Results {
+		results = append(results, r.Name)
+	}
+	if diff := cmp.Diff([]string{"dev.mink.images.digest", "dev.mink.images.sbom"}, results); diff != "" {
+		t.Errorf("Results (-want, +got): %s", diff)
+	}
+
+	// The publish step runs a pinned apko.
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		if step.Name == "publish" && step.Image != "ghcr.io/chainguard-dev/apko:v0.6.0" {
+			t.Errorf("publish image = %s, wanted a pinned apko", step.Image)
+		}
+	}
+}
+
+func TestExtractDigestScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is required:", err)
+	}
+	var script string
+	for _, step := range ApkoTask.Spec.Steps {
+		if step.Name == "extract-digest" {
+			script = step.Script
+		}
+	}
+	if script == "" {
+		t.Fatal("no extract-digest step")
+	}
+
+	const digest = "sha256:deadbeef"
+	tests := []struct {
+		name       string
+		ref        string
+		sboms      []string
+		wantDigest string
+		wantSBOM   string
+		wantErr    bool
+	}{{
+		name:       "with sboms",
+		ref:        "ghcr.io/mattmoor/base:latest@" + digest,
+		sboms:      []string{"sbom-index.spdx.json", "sbom-x86_64.spdx.json"},
+		wantDigest: digest,
+		wantSBOM:   "ghcr.io/mattmoor/base:sha256-deadbeef.sbom",
+	}, {
+		name:       "registry with a port",
+		ref:        "localhost:5000/base@" + digest,
+		sboms:      []string{"sbom-x86_64.spdx.json"},
+		wantDigest: digest,
+		wantSBOM:   "localhost:5000/base:sha256-deadbeef.sbom",
+	}, {
+		// e.g. with --sbom=false
+		name:       "without sboms",
+		ref:        "ghcr.io/mattmoor/base:latest@" + digest,
+		wantDigest: digest,
+	}, {
+		name:    "no digest",
+		ref:     "ghcr.io/mattmoor/base:latest",
+		sboms:   []string{"sbom-x86_64.spdx.json"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apko, results := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(apko, "refs"), []byte(test.ref+"\n"), 0644); err != nil {
+				t.Fatal("WriteFile() =", err)
+			}
+			for _, name := range test.sboms {
+				if err := os.WriteFile(filepath.Join(apko, name), []byte("{}"), 0644); err != nil {
+					t.Fatal("WriteFile() =", err)
+				}
+			}
+			script := strings.NewReplacer("/apko", apko, "/tekton/results", results).Replace(script)
+
+			out, err := exec.Command(sh, "-c", script).CombinedOutput()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("script succeeded, wanted failure: %s", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("script failed: %v: %s", err, out)
+			}
+			for result, want := range map[string]string{
+				"dev.mink.images.digest": test.wantDigest,
+				"dev.mink.images.sbom":   test.wantSBOM,
+			} {
+				got, err := os.ReadFile(filepath.Join(results, result))
+				if err != nil {
+					t.Fatal("ReadFile() =", err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, wanted %q", result, got, want)
+				}
+			}
+		})
+	}
+}
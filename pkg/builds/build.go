@@ -19,6 +19,7 @@ package builds
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -37,16 +38,34 @@ func Run(ctx context.Context, image string, tr *tknv1beta1.TaskRun, opt *options
 		return name.Digest{}, err
 	}
 
+	var w io.Writer = io.Discard
+	if opt.Stream != nil && opt.Stream.Err != nil {
+		w = opt.Stream.Err
+	}
+	return imageDigest(image, tr, w)
+}
+
+// imageDigest returns the fully-qualified digest of the image that the
+// TaskRun produced, reporting the reference of its SBOM to w for tasks that
+// surface one (an empty reference means no SBOM was produced).
+func imageDigest(image string, tr *tknv1beta1.TaskRun, w io.Writer) (name.Digest, error) {
+	var digest string
 	for _, result := range tr.Status.TaskRunResults {
-		if result.Name != constants.ImageDigestResult {
-			continue
+		switch result.Name {
+		case constants.ImageDigestResult:
+			digest = strings.TrimSpace(result.Value)
+		case constants.ImageSBOMResult:
+			if sbom := strings.TrimSpace(result.Value); sbom != "" {
+				fmt.Fprintf(w, "SBOM: %s\n", sbom)
+			}
 		}
-		value := strings.TrimSpace(result.Value)
-
-		// Extract the constants.ImageDigestResult result.
-		return name.NewDigest(image + "@" + value)
 	}
-	return name.Digest{}, fmt.Errorf("taskrun did not produce an %q result", constants.ImageDigestResult)
+	if digest == "" {
+		return name.Digest{}, fmt.Errorf("taskrun did not produce an %q result", constants.ImageDigestResult)
+	}
+
+	// Extract the constants.ImageDigestResult result.
+	return name.NewDigest(image + "@" + digest)
 }
 
 func streamLogs(ctx context.Context, opt *options.LogOptions) error {
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+)
+
+func TestImageDigest(t *testing.T) {
+	const digest = "sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+
+	tests := []struct {
+		name    string
+		results []tknv1beta1.TaskRunResult
+		want    string
+		wantLog string
+		wantErr string
+	}{{
+		name: "digest",
+		results: []tknv1beta1.TaskRunResult{{
+			Name:  "dev.mink.images.digest",
+			Value: digest + "\n",
+		}},
+		want: "ghcr.io/mattmoor/image@" + digest,
+	}, {
+		name: "digest and sbom",
+		results: []tknv1beta1.TaskRunResult{{
+			Name:  "dev.mink.images.sbom",
+			Value: "ghcr.io/mattmoor/image:sha256-deadbeef.sbom",
+		}, {
+			Name:  "dev.mink.images.digest",
+			Value: digest,
+		}},
+		want:    "ghcr.io/mattmoor/image@" + digest,
+		wantLog: "SBOM: ghcr.io/mattmoor/image:sha256-deadbeef.sbom\n",
+	}, {
+		// The task produced no SBOM after all.
+		name: "digest and empty sbom",
+		results: []tknv1beta1.TaskRunResult{{
+			Name:  "dev.mink.images.digest",
+			Value: digest,
+		}, {
+			Name:  "dev.mink.images.sbom",
+			Value: "",
+		}},
+		want: "ghcr.io/mattmoor/image@" + digest,
+	}, {
+		name: "no digest",
+		results: []tknv1beta1.TaskRunResult{{
+			Name:  "dev.mink.images.sbom",
+			Value: "ghcr.io/mattmoor/image:sha256-deadbeef.sbom",
+		}},
+		wantErr: `did not produce an "dev.mink.images.digest" result`,
+	}, {
+		name: "bad digest",
+		results: []tknv1beta1.TaskRunResult{{
+			Name:  "dev.mink.images.digest",
+			Value: "deadbeef",
+		}},
+		wantErr: "deadbeef",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := &tknv1beta1.TaskRun{}
+			tr.Status.TaskRunResults = test.results
+
+			var log bytes.Buffer
+			got, err := imageDigest("ghcr.io/mattmoor/image", tr, &log)
+			switch {
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("imageDigest() = %v, wanted error containing %q", err, test.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal("imageDigest() =", err)
+			}
+			if got.String() != test.want {
+				t.Errorf("imageDigest() = %s, wanted %s", got, test.want)
+			}
+			if log.String() != test.wantLog {
+				t.Errorf("log = %q, wanted %q", log.String(), test.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Builder builds images from source bundles for references of the schemes
+// with which it is registered (e.g. jib:///path/to/module), so that mink
+// resolve and mink apply may build them.
+type Builder interface {
+	// Build builds the image that the reference u describes from the
+	// source bundle, publishes it to the tag of the options, and returns
+	// its digest.
+	Build(ctx context.Context, source name.Digest, u *url.URL, opt BuilderOptions) (name.Digest, error)
+}
+
+// BuilderFunc implements Builder with a function.
+type BuilderFunc func(ctx context.Context, source name.Digest, u *url.URL, opt BuilderOptions) (name.Digest, error)
+
+// Build implements Builder
+func (f BuilderFunc) Build(ctx context.Context, source name.Digest, u *url.URL, opt BuilderOptions) (name.Digest, error) {
+	return f(ctx, source, u, opt)
+}
+
+// KeyedBuilder is implemented by builders whose results are determined by
+// their inputs, so that mink may reuse the result of an identical build
+// (see --local-cache) instead of running it again.
+type KeyedBuilder interface {
+	Builder
+
+	// Key returns what, besides the source bundle, the reference u and the
+	// tag of the options, determines the result of the build of u: the
+	// configuration of the builder (e.g. its flags) and the definition of
+	// the task that it runs (including the images of its steps).  Both are
+	// serialized as JSON into the key of the build.
+	Key(ctx context.Context, u *url.URL, opt BuilderOptions) (config, task interface{}, err error)
+}
+
+// NamedBuilder is implemented by builders whose references name what they
+// build by their host alone (e.g. task://name), and so take no path.  The
+// host and path of references to aliases of their schemes (see the schemes
+// of .mink.yaml) are not appended to the reference of the alias.
+type NamedBuilder interface {
+	Builder
+
+	// Named distinguishes NamedBuilders from other builders.
+	Named()
+}
+
+// BuilderOptions holds the options of a build that are common to builders.
+type BuilderOptions struct {
+	// Tag is where to publish the image (see --image).
+	Tag name.Tag
+
+	// Namespace is the namespace in which to run the build.
+	Namespace string
+
+	// ServiceAccount is the name of the service account as which to run the
+	// build (see WithTaskServiceAccount).
+	ServiceAccount string
+
+	// CacheClaim is the name of the PersistentVolumeClaim in which to
+	// persist caches across builds, or empty (see AddCacheWorkspace).
+	CacheClaim string
+
+	// Output is where to write the logs of the build.
+	Output io.Writer
+}
+
+var (
+	buildersLock sync.RWMutex
+	builders     = make(map[string]Builder)
+)
+
+// Register registers the builder for references of the given scheme, and is
+// typically called from the init function of the package implementing it.
+// mink registers the builders that it provides (e.g. for ko://) the same
+// way, so their schemes are taken.  Register panics if the scheme is already
+// registered.
+func Register(scheme string, b Builder) {
+	buildersLock.Lock()
+	defer buildersLock.Unlock()
+
+	if _, ok := builders[scheme]; ok {
+		panic(fmt.Sprintf("builder for scheme %q registered twice", scheme))
+	}
+	builders[scheme] = b
+}
+
+// Lookup returns the builder registered for the given scheme, if any.
+func Lookup(scheme string) (Builder, bool) {
+	buildersLock.RLock()
+	defer buildersLock.RUnlock()
+
+	b, ok := builders[scheme]
+	return b, ok
+}
+
+// Schemes returns the schemes for which builders are registered, in order.
+func Schemes() []string {
+	buildersLock.RLock()
+	defer buildersLock.RUnlock()
+
+	schemes := make([]string, 0, len(builders))
+	for scheme := range builders {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
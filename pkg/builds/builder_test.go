@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// register registers the builder for the duration of the test.
+func register(t *testing.T, scheme string, b Builder) {
+	t.Helper()
+	Register(scheme, b)
+	t.Cleanup(func() {
+		buildersLock.Lock()
+		defer buildersLock.Unlock()
+		delete(builders, scheme)
+	})
+}
+
+func TestRegister(t *testing.T) {
+	digest, err := name.NewDigest("ghcr.io/mattmoor/image@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	var got *url.URL
+	register(t, "test-bazel", BuilderFunc(func(_ context.Context, _ name.Digest, u *url.URL, _ BuilderOptions) (name.Digest, error) {
+		got = u
+		return digest, nil
+	}))
+	register(t, "test-alpha", BuilderFunc(func(context.Context, name.Digest, *url.URL, BuilderOptions) (name.Digest, error) {
+		return name.Digest{}, nil
+	}))
+
+	b, ok := Lookup("test-bazel")
+	if !ok {
+		t.Fatal("Lookup(test-bazel) = false, wanted the registered builder")
+	}
+	u, _ := url.Parse("test-bazel://foo/bar")
+	if d, err := b.Build(context.Background(), name.Digest{}, u, BuilderOptions{}); err != nil {
+		t.Fatal("Build() =", err)
+	} else if d != digest {
+		t.Errorf("Build() = %s, wanted %s", d, digest)
+	}
+	if got != u {
+		t.Errorf("Build() got %v, wanted %v", got, u)
+	}
+
+	// Schemes are listed in order.
+	var schemes []string
+	for _, s := range Schemes() {
+		if s == "test-alpha" || s == "test-bazel" {
+			schemes = append(schemes, s)
+		}
+	}
+	if diff := cmp.Diff([]string{"test-alpha", "test-bazel"}, schemes); diff != "" {
+		t.Errorf("Schemes (-want, +got): %s", diff)
+	}
+}
+
+func TestLookupUnknown(t *testing.T) {
+	if b, ok := Lookup("test-unknown"); ok || b != nil {
+		t.Errorf("Lookup(test-unknown) = %v, %v, wanted no builder", b, ok)
+	}
+	for _, s := range Schemes() {
+		if s == "test-unknown" {
+			t.Error("Schemes() holds test-unknown")
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	b := BuilderFunc(func(context.Context, name.Digest, *url.URL, BuilderOptions) (name.Digest, error) {
+		return name.Digest{}, nil
+	})
+	register(t, "test-twice", b)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Register() didn't panic on a duplicate scheme")
+		}
+	}()
+	Register("test-twice", b)
+}
@@ -22,8 +22,10 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/constants"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"knative.dev/pkg/ptr"
 )
@@ -90,6 +92,32 @@ spec:
         Boson (Go): quay.io/boson/faas-go-builder
 
         For more information on builders, see: https://buildpacks.io/docs/concepts/components/builder/
+    - name: buildpacks
+      type: array
+      default: []
+      description: |
+        The buildpacks to run (in order) in place of the builder's default
+        order, each as --buildpack=ID[@VERSION].  The buildpacks must be
+        available in the builder, and when the version is omitted the latest
+        version in the builder is used.  These take precedence over any
+        [[build.buildpacks]] in the project descriptor.
+    - name: env
+      type: array
+      default: []
+      description: |
+        Build-time environment variables, each as --env=NAME=VALUE.  These
+        take precedence over any [[build.env]] in the project descriptor.
+    - name: run-image
+      default: ""
+      description: The run image on which to base the application image (defaults to the builder's).
+    - name: cache-image
+      default: ""
+      description: |
+        An image in which to persist the build cache between builds.  When
+        empty the cache is discarded after each build.
+    - name: process-type
+      default: ""
+      description: The default process type to set on the application image.
 
     # TODO(mattmoor): There is not a good way to support integer substitutions in tekton,
     # so we cannot practically make user-id and group-id parameters.
@@ -128,21 +156,53 @@ spec:
     - name: platform-setup
       image: ko://github.com/mattmoor/mink/cmd/platform-setup
       workingDir: /workspace
-      args: ["--descriptor=/workspace/$(params.descriptor)"]
+      args:
+        - "--descriptor=/workspace/$(params.descriptor)"
+        - "--app=/workspace"
+        - $(params.env)
+        - $(params.buildpacks)
       volumeMounts: *mounts
 
     - name: create
       image: $(params.builder)
       workingDir: /workspace
       imagePullPolicy: Always
-      command: ["/cnb/lifecycle/creator"]
+      command: ["/bin/sh", "-c"]
       args:
+        - |
+          # When buildpacks were requested, run them in place of the builder's order.
+          if [ -s /platform/mink/buildpacks ]; then
+            echo "[[order]]" > /tmp/order.toml
+            while read -r bp; do
+              id="${bp%%@*}"
+              version="${bp#*@}"
+              dir="/cnb/buildpacks/$(echo "${id}" | tr / _)"
+              if [ ! -d "${dir}" ]; then
+                echo "The buildpack ${id} is not available in the builder" >&2
+                exit 1
+              fi
+              if [ "${version}" = "${bp}" ]; then
+                version="$(ls "${dir}" | sort -V | tail -n 1)"
+              fi
+              if [ -z "${version}" ] || [ ! -d "${dir}/${version}" ]; then
+                echo "The buildpack ${id} has no version \"${version}\" in the builder" >&2
+                exit 1
+              fi
+              printf '[[order.group]]\nid = "%s"\nversion = "%s"\n' "${id}" "${version}" >> /tmp/order.toml
+            done < /platform/mink/buildpacks
+            set -- "-order=/tmp/order.toml" "$@"
+          fi
+          exec /cnb/lifecycle/creator "$@"
+        - creator
         - "-layers=/layers"
         - "-app=/workspace"
         - "-cache-dir=/cache"
         - "-platform=/platform"
         - "-uid=1000"
         - "-gid=1000"
+        - "-run-image=$(params.run-image)"
+        - "-cache-image=$(params.cache-image)"
+        - "-process-type=$(params.process-type)"
         - $(params["dev.mink.images.target"])
       env:
       - name: DOCKER_CONFIG
@@ -193,12 +253,42 @@ type Options struct {
 
 	// DescriptorFile holds the name of the project descriptor file (aka project.toml).
 	DescriptorFile string
+
+	// Buildpacks holds the buildpacks (as ID[@VERSION]) to run in place of
+	// the builder's default order.
+	Buildpacks []string
+
+	// Env holds build-time environment variables (as NAME=VALUE).
+	Env []string
+
+	// RunImage overrides the run image of the builder.
+	RunImage string
+
+	// CacheImage is the image in which to persist the build cache.
+	CacheImage string
+
+	// ProcessType is the default process type of the application image.
+	ProcessType string
+
+	// CacheClaim is the name of a PersistentVolumeClaim in which to persist
+	// the build cache across builds, when set, in place of the scratch
+	// space that is discarded after each build.
+	CacheClaim string
+}
+
+// prefixed returns the values, each prefixed by the given flag.
+func prefixed(flag string, values []string) []string {
+	args := make([]string, 0, len(values))
+	for _, v := range values {
+		args = append(args, flag+"="+v)
+	}
+	return args
 }
 
 // Build synthesizes a TaskRun definition that evaluates the buildpack lifecycle with the
 // given options over the provided source.
 func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) *tknv1beta1.TaskRun {
-	return &tknv1beta1.TaskRun{
+	tr := &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "buildpack-",
 		},
@@ -219,9 +309,52 @@ func Build(ctx context.Context, source name.Reference, target name.Tag, opt Opti
 			}, {
 				Name:  "descriptor",
 				Value: *tknv1beta1.NewArrayOrString(opt.DescriptorFile),
+			}, {
+				Name: "buildpacks",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: prefixed("--buildpack", opt.Buildpacks),
+				},
+			}, {
+				Name: "env",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: prefixed("--env", opt.Env),
+				},
+			}, {
+				Name:  "run-image",
+				Value: *tknv1beta1.NewArrayOrString(opt.RunImage),
+			}, {
+				Name:  "cache-image",
+				Value: *tknv1beta1.NewArrayOrString(opt.CacheImage),
+			}, {
+				Name:  "process-type",
+				Value: *tknv1beta1.NewArrayOrString(opt.ProcessType),
 			}},
 
 			TaskSpec: BuildpackTask.Spec.DeepCopy(),
 		},
 	}
+
+	if opt.CacheClaim != "" {
+		// Mount the cache workspace at /cache in place of the scratch space.
+		for i, step := range tr.Spec.TaskSpec.Steps {
+			mounts := make([]corev1.VolumeMount, 0, len(step.VolumeMounts))
+			for _, vm := range step.VolumeMounts {
+				if vm.Name != "empty-dir" {
+					mounts = append(mounts, vm)
+				}
+			}
+			tr.Spec.TaskSpec.Steps[i].VolumeMounts = mounts
+		}
+		volumes := make([]corev1.Volume, 0, len(tr.Spec.TaskSpec.Volumes))
+		for _, v := range tr.Spec.TaskSpec.Volumes {
+			if v.Name != "empty-dir" {
+				volumes = append(volumes, v)
+			}
+		}
+		tr.Spec.TaskSpec.Volumes = volumes
+		builds.AddCacheWorkspace(tr, opt.CacheClaim, "buildpacks")
+	}
+	return tr
 }
@@ -0,0 +1,260 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildpacks
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// buildTaskRun returns the TaskRun for a buildpack build with the given
+// options.
+func buildTaskRun(t *testing.T, opt Options) *tknv1beta1.TaskRun {
+	t.Helper()
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	target, err := name.NewTag("ghcr.io/mattmoor/image:latest")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+	return Build(context.Background(), source, target, opt)
+}
+
+func TestBuildParams(t *testing.T) {
+	tr := buildTaskRun(t, Options{
+		Builder:        BuildpackImage,
+		DescriptorFile: "app/project.toml",
+		Buildpacks:     []string{"paketo-buildpacks/go-dist@1.2.3", "paketo-buildpacks/go-build"},
+		Env:            []string{"BP_GO_TARGETS=./cmd/foo"},
+		RunImage:       "cgr.dev/chainguard/static",
+		CacheImage:     "ghcr.io/mattmoor/cache",
+		ProcessType:    "web",
+	})
+
+	got := make(map[string]tknv1beta1.ArrayOrString, len(tr.Spec.Params))
+	for _, p := range tr.Spec.Params {
+		got[p.Name] = p.Value
+	}
+	want := map[string]tknv1beta1.ArrayOrString{
+		"dev.mink.sources.bundle": *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"),
+		"dev.mink.images.target":  *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/image:latest"),
+		"builder":                 *tknv1beta1.NewArrayOrString(BuildpackImage),
+		"descriptor":              *tknv1beta1.NewArrayOrString("app/project.toml"),
+		"buildpacks": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--buildpack=paketo-buildpacks/go-dist@1.2.3", "--buildpack=paketo-buildpacks/go-build"},
+		},
+		"env": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--env=BP_GO_TARGETS=./cmd/foo"},
+		},
+		"run-image":    *tknv1beta1.NewArrayOrString("cgr.dev/chainguard/static"),
+		"cache-image":  *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/cache"),
+		"process-type": *tknv1beta1.NewArrayOrString("web"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Params (-want, +got): %s", diff)
+	}
+
+	// Every parameter is declared by the task.
+	for _, p := range tr.Spec.Params {
+		found := false
+		for _, ps := range tr.Spec.TaskSpec.Params {
+			found = found || ps.Name == p.Name
+		}
+		if !found {
+			t.Errorf("parameter %q is not declared by the task", p.Name)
+		}
+	}
+}
+
+// cacheMounts returns the mount paths of the /cache directory, keyed by the
+// names of the steps that mount it.
+func cacheMounts(tr *tknv1beta1.TaskRun) map[string]string {
+	got := map[string]string{}
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		for _, vm := range step.VolumeMounts {
+			if vm.MountPath == "/cache" {
+				got[step.Name] = vm.Name
+			}
+		}
+	}
+	return got
+}
+
+func TestBuildCache(t *testing.T) {
+	// Without a claim, the cache is scratch space.
+	tr := buildTaskRun(t, Options{Builder: BuildpackImage})
+	if diff := cmp.Diff(map[string]string{
+		"prepare":        "empty-dir",
+		"platform-setup": "empty-dir",
+		"create":         "empty-dir",
+		"extract-digest": "empty-dir",
+	}, cacheMounts(tr)); diff != "" {
+		t.Errorf("cache mounts (-want, +got): %s", diff)
+	}
+	if len(tr.Spec.Workspaces) != 0 || len(tr.Spec.TaskSpec.Workspaces) != 0 {
+		t.Errorf("Workspaces = %v, wanted none", tr.Spec.Workspaces)
+	}
+
+	// With a claim, the cache workspace takes the place of the scratch space.
+	tr = buildTaskRun(t, Options{Builder: BuildpackImage, CacheClaim: "mink-cache"})
+	if diff := cmp.Diff(map[string]string{}, cacheMounts(tr)); diff != "" {
+		t.Errorf("cache mounts (-want, +got): %s", diff)
+	}
+	for _, v := range tr.Spec.TaskSpec.Volumes {
+		if v.Name == "empty-dir" {
+			t.Error("Volumes still holds the scratch space")
+		}
+	}
+	if got, want := len(tr.Spec.TaskSpec.Volumes), len(BuildpackTask.Spec.Volumes)-1; got != want {
+		t.Errorf("len(Volumes) = %d, wanted %d", got, want)
+	}
+	wantDecl := []tknv1beta1.WorkspaceDeclaration{{
+		Name:        builds.CacheWorkspace,
+		Description: "A persistent cache, which is shared across builds.",
+		MountPath:   "/cache",
+	}}
+	if diff := cmp.Diff(wantDecl, tr.Spec.TaskSpec.Workspaces); diff != "" {
+		t.Errorf("TaskSpec.Workspaces (-want, +got): %s", diff)
+	}
+	wantBinding := []tknv1beta1.WorkspaceBinding{{
+		Name:                  builds.CacheWorkspace,
+		SubPath:               "buildpacks",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "mink-cache"},
+	}}
+	if diff := cmp.Diff(wantBinding, tr.Spec.Workspaces); diff != "" {
+		t.Errorf("Workspaces (-want, +got): %s", diff)
+	}
+
+	// The shared task definition is left untouched.
+	shared := &tknv1beta1.TaskRun{Spec: tknv1beta1.TaskRunSpec{TaskSpec: &BuildpackTask.Spec}}
+	if got := len(cacheMounts(shared)); got != 4 {
+		t.Errorf("BuildpackTask mounts /cache in %d steps, wanted 4", got)
+	}
+}
+
+func TestCreateScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is required:", err)
+	}
+	var script string
+	for _, step := range BuildpackTask.Spec.Steps {
+		if step.Name == "create" {
+			script = step.Args[0]
+		}
+	}
+	if script == "" {
+		t.Fatal("no create step")
+	}
+
+	tests := []struct {
+		name       string
+		buildpacks []string
+		want       string
+		wantErr    string
+	}{{
+		name: "builder order",
+		want: "",
+	}, {
+		name:       "latest version",
+		buildpacks: []string{"paketo-buildpacks/go-dist"},
+		want:       "[[order]]\n[[order.group]]\nid = \"paketo-buildpacks/go-dist\"\nversion = \"1.10.0\"\n",
+	}, {
+		name:       "explicit version",
+		buildpacks: []string{"paketo-buildpacks/go-dist@1.2.0", "paketo-buildpacks/go-build"},
+		want: "[[order]]\n[[order.group]]\nid = \"paketo-buildpacks/go-dist\"\nversion = \"1.2.0\"\n" +
+			"[[order.group]]\nid = \"paketo-buildpacks/go-build\"\nversion = \"2.0.0\"\n",
+	}, {
+		name:       "missing buildpack",
+		buildpacks: []string{"paketo-buildpacks/go-build", "paketo-buildpacks/nodejs"},
+		wantErr:    "The buildpack paketo-buildpacks/nodejs is not available in the builder",
+	}, {
+		name:       "missing version",
+		buildpacks: []string{"paketo-buildpacks/go-dist@3.0.0"},
+		wantErr:    `The buildpack paketo-buildpacks/go-dist has no version "3.0.0" in the builder`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Lay out the buildpacks of a fake builder, and point the script
+			// at them, its inputs and its outputs within a temporary directory.
+			dir := t.TempDir()
+			for _, bp := range []string{
+				"paketo-buildpacks_go-dist/1.2.0",
+				"paketo-buildpacks_go-dist/1.10.0",
+				"paketo-buildpacks_go-build/2.0.0",
+			} {
+				if err := os.MkdirAll(filepath.Join(dir, "cnb", "buildpacks", bp), os.ModePerm); err != nil {
+					t.Fatal("MkdirAll() =", err)
+				}
+			}
+			bps := filepath.Join(dir, "buildpacks")
+			if len(test.buildpacks) > 0 {
+				if err := os.WriteFile(bps, []byte(strings.Join(test.buildpacks, "\n")+"\n"), 0644); err != nil {
+					t.Fatal("WriteFile() =", err)
+				}
+			}
+			order := filepath.Join(dir, "order.toml")
+			s := strings.NewReplacer(
+				"/cnb/buildpacks", filepath.Join(dir, "cnb", "buildpacks"),
+				"/cnb/lifecycle/creator", "echo",
+				"/platform/mink/buildpacks", bps,
+				"/tmp/order.toml", order,
+			).Replace(script)
+
+			out, err := exec.Command(sh, "-c", s, "creator", "-layers=/layers").CombinedOutput()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(string(out), test.wantErr) {
+					t.Fatalf("script = %v, %q, wanted failure with %q", err, out, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("script failed: %v: %s", err, out)
+			}
+
+			wantArgs := "-layers=/layers\n"
+			if test.want != "" {
+				wantArgs = "-order=" + order + " " + wantArgs
+				got, err := os.ReadFile(order)
+				if err != nil {
+					t.Fatal("ReadFile() =", err)
+				}
+				if diff := cmp.Diff(test.want, string(got)); diff != "" {
+					t.Errorf("order.toml (-want, +got): %s", diff)
+				}
+			}
+			if got := string(out); got != wantArgs {
+				t.Errorf("creator args = %q, wanted %q", got, wantArgs)
+			}
+		})
+	}
+}
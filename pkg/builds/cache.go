@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+)
+
+const (
+	// CacheWorkspace is the name of the workspace through which builds
+	// mount a persistent cache (e.g. of Go modules or image layers).
+	CacheWorkspace = "cache"
+
+	// CacheMountPath is where the cache workspace is mounted.
+	CacheMountPath = "/cache"
+
+	// AutoCacheClaim is the name of the PersistentVolumeClaim that is used
+	// for the cache with --cache=auto.
+	AutoCacheClaim = "mink-build-cache"
+)
+
+// CacheSize is the size of the PersistentVolumeClaims created for caches.
+var CacheSize = resource.MustParse("10Gi")
+
+// AddCacheWorkspace declares the cache workspace on the TaskRun's embedded
+// TaskSpec, and binds it to the subPath of the named claim, so that builders
+// of different kinds may share a claim without sharing a cache.
+func AddCacheWorkspace(tr *tknv1beta1.TaskRun, claim, subPath string) {
+	tr.Spec.TaskSpec.Workspaces = append(tr.Spec.TaskSpec.Workspaces, tknv1beta1.WorkspaceDeclaration{
+		Name:        CacheWorkspace,
+		Description: "A persistent cache, which is shared across builds.",
+		MountPath:   CacheMountPath,
+	})
+	tr.Spec.Workspaces = append(tr.Spec.Workspaces, tknv1beta1.WorkspaceBinding{
+		Name:    CacheWorkspace,
+		SubPath: subPath,
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: claim,
+		},
+	})
+}
+
+// WithCacheClaim is used to create the PersistentVolumeClaim to which the
+// cache workspace of the TaskRun is bound (see AddCacheWorkspace), when it
+// doesn't already exist.  Unlike other temporary artifacts, the claim is
+// left behind, so that subsequent builds may use the cache.
+func WithCacheClaim(ctx context.Context) CancelableTaskOption {
+	client := kubeclient.Get(ctx)
+
+	return func(ctx context.Context, tr *tknv1beta1.TaskRun) (context.CancelFunc, error) {
+		for _, ws := range tr.Spec.Workspaces {
+			if ws.Name != CacheWorkspace || ws.PersistentVolumeClaim == nil {
+				continue
+			}
+			_, err := client.CoreV1().PersistentVolumeClaims(tr.Namespace).Create(ctx, &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ws.PersistentVolumeClaim.ClaimName,
+					Namespace: tr.Namespace,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceStorage: CacheSize,
+						},
+					},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil && !apierrs.IsAlreadyExists(err) {
+				return nil, err
+			}
+		}
+		return func() {}, nil
+	}
+}
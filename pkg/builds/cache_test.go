@@ -0,0 +1,176 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+)
+
+func TestAddCacheWorkspace(t *testing.T) {
+	tr := &tknv1beta1.TaskRun{
+		Spec: tknv1beta1.TaskRunSpec{
+			TaskSpec: &tknv1beta1.TaskSpec{},
+		},
+	}
+	AddCacheWorkspace(tr, "mink-cache", "ko")
+
+	wantDecl := []tknv1beta1.WorkspaceDeclaration{{
+		Name:        CacheWorkspace,
+		Description: "A persistent cache, which is shared across builds.",
+		MountPath:   CacheMountPath,
+	}}
+	if diff := cmp.Diff(wantDecl, tr.Spec.TaskSpec.Workspaces); diff != "" {
+		t.Errorf("TaskSpec.Workspaces (-want, +got): %s", diff)
+	}
+	wantBinding := []tknv1beta1.WorkspaceBinding{{
+		Name:                  CacheWorkspace,
+		SubPath:               "ko",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "mink-cache"},
+	}}
+	if diff := cmp.Diff(wantBinding, tr.Spec.Workspaces); diff != "" {
+		t.Errorf("Workspaces (-want, +got): %s", diff)
+	}
+}
+
+// fakeClaims serves the PersistentVolumeClaims API of a kubernetes API
+// server, recording the claims that are created.
+type fakeClaims struct {
+	m       sync.Mutex
+	created []corev1.PersistentVolumeClaim
+
+	// reason is the reason with which to fail creations, when set.
+	reason metav1.StatusReason
+	code   int
+}
+
+func (fc *fakeClaims) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fc.m.Lock()
+	defer fc.m.Unlock()
+
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/builds/persistentvolumeclaims" {
+		http.Error(w, "unexpected request: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	var pvc corev1.PersistentVolumeClaim
+	if err := json.NewDecoder(r.Body).Decode(&pvc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if fc.reason != "" {
+		w.WriteHeader(fc.code)
+		json.NewEncoder(w).Encode(&metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure,
+			Code:     int32(fc.code),
+			Reason:   fc.reason,
+		})
+		return
+	}
+	fc.created = append(fc.created, pvc)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&pvc)
+}
+
+func TestWithCacheClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   string
+		reason  metav1.StatusReason
+		code    int
+		want    []string
+		wantErr bool
+	}{{
+		name: "no cache",
+	}, {
+		name:  "created",
+		claim: "mink-cache",
+		want:  []string{"mink-cache"},
+	}, {
+		name:   "already exists",
+		claim:  "mink-cache",
+		reason: metav1.StatusReasonAlreadyExists,
+		code:   http.StatusConflict,
+	}, {
+		name:    "forbidden",
+		claim:   "mink-cache",
+		reason:  metav1.StatusReasonForbidden,
+		code:    http.StatusForbidden,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fc := &fakeClaims{reason: test.reason, code: test.code}
+			s := httptest.NewServer(fc)
+			defer s.Close()
+			ctx := context.WithValue(context.Background(), kubeclient.Key{},
+				kubernetes.NewForConfigOrDie(&rest.Config{Host: s.URL}))
+
+			tr := &tknv1beta1.TaskRun{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "builds"},
+				Spec: tknv1beta1.TaskRunSpec{
+					TaskSpec: &tknv1beta1.TaskSpec{},
+				},
+			}
+			if test.claim != "" {
+				AddCacheWorkspace(tr, test.claim, "ko")
+			}
+
+			cancel, err := WithCacheClaim(ctx)(ctx, tr)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("WithCacheClaim() = nil, wanted error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("WithCacheClaim() =", err)
+			}
+			// The claim outlives the build.
+			cancel()
+
+			var got []string
+			for _, pvc := range fc.created {
+				got = append(got, pvc.Name)
+				if diff := cmp.Diff([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, pvc.Spec.AccessModes); diff != "" {
+					t.Errorf("AccessModes (-want, +got): %s", diff)
+				}
+				if got := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(CacheSize) != 0 {
+					t.Errorf("storage = %s, wanted %s", got.String(), CacheSize.String())
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("created (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -18,12 +18,19 @@ package dockerfile
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/constants"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/ptr"
 )
 
@@ -36,7 +43,11 @@ kind: Task
 metadata:
   name: kaniko
 spec:
-  description: "An example kaniko task illustrating some of the parameter processing."
+  description: |
+    An example kaniko task illustrating some of the parameter processing.
+
+    Secrets for RUN --mount=type=secret,id=ID are read from /run/secrets/ID,
+    where mink mounts them from Kubernetes Secrets (see --build-secret).
   params:
     - name: dev.mink.sources.bundle
       description: A self-extracting container image of source
@@ -48,6 +59,23 @@ spec:
     - name: dockerfile
       description: The name of the dockerfile.
       default: Dockerfile
+    - name: target
+      description: The stage of the dockerfile to build, by default the last.
+      default: ""
+    - name: build-args
+      description: Build-time variables, each of the form --build-arg=KEY=VALUE.
+      type: array
+      default: []
+    - name: labels
+      description: Labels to add to the image, each of the form --label=KEY=VALUE.
+      type: array
+      default: []
+    - name: cache
+      description: Whether to cache the layers of RUN commands in cache-repo.
+      default: "true"
+    - name: cache-repo
+      description: The repository in which to cache layers, by default the repository of dev.mink.images.target.
+      default: ""
     - name: kaniko-args
       description: Extra arguments to supply to kaniko
       type: array
@@ -63,7 +91,7 @@ spec:
       workingDir: /workspace
 
     - name: build-and-push
-      image: gcr.io/kaniko-project/executor:v1.6.0
+      image: ` + ExecutorImage + `
       workingDir: /workspace
       env:
       - name: DOCKER_CONFIG
@@ -73,8 +101,12 @@ spec:
       - --context=/workspace
       - --destination=$(params["dev.mink.images.target"])
       - --digest-file=/tekton/results/dev.mink.images.digest
-      - --cache=true
+      - --target=$(params.target)
+      - --cache=$(params.cache)
       - --cache-ttl=24h
+      - --cache-repo=$(params.cache-repo)
+      - $(params.build-args)
+      - $(params.labels)
       - $(params.kaniko-args)
 `
 	// KanikoTask is the parsed form of KanikoTaskString.
@@ -87,19 +119,147 @@ func init() {
 	}
 }
 
+// KanikoVersion is the release of kaniko with which builds run.  The
+// executor and the warmer share it, since the warmer writes the cache of base
+// images that the executor reads.
+const KanikoVersion = "v1.9.1"
+
+const (
+	// ExecutorImage is the image of the kaniko executor, which performs
+	// the builds.
+	ExecutorImage = "gcr.io/kaniko-project/executor:" + KanikoVersion
+
+	// WarmerImage is the image of the kaniko warmer, with which base images
+	// are cached ahead of builds that persist their caches.
+	WarmerImage = "gcr.io/kaniko-project/warmer:" + KanikoVersion
+)
+
+// SecretsPath is the directory in which build secrets are mounted, which is
+// where RUN --mount=type=secret reads them by default.
+const SecretsPath = "/run/secrets"
+
+// Secret is a build secret that is mounted from a Kubernetes Secret.
+type Secret struct {
+	// ID is the id of the secret within the build (as in
+	// RUN --mount=type=secret,id=ID), which is mounted at SecretsPath/ID.
+	ID string
+
+	// Name is the name of the Kubernetes Secret holding the secret.
+	Name string
+
+	// Key is the key within the Kubernetes Secret holding the secret.
+	Key string
+}
+
+// ParseSecret parses a build secret of the form ID=NAME[:KEY], where KEY
+// defaults to ID.
+func ParseSecret(s string) (Secret, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Secret{}, fmt.Errorf("%q is not of the form ID=NAME[:KEY]", s)
+	}
+	secret := Secret{ID: parts[0], Name: parts[1], Key: parts[0]}
+	if i := strings.Index(secret.Name, ":"); i >= 0 {
+		secret.Name, secret.Key = secret.Name[:i], secret.Name[i+1:]
+	}
+	if errs := validation.IsConfigMapKey(secret.ID); len(errs) > 0 {
+		return Secret{}, fmt.Errorf("%q: invalid secret id: %s", s, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(secret.Name); len(errs) > 0 {
+		return Secret{}, fmt.Errorf("%q: invalid secret name: %s", s, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsConfigMapKey(secret.Key); len(errs) > 0 {
+		return Secret{}, fmt.Errorf("%q: invalid secret key: %s", s, strings.Join(errs, ", "))
+	}
+	return secret, nil
+}
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	if s.Key == s.ID {
+		return s.ID + "=" + s.Name
+	}
+	return s.ID + "=" + s.Name + ":" + s.Key
+}
+
 // Options holds configuration options specific to Dockerfile builds
 type Options struct {
 	// Dockerfile is the path to the Dockerfile within the build context.
 	Dockerfile string
 
+	// Target is the stage of the Dockerfile to build, by default the last.
+	Target string
+
+	// BuildArgs holds the build-time variables, each of the form KEY=VALUE.
+	BuildArgs []string
+
+	// Labels holds the labels to add to the image, each of the form KEY=VALUE.
+	Labels []string
+
+	// CacheRepo is the repository in which to cache layers, by default the
+	// repository of the target.
+	CacheRepo string
+
+	// NoCache disables the caching of layers.
+	NoCache bool
+
+	// CacheClaim is the name of a PersistentVolumeClaim in which to cache
+	// base images across builds, when set.
+	CacheClaim string
+
+	// Secrets are mounted for use by RUN --mount=type=secret.
+	Secrets []Secret
+
+	// Platform is the platform for which to build, when set, which the build
+	// targets by running on a node of that operating system and architecture.
+	Platform *v1.Platform
+
 	// The extra kaniko arguments for handling things like insecure registries
 	KanikoArgs []string
 }
 
+// addBaseImageCache has kaniko read the base images of the build from the
+// cache workspace, which a step before the build fills with those of the
+// Dockerfile that it doesn't yet hold.  The layers of RUN commands are still
+// cached in cache-repo, since kaniko only caches layers in a registry.
+func addBaseImageCache(tr *tknv1beta1.TaskRun) {
+	steps := make([]tknv1beta1.Step, 0, len(tr.Spec.TaskSpec.Steps)+1)
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		if step.Name == "build-and-push" {
+			steps = append(steps, tknv1beta1.Step{
+				Container: corev1.Container{
+					Name:       "warm-cache",
+					Image:      WarmerImage,
+					WorkingDir: "/workspace",
+					Env:        append([]corev1.EnvVar(nil), step.Env...),
+					Args: []string{
+						"--cache-dir=" + builds.CacheMountPath,
+						"--cache-ttl=24h",
+						"--dockerfile=/workspace/$(params.path)/$(params.dockerfile)",
+						"$(params.build-args)",
+					},
+				},
+			})
+			step.Args = append(step.Args, "--cache-dir="+builds.CacheMountPath)
+		}
+		steps = append(steps, step)
+	}
+	tr.Spec.TaskSpec.Steps = steps
+}
+
+// prefixed returns the values, each prefixed by the given flag.
+func prefixed(flag string, values []string) []string {
+	args := make([]string, 0, len(values))
+	for _, v := range values {
+		args = append(args, flag+"="+v)
+	}
+	return args
+}
+
 // Build returns a TaskRun suitable for performing a Dockerfile build over the
 // provided source and publishing to the target tag.
 func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) *tknv1beta1.TaskRun {
-	return &tknv1beta1.TaskRun{
+	tr := &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "dockerfile-",
 		},
@@ -117,6 +277,27 @@ func Build(ctx context.Context, source name.Reference, target name.Tag, opt Opti
 			}, {
 				Name:  "dockerfile",
 				Value: *tknv1beta1.NewArrayOrString(opt.Dockerfile),
+			}, {
+				Name:  "target",
+				Value: *tknv1beta1.NewArrayOrString(opt.Target),
+			}, {
+				Name: "build-args",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: prefixed("--build-arg", opt.BuildArgs),
+				},
+			}, {
+				Name: "labels",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: prefixed("--label", opt.Labels),
+				},
+			}, {
+				Name:  "cache",
+				Value: *tknv1beta1.NewArrayOrString(strconv.FormatBool(!opt.NoCache)),
+			}, {
+				Name:  "cache-repo",
+				Value: *tknv1beta1.NewArrayOrString(opt.CacheRepo),
 			}, {
 				Name: "kaniko-args",
 				Value: tknv1beta1.ArrayOrString{
@@ -126,4 +307,53 @@ func Build(ctx context.Context, source name.Reference, target name.Tag, opt Opti
 			}},
 		},
 	}
+
+	if opt.CacheClaim != "" {
+		addBaseImageCache(tr)
+		builds.AddCacheWorkspace(tr, opt.CacheClaim, "kaniko")
+	}
+
+	if opt.Platform != nil {
+		tr.Spec.PodTemplate.NodeSelector = map[string]string{
+			corev1.LabelOSStable:   opt.Platform.OS,
+			corev1.LabelArchStable: opt.Platform.Architecture,
+		}
+	}
+
+	if len(opt.Secrets) > 0 {
+		// Project the secrets into a single volume, and mount it where
+		// RUN --mount=type=secret expects to find them.
+		sources := make([]corev1.VolumeProjection, 0, len(opt.Secrets))
+		for _, secret := range opt.Secrets {
+			sources = append(sources, corev1.VolumeProjection{
+				Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: secret.Name,
+					},
+					Items: []corev1.KeyToPath{{
+						Key:  secret.Key,
+						Path: secret.ID,
+					}},
+				},
+			})
+		}
+		tr.Spec.TaskSpec.Volumes = append(tr.Spec.TaskSpec.Volumes, corev1.Volume{
+			Name: "build-secrets",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: sources,
+				},
+			},
+		})
+		for i, step := range tr.Spec.TaskSpec.Steps {
+			if step.Name == "build-and-push" {
+				tr.Spec.TaskSpec.Steps[i].VolumeMounts = append(step.VolumeMounts, corev1.VolumeMount{
+					Name:      "build-secrets",
+					MountPath: SecretsPath,
+					ReadOnly:  true,
+				})
+			}
+		}
+	}
+	return tr
 }
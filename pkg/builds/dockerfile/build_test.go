@@ -0,0 +1,363 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/builds"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Secret
+		wantErr string
+	}{{
+		name:  "key defaults to id",
+		input: "npmrc=npm-creds",
+		want:  Secret{ID: "npmrc", Name: "npm-creds", Key: "npmrc"},
+	}, {
+		name:  "explicit key",
+		input: "npmrc=npm-creds:.npmrc",
+		want:  Secret{ID: "npmrc", Name: "npm-creds", Key: ".npmrc"},
+	}, {
+		name:    "missing name",
+		input:   "npmrc",
+		wantErr: "not of the form ID=NAME[:KEY]",
+	}, {
+		name:    "empty id",
+		input:   "=npm-creds",
+		wantErr: "not of the form ID=NAME[:KEY]",
+	}, {
+		name:    "empty name",
+		input:   "npmrc=",
+		wantErr: "not of the form ID=NAME[:KEY]",
+	}, {
+		name:    "invalid id",
+		input:   "npm/rc=npm-creds",
+		wantErr: "invalid secret id",
+	}, {
+		name:    "invalid name",
+		input:   "npmrc=NPM_Creds",
+		wantErr: "invalid secret name",
+	}, {
+		name:    "empty name with key",
+		input:   "npmrc=:key",
+		wantErr: "invalid secret name",
+	}, {
+		name:    "empty key",
+		input:   "npmrc=npm-creds:",
+		wantErr: "invalid secret key",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseSecret(test.input)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("ParseSecret() = %v, wanted error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("ParseSecret() =", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ParseSecret (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestSecretString(t *testing.T) {
+	tests := []struct {
+		secret Secret
+		want   string
+	}{{
+		secret: Secret{ID: "npmrc", Name: "npm-creds", Key: "npmrc"},
+		want:   "npmrc=npm-creds",
+	}, {
+		secret: Secret{ID: "npmrc", Name: "npm-creds", Key: ".npmrc"},
+		want:   "npmrc=npm-creds:.npmrc",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := test.secret.String(); got != test.want {
+				t.Errorf("String() = %q, wanted %q", got, test.want)
+			}
+			// The result parses back into the same secret.
+			got, err := ParseSecret(test.secret.String())
+			if err != nil {
+				t.Fatal("ParseSecret() =", err)
+			}
+			if diff := cmp.Diff(test.secret, got); diff != "" {
+				t.Errorf("ParseSecret (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+// buildTaskRun returns the TaskRun for a Dockerfile build with the given
+// options.
+func buildTaskRun(t *testing.T, opt Options) *tknv1beta1.TaskRun {
+	t.Helper()
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	target, err := name.NewTag("ghcr.io/mattmoor/image:latest")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+	return Build(context.Background(), source, target, opt)
+}
+
+// param returns the value of the named parameter of the TaskRun.
+func param(t *testing.T, tr *tknv1beta1.TaskRun, name string) tknv1beta1.ArrayOrString {
+	t.Helper()
+	for _, p := range tr.Spec.Params {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	t.Fatalf("no parameter %q", name)
+	return tknv1beta1.ArrayOrString{}
+}
+
+// buildStep returns the step of the TaskRun that runs kaniko.
+func buildStep(t *testing.T, tr *tknv1beta1.TaskRun) tknv1beta1.Step {
+	t.Helper()
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		if step.Name == "build-and-push" {
+			return step
+		}
+	}
+	t.Fatal("no build-and-push step")
+	return tknv1beta1.Step{}
+}
+
+func TestBuildParams(t *testing.T) {
+	tr := buildTaskRun(t, Options{
+		Dockerfile: "app/Dockerfile",
+		Target:     "prod",
+		BuildArgs:  []string{"VERSION=1.2.3", "EMPTY="},
+		Labels:     []string{"team=web"},
+		CacheRepo:  "ghcr.io/mattmoor/cache",
+		NoCache:    true,
+	})
+
+	for name, want := range map[string]tknv1beta1.ArrayOrString{
+		"dockerfile": *tknv1beta1.NewArrayOrString("app/Dockerfile"),
+		"target":     *tknv1beta1.NewArrayOrString("prod"),
+		"build-args": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--build-arg=VERSION=1.2.3", "--build-arg=EMPTY="},
+		},
+		"labels": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"--label=team=web"},
+		},
+		"cache":      *tknv1beta1.NewArrayOrString("false"),
+		"cache-repo": *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/cache"),
+	} {
+		if diff := cmp.Diff(want, param(t, tr, name)); diff != "" {
+			t.Errorf("%s (-want, +got): %s", name, diff)
+		}
+	}
+
+	// Without secrets, nothing is mounted.
+	if got := tr.Spec.TaskSpec.Volumes; len(got) != 0 {
+		t.Errorf("Volumes = %v, wanted none", got)
+	}
+	for _, vm := range buildStep(t, tr).VolumeMounts {
+		if vm.MountPath == SecretsPath {
+			t.Errorf("VolumeMounts = %v, wanted nothing at %s", vm, SecretsPath)
+		}
+	}
+}
+
+func TestBuildSecrets(t *testing.T) {
+	tr := buildTaskRun(t, Options{
+		Dockerfile: "Dockerfile",
+		Secrets: []Secret{
+			{ID: "npmrc", Name: "npm-creds", Key: "npmrc"},
+			{ID: "token", Name: "gh-creds", Key: "GITHUB_TOKEN"},
+		},
+	})
+
+	wantVolume := corev1.Volume{
+		Name: "build-secrets",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "npm-creds"},
+						Items:                []corev1.KeyToPath{{Key: "npmrc", Path: "npmrc"}},
+					},
+				}, {
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "gh-creds"},
+						Items:                []corev1.KeyToPath{{Key: "GITHUB_TOKEN", Path: "token"}},
+					},
+				}},
+			},
+		},
+	}
+	if diff := cmp.Diff([]corev1.Volume{wantVolume}, tr.Spec.TaskSpec.Volumes); diff != "" {
+		t.Errorf("Volumes (-want, +got): %s", diff)
+	}
+
+	wantMount := corev1.VolumeMount{
+		Name:      "build-secrets",
+		MountPath: SecretsPath,
+		ReadOnly:  true,
+	}
+	var got []corev1.VolumeMount
+	for _, vm := range buildStep(t, tr).VolumeMounts {
+		if vm.Name == "build-secrets" {
+			got = append(got, vm)
+		}
+	}
+	if diff := cmp.Diff([]corev1.VolumeMount{wantMount}, got); diff != "" {
+		t.Errorf("VolumeMounts (-want, +got): %s", diff)
+	}
+
+	// Only the kaniko step sees the secrets.
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		if step.Name == "build-and-push" {
+			continue
+		}
+		for _, vm := range step.VolumeMounts {
+			if vm.Name == "build-secrets" {
+				t.Errorf("step %s mounts the build secrets", step.Name)
+			}
+		}
+	}
+
+	// The shared task definition is left untouched.
+	if got := KanikoTask.Spec.Volumes; len(got) != 0 {
+		t.Errorf("KanikoTask.Spec.Volumes = %v, wanted none", got)
+	}
+}
+
+func TestBuildPlatform(t *testing.T) {
+	tr := buildTaskRun(t, Options{
+		Dockerfile: "Dockerfile",
+		Platform:   &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	})
+	want := map[string]string{
+		corev1.LabelOSStable:   "linux",
+		corev1.LabelArchStable: "arm64",
+	}
+	if diff := cmp.Diff(want, tr.Spec.PodTemplate.NodeSelector); diff != "" {
+		t.Errorf("NodeSelector (-want, +got): %s", diff)
+	}
+
+	// Without a platform, the build may run on any node.
+	if got := buildTaskRun(t, Options{Dockerfile: "Dockerfile"}).Spec.PodTemplate.NodeSelector; got != nil {
+		t.Errorf("NodeSelector = %v, wanted none", got)
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	// Without a claim, kaniko caches nothing locally.
+	tr := buildTaskRun(t, Options{Dockerfile: "Dockerfile"})
+	for _, arg := range buildStep(t, tr).Args {
+		if strings.HasPrefix(arg, "--cache-dir=") {
+			t.Errorf("Args holds %s, wanted no cache directory", arg)
+		}
+	}
+	if got, want := len(tr.Spec.TaskSpec.Steps), len(KanikoTask.Spec.Steps); got != want {
+		t.Errorf("len(Steps) = %d, wanted %d", got, want)
+	}
+
+	// With a claim, base images are warmed into the cache workspace ahead
+	// of the build, which reads them from there.
+	tr = buildTaskRun(t, Options{Dockerfile: "Dockerfile", CacheClaim: "mink-cache"})
+	var names []string
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		names = append(names, step.Name)
+	}
+	if diff := cmp.Diff([]string{"extract-bundle", "warm-cache", "build-and-push"}, names); diff != "" {
+		t.Errorf("Steps (-want, +got): %s", diff)
+	}
+	warm := tr.Spec.TaskSpec.Steps[1]
+	if warm.Image != WarmerImage {
+		t.Errorf("Image = %s, wanted %s", warm.Image, WarmerImage)
+	}
+	wantArgs := []string{
+		"--cache-dir=/cache",
+		"--cache-ttl=24h",
+		"--dockerfile=/workspace/$(params.path)/$(params.dockerfile)",
+		"$(params.build-args)",
+	}
+	if diff := cmp.Diff(wantArgs, warm.Args); diff != "" {
+		t.Errorf("warm-cache Args (-want, +got): %s", diff)
+	}
+	build := buildStep(t, tr)
+	if got := build.Args[len(build.Args)-1]; got != "--cache-dir=/cache" {
+		t.Errorf("build-and-push Args ends with %s, wanted --cache-dir=/cache", got)
+	}
+
+	// The cache is written and read by the same release of kaniko.
+	for _, img := range []string{warm.Image, build.Image} {
+		if !strings.HasSuffix(img, ":"+KanikoVersion) {
+			t.Errorf("Image = %s, wanted kaniko %s", img, KanikoVersion)
+		}
+	}
+
+	// The steps don't share their environment.
+	if diff := cmp.Diff(build.Env, warm.Env); diff != "" {
+		t.Errorf("warm-cache Env (-want, +got): %s", diff)
+	}
+	warm.Env[0].Value = "changed"
+	if got := buildStep(t, tr).Env[0].Value; got == "changed" {
+		t.Error("changing the warm-cache Env changed the build-and-push Env")
+	}
+
+	// Layers are still cached in the registry.
+	if got := param(t, tr, "cache-repo").StringVal; got != "" {
+		t.Errorf("cache-repo = %q, wanted the default", got)
+	}
+	wantBinding := []tknv1beta1.WorkspaceBinding{{
+		Name:                  builds.CacheWorkspace,
+		SubPath:               "kaniko",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "mink-cache"},
+	}}
+	if diff := cmp.Diff(wantBinding, tr.Spec.Workspaces); diff != "" {
+		t.Errorf("Workspaces (-want, +got): %s", diff)
+	}
+
+	// The shared task definition is left untouched.
+	for _, step := range KanikoTask.Spec.Steps {
+		for _, arg := range step.Args {
+			if strings.HasPrefix(arg, "--cache-dir=") {
+				t.Errorf("KanikoTask step %s holds %s", step.Name, arg)
+			}
+		}
+	}
+}
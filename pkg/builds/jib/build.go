@@ -0,0 +1,245 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jib
+
+import (
+	"context"
+	"path"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/constants"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+)
+
+// Tools holds the build tools with which Jib may run, where auto picks the
+// one the project uses.
+var Tools = []string{"auto", "maven", "gradle"}
+
+var (
+	// JibTaskString holds the raw definition of the Jib task.
+	// We export this into ./examples/jib.yaml
+	JibTaskString = `
+apiVersion: tekton.dev/v1beta1
+kind: Task
+metadata:
+  name: jib
+spec:
+  description: |
+    An example Jib task, which builds Java projects with Maven or Gradle.
+
+    Projects that use neither the Jib Maven plugin nor the Jib Gradle plugin
+    are built with the jib-version of the plugin.  The Maven or Gradle
+    wrapper of the project (mvnw or gradlew) is used when there is one.
+  params:
+    - name: dev.mink.sources.bundle
+      description: A self-extracting container image of source
+    - name: dev.mink.images.target
+      description: Where to publish an image.
+    - name: path
+      description: The path to the project (holding the pom.xml or build.gradle).
+      default: .
+    - name: tool
+      description: The build tool to use, one of auto, maven or gradle.
+      default: auto
+    - name: jib-version
+      description: The version of the Jib plugins to use when the project doesn't.
+      default: "3.2.1"
+    - name: maven-image
+      description: The image with which to run Maven.
+      default: docker.io/library/maven:3-eclipse-temurin-17
+    - name: gradle-image
+      description: The image with which to run Gradle.
+      default: docker.io/library/gradle:7-jdk17
+    - name: jib-args
+      description: Extra arguments to supply to Maven or Gradle.
+      type: array
+      default: []
+
+  results:
+    - name: dev.mink.images.digest
+      description: The digest of the resulting image.
+    - name: tool
+      description: The build tool with which the project was built.
+
+  steps:
+    - name: extract-bundle
+      image: $(params["dev.mink.sources.bundle"])
+      workingDir: /workspace
+
+    - name: detect
+      image: gcr.io/distroless/base:debug
+      workingDir: /workspace/$(params.path)
+      script: |
+        #!/busybox/sh
+        set -o errexit
+        tool="$(params.tool)"
+        if [ "${tool}" = "auto" ]; then
+          if [ -f build.gradle ] || [ -f build.gradle.kts ]; then
+            tool=gradle
+          elif [ -f pom.xml ]; then
+            tool=maven
+          else
+            echo "found neither a pom.xml nor a build.gradle in $(params.path)" >&2
+            exit 1
+          fi
+        fi
+        echo -n "${tool}" > /tekton/results/tool
+
+    - name: maven
+      image: $(params.maven-image)
+      workingDir: /workspace/$(params.path)
+      env:
+      - name: DOCKER_CONFIG
+        value: /tekton/home/.docker
+      args:
+      - $(params.jib-args)
+      script: |
+        #!/usr/bin/env bash
+        set -o errexit
+        if [ "$(cat /tekton/results/tool)" != "maven" ]; then
+          exit 0
+        fi
+        MVN=mvn
+        if [ -x ./mvnw ]; then
+          MVN=./mvnw
+        fi
+        "${MVN}" --batch-mode compile \
+          "com.google.cloud.tools:jib-maven-plugin:$(params.jib-version):build" \
+          "-Djib.to.image=$(params["dev.mink.images.target"])" \
+          "-Djib.outputPaths.digest=/tekton/results/dev.mink.images.digest" \
+          "$@"
+
+    - name: gradle
+      image: $(params.gradle-image)
+      workingDir: /workspace/$(params.path)
+      env:
+      - name: DOCKER_CONFIG
+        value: /tekton/home/.docker
+      args:
+      - $(params.jib-args)
+      script: |
+        #!/usr/bin/env bash
+        set -o errexit
+        if [ "$(cat /tekton/results/tool)" != "gradle" ]; then
+          exit 0
+        fi
+        GRADLE=gradle
+        if [ -x ./gradlew ]; then
+          GRADLE=./gradlew
+        fi
+        # Apply the Jib plugin, unless the project already does.
+        if ! grep -qs com.google.cloud.tools.jib build.gradle build.gradle.kts; then
+          cat > /tmp/jib.gradle <<EOF
+        initscript {
+          repositories { gradlePluginPortal() }
+          dependencies { classpath "com.google.cloud.tools:jib-gradle-plugin:$(params.jib-version)" }
+        }
+        rootProject {
+          plugins.withId("java") { apply plugin: com.google.cloud.tools.jib.gradle.JibPlugin }
+        }
+        EOF
+          set -- --init-script /tmp/jib.gradle "$@"
+        fi
+        "${GRADLE}" --no-daemon jib \
+          "-Djib.to.image=$(params["dev.mink.images.target"])" \
+          "-Djib.outputPaths.digest=/tekton/results/dev.mink.images.digest" \
+          "$@"
+`
+	// JibTask is the parsed form of JibTaskString.
+	JibTask tknv1beta1.Task
+)
+
+func init() {
+	if err := yaml.Unmarshal([]byte(JibTaskString), &JibTask); err != nil {
+		panic(err)
+	}
+}
+
+// Options holds configuration options specific to Jib builds
+type Options struct {
+	// Path is the directory within the bundle holding the project.
+	Path string
+
+	// Tool is the build tool with which to run Jib (see Tools).
+	Tool string
+
+	// JibArgs holds extra arguments for Maven or Gradle.
+	JibArgs []string
+
+	// CacheClaim is the name of a PersistentVolumeClaim in which to persist
+	// the dependencies that Maven or Gradle download across builds, when set.
+	CacheClaim string
+}
+
+// Build returns a TaskRun suitable for performing a Jib build over the
+// provided source and publishing to the target tag.
+func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) *tknv1beta1.TaskRun {
+	tr := &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "jib-",
+		},
+		Spec: tknv1beta1.TaskRunSpec{
+			PodTemplate: &tknv1beta1.PodTemplate{
+				EnableServiceLinks: ptr.Bool(false),
+			},
+			TaskSpec: JibTask.Spec.DeepCopy(),
+			Params: []tknv1beta1.Param{{
+				Name:  constants.SourceBundleParam,
+				Value: *tknv1beta1.NewArrayOrString(source.String()),
+			}, {
+				Name:  constants.ImageTargetParam,
+				Value: *tknv1beta1.NewArrayOrString(target.String()),
+			}, {
+				Name:  "path",
+				Value: *tknv1beta1.NewArrayOrString(opt.Path),
+			}, {
+				Name:  "tool",
+				Value: *tknv1beta1.NewArrayOrString(opt.Tool),
+			}, {
+				Name: "jib-args",
+				Value: tknv1beta1.ArrayOrString{
+					Type:     tknv1beta1.ParamTypeArray,
+					ArrayVal: opt.JibArgs,
+				},
+			}},
+		},
+	}
+
+	if opt.CacheClaim != "" {
+		for i, step := range tr.Spec.TaskSpec.Steps {
+			switch step.Name {
+			case "maven":
+				tr.Spec.TaskSpec.Steps[i].Env = append(step.Env, corev1.EnvVar{
+					Name:  "MAVEN_OPTS",
+					Value: "-Dmaven.repo.local=" + path.Join(builds.CacheMountPath, "m2"),
+				})
+			case "gradle":
+				tr.Spec.TaskSpec.Steps[i].Env = append(step.Env, corev1.EnvVar{
+					Name:  "GRADLE_USER_HOME",
+					Value: path.Join(builds.CacheMountPath, "gradle"),
+				})
+			}
+		}
+		builds.AddCacheWorkspace(tr, opt.CacheClaim, "jib")
+	}
+	return tr
+}
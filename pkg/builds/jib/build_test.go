@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// buildTaskRun returns the TaskRun for a Jib build with the given options.
+func buildTaskRun(t *testing.T, opt Options) *tknv1beta1.TaskRun {
+	t.Helper()
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	target, err := name.NewTag("ghcr.io/mattmoor/image:latest")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+	return Build(context.Background(), source, target, opt)
+}
+
+// stepEnv returns the environment of each of the steps of the TaskRun.
+func stepEnv(tr *tknv1beta1.TaskRun) map[string][]corev1.EnvVar {
+	got := make(map[string][]corev1.EnvVar, len(tr.Spec.TaskSpec.Steps))
+	for _, step := range tr.Spec.TaskSpec.Steps {
+		got[step.Name] = step.Env
+	}
+	return got
+}
+
+func TestBuildParams(t *testing.T) {
+	tr := buildTaskRun(t, Options{
+		Path:    "services/api",
+		Tool:    "gradle",
+		JibArgs: []string{"-PskipTests", "--offline"},
+	})
+
+	got := make(map[string]tknv1beta1.ArrayOrString, len(tr.Spec.Params))
+	for _, p := range tr.Spec.Params {
+		got[p.Name] = p.Value
+	}
+	want := map[string]tknv1beta1.ArrayOrString{
+		"dev.mink.sources.bundle": *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"),
+		"dev.mink.images.target":  *tknv1beta1.NewArrayOrString("ghcr.io/mattmoor/image:latest"),
+		"path":                    *tknv1beta1.NewArrayOrString("services/api"),
+		"tool":                    *tknv1beta1.NewArrayOrString("gradle"),
+		"jib-args": {
+			Type:     tknv1beta1.ParamTypeArray,
+			ArrayVal: []string{"-PskipTests", "--offline"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Params (-want, +got): %s", diff)
+	}
+
+	// Every parameter is declared by the task.
+	for _, p := range tr.Spec.Params {
+		found := false
+		for _, ps := range tr.Spec.TaskSpec.Params {
+			found = found || ps.Name == p.Name
+		}
+		if !found {
+			t.Errorf("parameter %q is not declared by the task", p.Name)
+		}
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	// Without a claim, the build tools keep their own caches.
+	tr := buildTaskRun(t, Options{Path: ".", Tool: "auto"})
+	if diff := cmp.Diff(stepEnv(&tknv1beta1.TaskRun{Spec: tknv1beta1.TaskRunSpec{TaskSpec: &JibTask.Spec}}), stepEnv(tr)); diff != "" {
+		t.Errorf("step env (-want, +got): %s", diff)
+	}
+	if len(tr.Spec.Workspaces) != 0 || len(tr.Spec.TaskSpec.Workspaces) != 0 {
+		t.Errorf("Workspaces = %v, wanted none", tr.Spec.Workspaces)
+	}
+
+	// With a claim, Maven and Gradle keep their caches in the cache workspace.
+	tr = buildTaskRun(t, Options{Path: ".", Tool: "auto", CacheClaim: "mink-cache"})
+	env := stepEnv(tr)
+	for _, test := range []struct {
+		step string
+		want corev1.EnvVar
+	}{{
+		step: "maven",
+		want: corev1.EnvVar{Name: "MAVEN_OPTS", Value: "-Dmaven.repo.local=/cache/m2"},
+	}, {
+		step: "gradle",
+		want: corev1.EnvVar{Name: "GRADLE_USER_HOME", Value: "/cache/gradle"},
+	}} {
+		found := false
+		for _, ev := range env[test.step] {
+			found = found || ev == test.want
+		}
+		if !found {
+			t.Errorf("%s env = %v, wanted %v", test.step, env[test.step], test.want)
+		}
+	}
+	wantDecl := []tknv1beta1.WorkspaceDeclaration{{
+		Name:        builds.CacheWorkspace,
+		Description: "A persistent cache, which is shared across builds.",
+		MountPath:   "/cache",
+	}}
+	if diff := cmp.Diff(wantDecl, tr.Spec.TaskSpec.Workspaces); diff != "" {
+		t.Errorf("TaskSpec.Workspaces (-want, +got): %s", diff)
+	}
+	wantBinding := []tknv1beta1.WorkspaceBinding{{
+		Name:                  builds.CacheWorkspace,
+		SubPath:               "jib",
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "mink-cache"},
+	}}
+	if diff := cmp.Diff(wantBinding, tr.Spec.Workspaces); diff != "" {
+		t.Errorf("Workspaces (-want, +got): %s", diff)
+	}
+
+	// The shared task definition is left untouched.
+	for _, step := range JibTask.Spec.Steps {
+		for _, ev := range step.Env {
+			if ev.Name == "MAVEN_OPTS" || ev.Name == "GRADLE_USER_HOME" {
+				t.Errorf("JibTask step %s has %s", step.Name, ev.Name)
+			}
+		}
+	}
+	if len(JibTask.Spec.Workspaces) != 0 {
+		t.Errorf("JibTask.Spec.Workspaces = %v, wanted none", JibTask.Spec.Workspaces)
+	}
+}
@@ -19,9 +19,11 @@ package ko
 import (
 	"context"
 	"fmt"
+	"path"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/constants"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -34,6 +36,106 @@ import (
 type Options struct {
 	// ImportPath is the path to ko publish
 	ImportPath string
+
+	// Estargz indicates that the layers of the image should be compressed
+	// as eStargz, so that snapshotters that support lazy pulling may start
+	// containers before the image has been downloaded in full.
+	Estargz bool
+
+	// Platforms holds the platforms for which to build (e.g. linux/arm64,
+	// or all for every platform of the base image).  When there are
+	// several, ko publishes an image index.  By default ko builds for the
+	// platform of the node on which it runs.
+	Platforms []string
+
+	// BaseImage is the default base image, which takes precedence over the
+	// defaultBaseImage of .ko.yaml (but not its baseImageOverrides).
+	BaseImage string
+
+	// ConfigPath is the directory within the bundle holding the .ko.yaml,
+	// by default its root.
+	ConfigPath string
+
+	// Ldflags holds the flags to pass to the linker (as with go build -ldflags).
+	Ldflags string
+
+	// GoFlags holds flags for the go command (as with GOFLAGS).
+	GoFlags string
+
+	// Tags holds the tags to publish, by default the tag of the target.
+	Tags []string
+
+	// SBOM is the kind of SBOM for ko to produce (e.g. spdx), when set,
+	// which requires a version of ko that supports --sbom.
+	SBOM string
+
+	// CacheClaim is the name of a PersistentVolumeClaim in which to persist
+	// the Go module and build caches across builds, when set.
+	CacheClaim string
+}
+
+// goFlags returns the value of GOFLAGS, with the ldflags quoted so that
+// they may contain spaces (e.g. -X main.version=1.0 -s -w).
+func (opt Options) goFlags() (string, error) {
+	flags := opt.GoFlags
+	if opt.Ldflags == "" {
+		return flags, nil
+	}
+	q := "'"
+	if strings.Contains(opt.Ldflags, q) {
+		q = `"`
+		if strings.Contains(opt.Ldflags, q) {
+			return "", fmt.Errorf("ldflags may not contain both single and double quotes: %s", opt.Ldflags)
+		}
+	}
+	return strings.TrimSpace(flags + " " + q + "-ldflags=" + opt.Ldflags + q), nil
+}
+
+// args returns the arguments to ko publish.
+func (opt Options) args(target name.Tag) []string {
+	tags := opt.Tags
+	if len(tags) == 0 {
+		tags = []string{target.TagStr()}
+	}
+	args := []string{"--bare", "--tags=" + strings.Join(tags, ",")}
+	if len(opt.Platforms) > 0 {
+		args = append(args, "--platform="+strings.Join(opt.Platforms, ","))
+	}
+	if opt.SBOM != "" {
+		args = append(args, "--sbom="+opt.SBOM)
+	}
+	return append(args, opt.ImportPath)
+}
+
+// script returns the script that runs ko publish with the arguments passed
+// to it, and writes the digest of the result, failing when ko doesn't
+// produce one.
+func (opt Options) script() string {
+	lines := []string{
+		"set -o errexit -o pipefail",
+		// Good for debugging.
+		"go env",
+		"export GOROOT=$(go env GOROOT)",
+	}
+	if len(opt.Platforms) == 0 {
+		// Not set for some reason :rolls_eyes:
+		// When building for other platforms, ko sets these itself (and
+		// would be overridden by the environment).
+		lines = append(lines,
+			"export GOARCH=$(go env GOARCH)",
+			"export GOOS=$(go env GOOS)",
+			"export GOARM=$(go env GOARM)",
+		)
+	}
+	return strings.Join(append(lines,
+		// Where the magic happens.
+		`REF=$(ko publish "$@" | tail -n 1)`,
+		`if [[ "${REF}" != *@sha256:* ]]; then`,
+		`  echo "ko publish did not produce an image digest, got: ${REF}" >&2`,
+		"  exit 1",
+		"fi",
+		fmt.Sprintf(`echo -n "${REF##*@}" > /tekton/results/%s`, constants.ImageDigestResult),
+	), "\n")
 }
 
 var (
@@ -44,8 +146,55 @@ var (
 
 // Build returns a TaskRun suitable for performing a "ko publish" build over the
 // provided source and publishing to the target tag.
-func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) *tknv1beta1.TaskRun {
-	return &tknv1beta1.TaskRun{
+func Build(ctx context.Context, source name.Reference, target name.Tag, opt Options) (*tknv1beta1.TaskRun, error) {
+	env := []corev1.EnvVar{{
+		Name:  "DOCKER_CONFIG",
+		Value: "/tekton/home/.docker",
+	}, {
+		Name:  "KO_DOCKER_REPO",
+		Value: target.Repository.String(),
+	}}
+	if opt.Estargz {
+		// Enable estargz support
+		env = append(env, corev1.EnvVar{
+			Name:  "GGCR_EXPERIMENT_ESTARGZ",
+			Value: "1",
+		})
+	}
+	if opt.BaseImage != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "KO_DEFAULTBASEIMAGE",
+			Value: opt.BaseImage,
+		})
+	}
+	if opt.ConfigPath != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "KO_CONFIG_PATH",
+			Value: path.Join("/workspace", opt.ConfigPath),
+		})
+	}
+	goflags, err := opt.goFlags()
+	if err != nil {
+		return nil, err
+	}
+	if goflags != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOFLAGS",
+			Value: goflags,
+		})
+	}
+
+	if opt.CacheClaim != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOMODCACHE",
+			Value: path.Join(builds.CacheMountPath, "mod"),
+		}, corev1.EnvVar{
+			Name:  "GOCACHE",
+			Value: path.Join(builds.CacheMountPath, "build"),
+		})
+	}
+
+	tr := &tknv1beta1.TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "ko-publish-",
 		},
@@ -70,31 +219,13 @@ func Build(ctx context.Context, source name.Reference, target name.Tag, opt Opti
 						Name:       "ko-publish",
 						Image:      KoImageString,
 						WorkingDir: "/workspace",
-						Env: []corev1.EnvVar{{
-							Name:  "DOCKER_CONFIG",
-							Value: "/tekton/home/.docker",
-						}, {
-							Name:  "KO_DOCKER_REPO",
-							Value: target.Repository.String(),
-						}},
+						Env:        env,
 						Command: []string{
 							"/bin/bash", "-c",
 						},
-						Args: []string{
-							strings.Join([]string{
-								// Good for debugging.
-								"go env",
-								// Not set for some reason :rolls_eyes:
-								"export GOARCH=$(go env GOARCH)",
-								"export GOOS=$(go env GOOS)",
-								"export GOARM=$(go env GOARM)",
-								"export GOROOT=$(go env GOROOT)",
-								// Enable estargz support
-								// "export GGCR_EXPERIMENT_ESTARGZ=1",
-								// Where the magic happens.
-								fmt.Sprintf("ko publish --bare %s | cut -d'@' -f 2 > /tekton/results/%s", opt.ImportPath, constants.ImageDigestResult),
-							}, " && "),
-						},
+						// Pass the arguments to the script positionally,
+						// so that they needn't be quoted.
+						Args: append([]string{opt.script(), "ko-publish"}, opt.args(target)...),
 						Resources: corev1.ResourceRequirements{
 							// Set requests based on a typical ko task,
 							// but do not set limits because it could
@@ -111,4 +242,8 @@ func Build(ctx context.Context, source name.Reference, target name.Tag, opt Opti
 			},
 		},
 	}
+	if opt.CacheClaim != "" {
+		builds.AddCacheWorkspace(tr, opt.CacheClaim, "ko")
+	}
+	return tr, nil
 }
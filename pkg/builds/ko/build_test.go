@@ -0,0 +1,277 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ko
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGoFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     Options
+		want    string
+		wantErr bool
+	}{{
+		name: "none",
+		want: "",
+	}, {
+		name: "go flags only",
+		opt:  Options{GoFlags: "-mod=vendor"},
+		want: "-mod=vendor",
+	}, {
+		name: "ldflags only",
+		opt:  Options{Ldflags: "-s -w"},
+		want: "'-ldflags=-s -w'",
+	}, {
+		name: "both",
+		opt:  Options{GoFlags: "-mod=vendor -trimpath", Ldflags: "-X main.version=1.0 -s -w"},
+		want: "-mod=vendor -trimpath '-ldflags=-X main.version=1.0 -s -w'",
+	}, {
+		name: "single quotes",
+		opt:  Options{Ldflags: "-X 'main.version=1.0 beta'"},
+		want: `"-ldflags=-X 'main.version=1.0 beta'"`,
+	}, {
+		name: "double quotes",
+		opt:  Options{Ldflags: `-X "main.version=1.0 beta"`},
+		want: `'-ldflags=-X "main.version=1.0 beta"'`,
+	}, {
+		name:    "both quotes",
+		opt:     Options{Ldflags: `-X 'main.version="1.0"'`},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.opt.goFlags()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("goFlags() = %q, wanted error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("goFlags() =", err)
+			}
+			if got != test.want {
+				t.Errorf("goFlags() = %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestArgs(t *testing.T) {
+	target, err := name.NewTag("ghcr.io/mattmoor/image:v1")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+
+	tests := []struct {
+		name string
+		opt  Options
+		want []string
+	}{{
+		name: "defaults",
+		opt:  Options{ImportPath: "./cmd/foo"},
+		want: []string{"--bare", "--tags=v1", "./cmd/foo"},
+	}, {
+		name: "tags",
+		opt:  Options{ImportPath: "./cmd/foo", Tags: []string{"latest", "v1.2.3"}},
+		want: []string{"--bare", "--tags=latest,v1.2.3", "./cmd/foo"},
+	}, {
+		name: "platforms",
+		opt:  Options{ImportPath: "./cmd/foo", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		want: []string{"--bare", "--tags=v1", "--platform=linux/amd64,linux/arm64", "./cmd/foo"},
+	}, {
+		name: "sbom",
+		opt:  Options{ImportPath: "./cmd/foo", SBOM: "spdx"},
+		want: []string{"--bare", "--tags=v1", "--sbom=spdx", "./cmd/foo"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, test.opt.args(target)); diff != "" {
+				t.Errorf("args (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is required:", err)
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		fail    bool
+		want    string
+		wantErr bool
+	}{{
+		name:   "digest",
+		output: "ghcr.io/mattmoor/image:v1@sha256:deadbeef",
+		want:   "sha256:deadbeef",
+	}, {
+		name:    "empty",
+		output:  "",
+		wantErr: true,
+	}, {
+		name:    "tag",
+		output:  "ghcr.io/mattmoor/image:v1",
+		wantErr: true,
+	}, {
+		name:    "ko fails",
+		output:  "ghcr.io/mattmoor/image:v1@sha256:deadbeef",
+		fail:    true,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Stub out go and ko, and write the results to a temporary directory.
+			bin, results := t.TempDir(), t.TempDir()
+			exit := "0"
+			if test.fail {
+				exit = "1"
+			}
+			for cmd, body := range map[string]string{
+				"go": "true",
+				"ko": "echo building >&2\necho '" + test.output + "'\nexit " + exit,
+			} {
+				if err := os.WriteFile(filepath.Join(bin, cmd), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+					t.Fatal("WriteFile() =", err)
+				}
+			}
+			script := strings.ReplaceAll(Options{}.script(), "/tekton/results", results)
+
+			cmd := exec.Command(bash, "-c", script, "ko-publish", "--bare", "./cmd/foo")
+			cmd.Env = []string{"PATH=" + bin + string(os.PathListSeparator) + os.Getenv("PATH")}
+			out, err := cmd.CombinedOutput()
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("script succeeded, wanted failure: %s", out)
+				}
+				if _, err := os.Stat(filepath.Join(results, constants.ImageDigestResult)); !os.IsNotExist(err) {
+					t.Errorf("Stat() = %v, wanted no digest result", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("script failed: %v: %s", err, out)
+			}
+			got, err := os.ReadFile(filepath.Join(results, constants.ImageDigestResult))
+			if err != nil {
+				t.Fatal("ReadFile() =", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("digest = %q, wanted %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildEnv(t *testing.T) {
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	target, err := name.NewTag("ghcr.io/mattmoor/image:latest")
+	if err != nil {
+		t.Fatal("name.NewTag() =", err)
+	}
+
+	tests := []struct {
+		name          string
+		opt           Options
+		want          map[string]string
+		wantWorkspace bool
+	}{{
+		name: "defaults",
+		opt:  Options{ImportPath: "./cmd/foo"},
+		want: map[string]string{
+			"DOCKER_CONFIG":  "/tekton/home/.docker",
+			"KO_DOCKER_REPO": "ghcr.io/mattmoor/image",
+		},
+	}, {
+		name: "everything",
+		opt: Options{
+			ImportPath: "./cmd/foo",
+			Estargz:    true,
+			BaseImage:  "cgr.dev/chainguard/static",
+			ConfigPath: "build/ko",
+			Ldflags:    "-s -w",
+			CacheClaim: "mink-cache",
+		},
+		want: map[string]string{
+			"DOCKER_CONFIG":           "/tekton/home/.docker",
+			"KO_DOCKER_REPO":          "ghcr.io/mattmoor/image",
+			"GGCR_EXPERIMENT_ESTARGZ": "1",
+			"KO_DEFAULTBASEIMAGE":     "cgr.dev/chainguard/static",
+			"KO_CONFIG_PATH":          "/workspace/build/ko",
+			"GOFLAGS":                 "'-ldflags=-s -w'",
+			"GOMODCACHE":              builds.CacheMountPath + "/mod",
+			"GOCACHE":                 builds.CacheMountPath + "/build",
+		},
+		wantWorkspace: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr, err := Build(context.Background(), source, target, test.opt)
+			if err != nil {
+				t.Fatal("Build() =", err)
+			}
+			var step *corev1.Container
+			for i := range tr.Spec.TaskSpec.Steps {
+				if c := &tr.Spec.TaskSpec.Steps[i].Container; c.Name == "ko-publish" {
+					step = c
+				}
+			}
+			if step == nil {
+				t.Fatal("no ko-publish step")
+			}
+			got := make(map[string]string, len(step.Env))
+			for _, env := range step.Env {
+				got[env.Name] = env.Value
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Env (-want, +got): %s", diff)
+			}
+			if got := len(tr.Spec.Workspaces) != 0; got != test.wantWorkspace {
+				t.Errorf("Workspaces = %v, wanted a cache workspace: %v", tr.Spec.Workspaces, test.wantWorkspace)
+			}
+		})
+	}
+
+	// Ldflags that can't be quoted fail the build.
+	if _, err := Build(context.Background(), source, target, Options{Ldflags: `-X 'a="b"'`}); err == nil {
+		t.Error("Build() = nil, wanted error")
+	}
+}
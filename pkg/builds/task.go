@@ -22,9 +22,12 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/constants"
 	"github.com/tektoncd/cli/pkg/options"
 	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	pipelineclient "github.com/tektoncd/pipeline/pkg/client/injection/client"
@@ -229,3 +232,40 @@ func WithTaskServiceAccount(ctx context.Context, sa string, refs ...name.Referen
 		}, nil
 	}
 }
+
+// WithSourceProvenance is used to record the provenance of the source bundle
+// passed via the constants.SourceBundleParam parameter (see bundles.Provenance)
+// as annotations on the TaskRun, so that the TaskRun (and anything observing
+// it, such as Tekton Chains) can trace the image it produces back to the
+// sources from which it was built.  The annotations are keyed by the name of
+// the parameter, e.g. "dev.mink.sources.bundle/org.opencontainers.image.revision".
+func WithSourceProvenance(source name.Reference) CancelableTaskOption {
+	return func(ctx context.Context, tr *tknv1beta1.TaskRun) (context.CancelFunc, error) {
+		auth, err := authn.DefaultKeychain.Resolve(source.Context())
+		if err != nil {
+			return nil, err
+		}
+		desc, err := remote.Get(source, remote.WithAuth(auth), remote.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		// Both image manifests and image indices carry annotations.
+		var manifest struct {
+			Annotations map[string]string `json:"annotations"`
+		}
+		if err := json.Unmarshal(desc.Manifest, &manifest); err != nil {
+			return nil, err
+		}
+		for k, v := range manifest.Annotations {
+			if !strings.HasPrefix(k, "org.opencontainers.image.") && !strings.HasPrefix(k, "dev.mink.bundle.") {
+				continue
+			}
+			if tr.Annotations == nil {
+				tr.Annotations = make(map[string]string, len(manifest.Annotations))
+			}
+			tr.Annotations[constants.SourceBundleParam+"/"+k] = v
+		}
+		return func() {}, nil
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithSourceProvenance(t *testing.T) {
+	manifest := []byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {
+    "mediaType": "application/vnd.oci.image.config.v1+json",
+    "size": 2,
+    "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+  },
+  "layers": [],
+  "annotations": {
+    "org.opencontainers.image.source": "https://github.com/mattmoor/mink.git",
+    "org.opencontainers.image.revision": "deadbeef",
+    "dev.mink.bundle.mode": "kontext",
+    "com.example.unrelated": "dropped"
+  }
+}`)
+	sum := sha256.Sum256(manifest)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/source/manifests/" + digest:
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Write(manifest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal("url.Parse() =", err)
+	}
+
+	source, err := name.NewDigest(fmt.Sprintf("%s/source@%s", u.Host, digest))
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	tr := &tknv1beta1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"existing": "annotation"},
+		},
+	}
+	cancel, err := WithSourceProvenance(source)(context.Background(), tr)
+	if err != nil {
+		t.Fatal("WithSourceProvenance() =", err)
+	}
+	defer cancel()
+
+	if diff := cmp.Diff(map[string]string{
+		"existing": "annotation",
+		"dev.mink.sources.bundle/org.opencontainers.image.source":   "https://github.com/mattmoor/mink.git",
+		"dev.mink.sources.bundle/org.opencontainers.image.revision": "deadbeef",
+		"dev.mink.sources.bundle/dev.mink.bundle.mode":              "kontext",
+	}, tr.Annotations); diff != "" {
+		t.Errorf("Annotations (-want, +got): %s", diff)
+	}
+
+	// A missing bundle is an error.
+	missing, err := name.NewDigest(fmt.Sprintf("%s/missing@%s", u.Host, digest))
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	if _, err := WithSourceProvenance(missing)(context.Background(), &tknv1beta1.TaskRun{}); err == nil {
+		t.Error("WithSourceProvenance() = nil, wanted error")
+	}
+}
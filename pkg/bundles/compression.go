@@ -0,0 +1,390 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Compression is how bundle layers are compressed.
+type Compression string
+
+const (
+	// GzipCompression compresses layers with gzip, which every runtime supports.
+	GzipCompression Compression = "gzip"
+
+	// ZstdCompression compresses layers with zstd, which is faster to
+	// decompress but requires a runtime that supports it.
+	ZstdCompression Compression = "zstd"
+
+	// EstargzCompression compresses layers as eStargz, which is compatible
+	// with gzip but also allows snapshotters that support lazy pulling to
+	// fetch individual files before the whole layer has been downloaded.
+	EstargzCompression Compression = "estargz"
+
+	// ZstdLayer is the media type of zstd compressed OCI layers.
+	ZstdLayer types.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+)
+
+// Compressions holds the supported kinds of Compression.
+var Compressions = []Compression{GzipCompression, ZstdCompression, EstargzCompression}
+
+// ParseCompression parses the given string as a Compression.
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range Compressions {
+		if string(c) == s {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported compression %q, wanted one of %v", s, Compressions)
+}
+
+// layer returns a layer holding the uncompressed tarball at path, compressed
+// accordingly.  The zero value is treated as GzipCompression.
+func (c Compression) layer(path string) (v1.Layer, error) {
+	switch c {
+	case "", GzipCompression:
+		return tarball.LayerFromFile(path)
+	case EstargzCompression:
+		return newEstargzLayer(path)
+	case ZstdCompression:
+		return newZstdLayer(path)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", c)
+	}
+}
+
+// AppendLayers appends the given layers to img.  Docker manifests cannot
+// reference zstd compressed layers, so when any of them are, the result
+// uses OCI media types instead.
+func AppendLayers(img v1.Image, layers ...v1.Layer) (v1.Image, error) {
+	img, err := mutate.AppendLayers(img, layers...)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		mt, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mt == ZstdLayer {
+			img = mutate.MediaType(img, types.OCIManifestSchema1)
+			return mutate.ConfigMediaType(img, types.OCIConfigJSON), nil
+		}
+	}
+	return img, nil
+}
+
+// Readable returns an image with the layers of img, whose uncompressed
+// contents may be read even when they are compressed with zstd (which the
+// layers ggcr reads from registries and layouts assume is gzip).  The result
+// is only suitable for reading the layers of img, e.g. via mutate.Extract.
+func Readable(img v1.Image) (v1.Image, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range layers {
+		mt, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mt == ZstdLayer {
+			layers[i] = &zstdReadLayer{l}
+		}
+	}
+	return mutate.AppendLayers(empty.Image, layers...)
+}
+
+// zstdReadLayer decompresses the contents of a zstd compressed layer.
+type zstdReadLayer struct {
+	v1.Layer
+}
+
+// Uncompressed implements v1.Layer
+func (l *zstdReadLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zstd.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &zstdReadCloser{zr: zr, rc: rc}, nil
+}
+
+// zstdReadCloser closes both the decoder and what it reads from.
+type zstdReadCloser struct {
+	zr *zstd.Decoder
+	rc io.ReadCloser
+}
+
+// Read implements io.Reader
+func (z *zstdReadCloser) Read(p []byte) (int, error) {
+	return z.zr.Read(p)
+}
+
+// Close implements io.Closer
+func (z *zstdReadCloser) Close() error {
+	z.zr.Close()
+	return z.rc.Close()
+}
+
+// zstdLayer implements v1.Layer for a tarball compressed with zstd, where
+// both the uncompressed and compressed forms are backed by files.
+type zstdLayer struct {
+	path, compressedPath string
+	digest, diffID       v1.Hash
+	size                 int64
+}
+
+var _ v1.Layer = (*zstdLayer)(nil)
+
+// newZstdLayer compresses the tarball at path into a sibling file.
+func newZstdLayer(path string) (v1.Layer, error) {
+	l := &zstdLayer{path: path, compressedPath: path + ".zst"}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(l.compressedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	// Compress with a single goroutine so that the output is reproducible.
+	zw, err := zstd.NewWriter(out, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	if l.diffID, _, err = hashFile(l.path); err != nil {
+		return nil, err
+	}
+	if l.digest, l.size, err = hashFile(l.compressedPath); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// hashFile returns the sha256 digest and size of the file at path.
+func hashFile(path string) (v1.Hash, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return v1.Hash{}, 0, err
+	}
+	defer f.Close()
+	return v1.SHA256(f)
+}
+
+// Digest implements v1.Layer
+func (l *zstdLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID implements v1.Layer
+func (l *zstdLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+// Compressed implements v1.Layer
+func (l *zstdLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.compressedPath)
+}
+
+// Uncompressed implements v1.Layer
+func (l *zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// Size implements v1.Layer
+func (l *zstdLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+// MediaType implements v1.Layer
+func (l *zstdLayer) MediaType() (types.MediaType, error) {
+	return ZstdLayer, nil
+}
+
+// estargzLayer is a gzip compressed layer in the eStargz format, whose
+// descriptor records the digest of its table of contents.
+type estargzLayer struct {
+	v1.Layer
+	toc digest.Digest
+}
+
+var _ v1.Layer = (*estargzLayer)(nil)
+
+// newEstargzLayer converts the tarball at path into an eStargz sibling file.
+// This uses a single estargz.Writer rather than estargz.Build, which splits
+// the tarball into as many parts as there are CPUs, so that the output does
+// not depend upon the machine producing it.
+func newEstargzLayer(path string) (v1.Layer, error) {
+	compressedPath := path + ".esgz"
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(compressedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	w := estargz.NewWriterWithCompressor(out, estargzCompressor{})
+	if err := w.AppendTar(in); err != nil {
+		return nil, err
+	}
+	toc, err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return os.Open(compressedPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &estargzLayer{Layer: l, toc: toc}, nil
+}
+
+// Descriptor implements partial.withDescriptor
+func (l *estargzLayer) Descriptor() (*v1.Descriptor, error) {
+	desc, err := partial.Descriptor(l.Layer)
+	if err != nil {
+		return nil, err
+	}
+	desc.Annotations = map[string]string{
+		estargz.TOCJSONDigestAnnotation: l.toc.String(),
+	}
+	return desc, nil
+}
+
+// estargzCompressor implements estargz.Compressor like the library's own
+// gzip compressor, but builds the fixed-size footer by hand: the library
+// relies on the exact framing that compress/gzip produces for an empty
+// stream, which varies between Go releases.
+type estargzCompressor struct{}
+
+var _ estargz.Compressor = estargzCompressor{}
+
+// Writer implements estargz.Compressor
+func (estargzCompressor) Writer(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.BestCompression)
+}
+
+// WriteTOCAndFooter implements estargz.Compressor
+func (estargzCompressor) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJSON, err := json.MarshalIndent(toc, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	gw := io.Writer(gz)
+	if diffHash != nil {
+		gw = io.MultiWriter(gz, diffHash)
+	}
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargz.TOCTarName,
+		Size:     int64(len(tocJSON)),
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if _, err := w.Write(estargzFooter(off)); err != nil {
+		return "", err
+	}
+	return digest.FromBytes(tocJSON), nil
+}
+
+// estargzFooter returns the eStargz footer pointing at the table of contents
+// at the given offset: an empty gzip member whose extra field holds the
+// offset, see https://github.com/containerd/stargz-snapshotter/blob/main/docs/estargz.md#footer
+func estargzFooter(tocOff int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOff)
+	footer := make([]byte, 0, estargz.FooterSize)
+	// The gzip header, with FEXTRA set, no modification time, and an
+	// unknown OS.
+	footer = append(footer, 0x1f, 0x8b, 8, 1<<2, 0, 0, 0, 0, 0, 255)
+	// The extra field, holding a single "SG" subfield.
+	footer = appendUint16(footer, uint16(4+len(subfield)))
+	footer = append(footer, 'S', 'G')
+	footer = appendUint16(footer, uint16(len(subfield)))
+	footer = append(footer, subfield...)
+	// A final, empty, stored deflate block.
+	footer = append(footer, 1, 0, 0, 0xff, 0xff)
+	// The CRC-32 and size of the (empty) contents.
+	return append(footer, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// appendUint16 appends v to b in little-endian order, as gzip requires.
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
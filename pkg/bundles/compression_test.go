@@ -0,0 +1,346 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// writeFiles writes a few files for NewLayer.
+func writeFiles(tw *tar.Writer) error {
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		content := bytes.Repeat([]byte(name), 1000)
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0444,
+			Size:     int64(len(content)),
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range Compressions {
+		if got, err := ParseCompression(string(c)); err != nil {
+			t.Errorf("ParseCompression(%q) = %v", c, err)
+		} else if got != c {
+			t.Errorf("ParseCompression(%q) = %q", c, got)
+		}
+	}
+	if _, err := ParseCompression("bzip2"); err == nil {
+		t.Error("ParseCompression(bzip2) = nil, wanted error")
+	}
+}
+
+func TestNewLayerCompression(t *testing.T) {
+	tests := []struct {
+		compression Compression
+		mediaType   types.MediaType
+		toc         bool
+	}{{
+		compression: "",
+		mediaType:   types.DockerLayer,
+	}, {
+		compression: GzipCompression,
+		mediaType:   types.DockerLayer,
+	}, {
+		compression: EstargzCompression,
+		mediaType:   types.DockerLayer,
+		toc:         true,
+	}, {
+		compression: ZstdCompression,
+		mediaType:   ZstdLayer,
+	}}
+
+	for _, test := range tests {
+		t.Run(string(test.compression), func(t *testing.T) {
+			l, err := NewLayer(t.TempDir(), test.compression, writeFiles)
+			if err != nil {
+				t.Fatal("NewLayer() =", err)
+			}
+
+			desc, err := partial.Descriptor(l)
+			if err != nil {
+				t.Fatal("Descriptor() =", err)
+			}
+			if desc.MediaType != test.mediaType {
+				t.Errorf("MediaType = %s, wanted %s", desc.MediaType, test.mediaType)
+			}
+			toc, ok := desc.Annotations[estargz.TOCJSONDigestAnnotation]
+			if ok != test.toc {
+				t.Errorf("Annotations = %v, wanted TOC: %v", desc.Annotations, test.toc)
+			} else if ok {
+				if _, err := v1.NewHash(toc); err != nil {
+					t.Errorf("TOC annotation %q is not a digest: %v", toc, err)
+				}
+			}
+
+			// The layer must be reproducible.
+			again, err := NewLayer(t.TempDir(), test.compression, writeFiles)
+			if err != nil {
+				t.Fatal("NewLayer() =", err)
+			}
+			d1, err := l.Digest()
+			if err != nil {
+				t.Fatal("Digest() =", err)
+			}
+			d2, err := again.Digest()
+			if err != nil {
+				t.Fatal("Digest() =", err)
+			}
+			if d1 != d2 {
+				t.Errorf("Digest() = %v and %v, wanted them equal", d1, d2)
+			}
+		})
+	}
+}
+
+func TestZstdLayer(t *testing.T) {
+	l, err := NewLayer(t.TempDir(), ZstdCompression, writeFiles)
+	if err != nil {
+		t.Fatal("NewLayer() =", err)
+	}
+
+	rc, err := l.Compressed()
+	if err != nil {
+		t.Fatal("Compressed() =", err)
+	}
+	defer rc.Close()
+	compressed, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal("ReadAll() =", err)
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal("SHA256() =", err)
+	}
+	if got, err := l.Digest(); err != nil || got != digest {
+		t.Errorf("Digest() = %v, %v, wanted %v", got, err, digest)
+	}
+	if got, err := l.Size(); err != nil || got != size {
+		t.Errorf("Size() = %v, %v, wanted %v", got, err, size)
+	}
+
+	// The compressed contents must decompress to the uncompressed ones.
+	zr, err := zstd.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal("zstd.NewReader() =", err)
+	}
+	defer zr.Close()
+	diffID, _, err := v1.SHA256(zr)
+	if err != nil {
+		t.Fatal("SHA256() =", err)
+	}
+	if got, err := l.DiffID(); err != nil || got != diffID {
+		t.Errorf("DiffID() = %v, %v, wanted %v", got, err, diffID)
+	}
+}
+
+func TestEstargzLayer(t *testing.T) {
+	l, err := NewLayer(t.TempDir(), EstargzCompression, writeFiles)
+	if err != nil {
+		t.Fatal("NewLayer() =", err)
+	}
+	desc, err := partial.Descriptor(l)
+	if err != nil {
+		t.Fatal("Descriptor() =", err)
+	}
+
+	rc, err := l.Compressed()
+	if err != nil {
+		t.Fatal("Compressed() =", err)
+	}
+	defer rc.Close()
+	compressed, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal("ReadAll() =", err)
+	}
+	// The blob must be a valid eStargz, whose table of contents matches
+	// the annotation and lists the bundled files.
+	r, err := estargz.Open(io.NewSectionReader(bytes.NewReader(compressed), 0, int64(len(compressed))))
+	if err != nil {
+		t.Fatal("estargz.Open() =", err)
+	}
+	toc, err := digest.Parse(desc.Annotations[estargz.TOCJSONDigestAnnotation])
+	if err != nil {
+		t.Fatal("digest.Parse() =", err)
+	}
+	if _, err := r.VerifyTOC(toc); err != nil {
+		t.Error("VerifyTOC() =", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		sr, err := r.OpenFile(name)
+		if err != nil {
+			t.Errorf("OpenFile(%q) = %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(sr)
+		if err != nil {
+			t.Fatal("ReadAll() =", err)
+		}
+		if want := bytes.Repeat([]byte(name), 1000); !bytes.Equal(got, want) {
+			t.Errorf("OpenFile(%q) = %d bytes, wanted %d", name, len(got), len(want))
+		}
+	}
+
+	// It must still be readable as a plain gzip compressed layer.
+	if got, err := l.MediaType(); err != nil || got != types.DockerLayer {
+		t.Errorf("MediaType() = %v, %v, wanted %v", got, err, types.DockerLayer)
+	}
+	urc, err := l.Uncompressed()
+	if err != nil {
+		t.Fatal("Uncompressed() =", err)
+	}
+	defer urc.Close()
+	diffID, _, err := v1.SHA256(urc)
+	if err != nil {
+		t.Fatal("SHA256() =", err)
+	}
+	if got, err := l.DiffID(); err != nil || got != diffID {
+		t.Errorf("DiffID() = %v, %v, wanted %v", got, err, diffID)
+	}
+}
+
+func TestEstargzFooter(t *testing.T) {
+	for _, off := range []int64{0, 12345, 1 << 40} {
+		footer := estargzFooter(off)
+		if len(footer) != estargz.FooterSize {
+			t.Errorf("len(estargzFooter(%d)) = %d, wanted %d", off, len(footer), estargz.FooterSize)
+		}
+		// The footer must be a valid, empty gzip member.
+		zr, err := gzip.NewReader(bytes.NewReader(footer))
+		if err != nil {
+			t.Fatal("gzip.NewReader() =", err)
+		}
+		if b, err := ioutil.ReadAll(zr); err != nil || len(b) != 0 {
+			t.Errorf("ReadAll() = %q, %v, wanted an empty member", b, err)
+		}
+		_, got, _, err := (&estargz.GzipDecompressor{}).ParseFooter(footer)
+		if err != nil {
+			t.Fatal("ParseFooter() =", err)
+		}
+		if got != off {
+			t.Errorf("ParseFooter() = %d, wanted %d", got, off)
+		}
+	}
+}
+
+func TestAppendLayers(t *testing.T) {
+	base, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatal("random.Image() =", err)
+	}
+	tests := []struct {
+		compression Compression
+		mediaType   types.MediaType
+	}{{
+		compression: GzipCompression,
+		mediaType:   types.DockerManifestSchema2,
+	}, {
+		compression: ZstdCompression,
+		mediaType:   types.OCIManifestSchema1,
+	}}
+
+	for _, test := range tests {
+		t.Run(string(test.compression), func(t *testing.T) {
+			l, err := NewLayer(t.TempDir(), test.compression, writeFiles)
+			if err != nil {
+				t.Fatal("NewLayer() =", err)
+			}
+			img, err := AppendLayers(base, l)
+			if err != nil {
+				t.Fatal("AppendLayers() =", err)
+			}
+			m, err := img.Manifest()
+			if err != nil {
+				t.Fatal("Manifest() =", err)
+			}
+			if m.MediaType != test.mediaType {
+				t.Errorf("MediaType = %s, wanted %s", m.MediaType, test.mediaType)
+			}
+		})
+	}
+}
+
+func TestReadable(t *testing.T) {
+	l, err := NewLayer(t.TempDir(), ZstdCompression, writeFiles)
+	if err != nil {
+		t.Fatal("NewLayer() =", err)
+	}
+	// Strip the uncompressed form of the layer, as happens when it is read
+	// back from a registry or layout.
+	l, err = partial.CompressedToLayer(compressedOnly{l})
+	if err != nil {
+		t.Fatal("CompressedToLayer() =", err)
+	}
+	img, err := AppendLayers(empty.Image, l)
+	if err != nil {
+		t.Fatal("AppendLayers() =", err)
+	}
+	if img, err = Readable(img); err != nil {
+		t.Fatal("Readable() =", err)
+	}
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Next() =", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if got, want := strings.Join(names, ","), "a.txt,b.txt,c.txt"; got != want {
+		t.Errorf("Extract() = %s, wanted %s", got, want)
+	}
+}
+
+// compressedOnly exposes only the compressed form of a layer.
+type compressedOnly struct {
+	l v1.Layer
+}
+
+func (c compressedOnly) Digest() (v1.Hash, error)            { return c.l.Digest() }
+func (c compressedOnly) Compressed() (io.ReadCloser, error)  { return c.l.Compressed() }
+func (c compressedOnly) Size() (int64, error)                { return c.l.Size() }
+func (c compressedOnly) MediaType() (types.MediaType, error) { return c.l.MediaType() }
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// tokenUsername is the username sent alongside tokens, which most git
+// hosts ignore in favor of the token itself.
+const tokenUsername = "x-access-token"
+
+// auth determines the credentials with which to clone opts.URL.  Over
+// http(s) an explicit opts.Token takes precedence, and otherwise we reuse
+// whatever git's configured credential helpers have stored.  Over ssh an
+// explicit opts.SSHKey takes precedence, and otherwise we use ssh-agent.
+// A nil AuthMethod indicates that the clone should be anonymous.
+func auth(ctx context.Context, opts Options) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "http", "https":
+		if opts.Token != "" {
+			return &http.BasicAuth{
+				Username: tokenUsername,
+				Password: opts.Token,
+			}, nil
+		}
+		if ep.User != "" || ep.Password != "" {
+			// go-git uses the credentials embedded in the URL.
+			return nil, nil
+		}
+		return credentialFill(ctx, ep), nil
+
+	case "ssh":
+		if opts.SSHKey != "" {
+			return ssh.NewPublicKeysFromFile(ep.User, opts.SSHKey, "" /* password */)
+		}
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return nil, nil
+		}
+		return ssh.NewSSHAgentAuth(ep.User)
+
+	default:
+		return nil, nil
+	}
+}
+
+// credentialFill asks git's configured credential helpers for credentials
+// for the given endpoint (see git-credential(1)).  This returns nil when git
+// is unavailable or has no credentials to offer, in which case we proceed
+// anonymously.
+func credentialFill(ctx context.Context, ep *transport.Endpoint) transport.AuthMethod {
+	host := ep.Host
+	if ep.Port != 0 {
+		host = fmt.Sprintf("%s:%d", ep.Host, ep.Port)
+	}
+	input := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n",
+		ep.Protocol, host, strings.TrimPrefix(ep.Path, "/"))
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input)
+	// Never prompt for credentials that the helpers don't have.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+
+	ba := &http.BasicAuth{}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "username":
+			ba.Username = parts[1]
+		case "password":
+			ba.Password = parts[1]
+		}
+	}
+	if ba.Password == "" {
+		return nil
+	}
+	return ba
+}
@@ -18,158 +18,354 @@ package git
 
 import (
 	"archive/tar"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
-	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
-	"gopkg.in/src-d/go-git.v4/storage/memory"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 
 	"github.com/mattmoor/mink/pkg/bundles"
 	"github.com/mattmoor/mink/pkg/bundles/kontext"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
 )
 
-func bundle(ctx context.Context, opts Options) (v1.Layer, error) {
-	// Filesystem abstraction based on memory
-	fs := memfs.New()
-	// Git objects storer based on memory
-	storer := memory.NewStorage()
+// bundle clones the repository into a directory within dir (rather than
+// into memory, which would grow with the size of the repository) and
+// produces a layer holding its contents, which is also backed by a file
+// within dir, along with the provenance of its contents.
+func bundle(ctx context.Context, opts Options, dir string) (v1.Layer, *bundles.Provenance, error) {
+	am, err := auth(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Clones the repository into the worktree (fs) and storer all the .git
-	// content into the storer
-	repo, err := git.CloneContext(ctx, storer, fs, &git.CloneOptions{
+	co := &git.CloneOptions{
 		URL:           opts.URL,
+		Auth:          am,
 		ReferenceName: opts.Ref,
 		SingleBranch:  true,
-	})
+		Depth:         opts.Depth,
+	}
+	if opts.Submodules {
+		co.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+	if !opts.Commit.IsZero() {
+		// We cannot ask for an arbitrary commit, so fetch everything and
+		// check out the commit (and its submodules) below.
+		co.ReferenceName = ""
+		co.SingleBranch = false
+		co.NoCheckout = true
+		co.RecurseSubmodules = git.NoRecurseSubmodules
+	}
+	repo, err := git.PlainCloneContext(ctx, filepath.Join(dir, "repo"), false /* isBare */, co)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	ref, err := repo.Head()
+	wt, err := repo.Worktree()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	hash := opts.Commit
+	if hash.IsZero() {
+		ref, err := repo.Head()
+		if err != nil {
+			return nil, nil, err
+		}
+		hash = ref.Hash()
+	} else if err := checkout(ctx, wt, opts, am); err != nil {
+		return nil, nil, err
 	}
-	commit, err := repo.CommitObject(ref.Hash())
+
+	commit, err := repo.CommitObject(hash)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	tree, err := commit.Tree()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	mtime, err := bundles.SourceDateEpoch()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names, err := treeNames(repo, tree, "", opts.Submodules)
+	if err != nil {
+		return nil, nil, err
 	}
-	buf := bytes.NewBuffer(nil)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
+	// Sort the names so that we don't depend on the order in which the
+	// tree is walked.
+	sort.Strings(names)
 
-	// Add an entry for the root kontext directory
-	// This is to facilitate testing for compatibility with kontext.
-	if err := tw.WriteHeader(&tar.Header{
-		Name:     kontext.StoragePath,
-		Typeflag: tar.TypeDir,
-		Mode:     0555,
+	created, err := bundles.CreatedTime(commit.Committer.When)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := layer(dir, opts.Compression, wt.Filesystem, names, mtime)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, &bundles.Provenance{
+		Source:    opts.URL,
+		Revision:  hash.String(),
+		Created:   created,
+		Mode:      "git",
+		Directory: ".",
+	}, nil
+}
+
+// checkout checks out opts.Commit within the worktree of a clone (and
+// its submodules, if requested).
+func checkout(ctx context.Context, wt *git.Worktree, opts Options, am transport.AuthMethod) error {
+	if err := wt.Checkout(&git.CheckoutOptions{
+		Hash:  opts.Commit,
+		Force: true,
 	}); err != nil {
-		return nil, err
+		if errors.Is(err, plumbing.ErrObjectNotFound) && opts.Depth > 0 {
+			return fmt.Errorf("commit %s not found within depth %d: %w", opts.Commit, opts.Depth, err)
+		}
+		return fmt.Errorf("checking out commit %s: %w", opts.Commit, err)
+	}
+	if !opts.Submodules {
+		return nil
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return err
 	}
+	return subs.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              am,
+	})
+}
 
+// treeNames returns the names of everything in tree (joined with prefix).
+// When submodules is set, this descends into the checked out submodules
+// of repo, and otherwise the (empty) directories of submodules are elided.
+func treeNames(repo *git.Repository, tree *object.Tree, prefix string, submodules bool) ([]string, error) {
+	var names []string
 	w := object.NewTreeWalker(tree, true /* recursive */, make(map[plumbing.Hash]bool))
 	defer w.Close()
 	for {
-		name, _, err := w.Next()
+		name, entry, err := w.Next()
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
 			return nil, err
 		}
+		if entry.Mode != filemode.Submodule {
+			names = append(names, path.Join(prefix, name))
+			continue
+		}
+		if !submodules {
+			continue
+		}
 
-		if err := func() error {
-			// Do not chase symlinks.
-			info, err := fs.Lstat(name)
-			if err != nil {
-				return err
-			}
+		sub, err := submodule(repo, name)
+		if err != nil {
+			return nil, fmt.Errorf("error processing submodule %q: %w", name, err)
+		}
+		// The entry holds the commit of the submodule that is checked out.
+		commit, err := sub.CommitObject(entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("error processing submodule %q: %w", name, err)
+		}
+		subTree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		subNames, err := treeNames(sub, subTree, path.Join(prefix, name), submodules)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, path.Join(prefix, name))
+		names = append(names, subNames...)
+	}
+	return names, nil
+}
 
-			newPath := filepath.Join(kontext.StoragePath, name)
+// submodule returns the repository of the submodule checked out at the given
+// path within the worktree of repo.
+func submodule(repo *git.Repository, name string) (*git.Repository, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := wt.Submodules()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		if sub.Config().Path == name {
+			return sub.Repository()
+		}
+	}
+	return nil, git.ErrSubmoduleNotFound
+}
 
-			// Handle directories
-			if info.Mode().IsDir() {
-				return tw.WriteHeader(&tar.Header{
-					Name:     newPath,
-					Typeflag: tar.TypeDir,
-					Mode:     0555,
-				})
-			}
+// layer writes the named paths within fs into a single tarball layer backed
+// by a file within dir.  The names must be sorted, and include each of the
+// directories leading to the files.
+func layer(dir string, c bundles.Compression, fs billy.Filesystem, names []string, mtime time.Time) (v1.Layer, error) {
+	return bundles.NewLayer(dir, c, func(tw *tar.Writer) error {
+		// Add an entry for the root kontext directory
+		// This is to facilitate testing for compatibility with kontext.
+		if err := tw.WriteHeader(bundles.NormalizeHeader(&tar.Header{
+			Name:     kontext.StoragePath,
+			Typeflag: tar.TypeDir,
+			Mode:     0555,
+		}, mtime)); err != nil {
+			return err
+		}
 
-			// Handle symlinks
-			if info.Mode()&os.ModeSymlink != 0 {
-				linkname, err := fs.Readlink(name)
+		for _, name := range names {
+			if err := func() error {
+				// Do not chase symlinks.
+				info, err := fs.Lstat(name)
 				if err != nil {
 					return err
 				}
-				return tw.WriteHeader(&tar.Header{
-					Name:     newPath,
-					Typeflag: tar.TypeSymlink,
-					Mode:     0555,
-					Linkname: linkname,
-				})
-			}
 
-			// Open the file to copy it into the tarball.
-			file, err := fs.Open(name)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			// Copy the file into the image tarball.
-			if err := tw.WriteHeader(&tar.Header{
-				Name:     newPath,
-				Size:     info.Size(),
-				Typeflag: tar.TypeReg,
-				// Use a fixed Mode, so that this isn't sensitive to the directory and umask
-				// under which it was created. Additionally, windows can only set 0222,
-				// 0444, or 0666, none of which are executable.
-				Mode: 0555,
-			}); err != nil {
+				newPath := filepath.Join(kontext.StoragePath, name)
+
+				// Handle directories
+				if info.Mode().IsDir() {
+					return tw.WriteHeader(bundles.NormalizeHeader(&tar.Header{
+						Name:     newPath,
+						Typeflag: tar.TypeDir,
+						Mode:     0555,
+					}, mtime))
+				}
+
+				// Handle symlinks
+				if info.Mode()&os.ModeSymlink != 0 {
+					linkname, err := fs.Readlink(name)
+					if err != nil {
+						return err
+					}
+					return tw.WriteHeader(bundles.NormalizeHeader(&tar.Header{
+						Name:     newPath,
+						Typeflag: tar.TypeSymlink,
+						Mode:     0555,
+						Linkname: linkname,
+					}, mtime))
+				}
+
+				// Open the file to copy it into the tarball.
+				file, err := fs.Open(name)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+
+				// Copy the file into the image tarball.
+				if err := tw.WriteHeader(bundles.NormalizeHeader(&tar.Header{
+					Name:     newPath,
+					Size:     info.Size(),
+					Typeflag: tar.TypeReg,
+					// Use a fixed Mode, so that this isn't sensitive to the directory and umask
+					// under which it was created. Additionally, windows can only set 0222,
+					// 0444, or 0666, none of which are executable.
+					Mode: 0555,
+				}, mtime)); err != nil {
+					return err
+				}
+				_, err = io.Copy(tw, file)
 				return err
+			}(); err != nil {
+				return fmt.Errorf("error processing %q: %w", name, err)
 			}
-			_, err = io.Copy(tw, file)
-			return err
-		}(); err != nil {
-			return nil, fmt.Errorf("error processing %q: %w", name, err)
 		}
-	}
-
-	return tarball.LayerFromReader(bytes.NewBuffer(buf.Bytes()))
+		return nil
+	})
 }
 
 // Options contains a collection of options for configuring how things are bundled from git.
 type Options struct {
 	// URL contains the url from which to clone the git repository.
 	URL string
-	// Ref contains the ref to check out for bundling.
+	// Ref contains the ref (e.g. a branch or tag) to check out for bundling.
 	Ref plumbing.ReferenceName
+	// Commit contains a commit to check out for bundling, which takes
+	// precedence over Ref when set.
+	Commit plumbing.Hash
+
+	// Depth limits the clone to the specified number of commits, and is
+	// unlimited when zero.
+	Depth int
+	// Submodules indicates whether to check out (and bundle) submodules
+	// recursively.
+	Submodules bool
+
+	// Token contains a token with which to authenticate clones over http(s).
+	Token string
+	// SSHKey contains the path to a private key with which to authenticate
+	// clones over ssh.
+	SSHKey string
+
+	// Compression is how the layers of the bundle are compressed.
+	Compression bundles.Compression
+
+	// Secrets is how the bundle is scanned for secrets before it is
+	// published, when set.
+	Secrets *secrets.Policy
+
+	// Size bounds the size of the bundle before it is published, when set.
+	Size *kontext.SizeBudget
+
+	// DryRun stops short of publishing the bundle once it has been checked
+	// (see Secrets and Size), in which case the empty string is returned.
+	DryRun bool
 }
 
 // Bundle packages up the given git repo as a self-extracting container image based
-// on BaseImage and publishes it to tag.
-func Bundle(ctx context.Context, opts Options, tag name.Tag) (name.Digest, error) {
-	layer, err := bundle(ctx, opts)
+// on base (typically kontext.BaseImage) and writes it to target, returning a reference
+// to the result.  The image is annotated with the URL of the repository and the
+// commit that was bundled.  The options are passed along to bundles.MapTo.
+func Bundle(ctx context.Context, opts Options, base bundles.Base, target bundles.Target, mopts ...bundles.MapOption) (string, error) {
+	dir, err := ioutil.TempDir("", "git-bundle-")
 	if err != nil {
-		return name.Digest{}, err
+		return "", err
 	}
+	defer os.RemoveAll(dir)
 
-	return bundles.Map(ctx, kontext.BaseImage, tag, func(ctx context.Context, img v1.Image) (v1.Image, error) {
-		return mutate.AppendLayers(img, layer)
-	})
+	layer, prov, err := bundle(ctx, opts, dir)
+	if err != nil {
+		return "", err
+	}
+	anns, err := prov.Annotations([]v1.Layer{layer})
+	if err != nil {
+		return "", err
+	}
+	if err := kontext.CheckSecrets([]v1.Layer{layer}, opts.Secrets); err != nil {
+		return "", err
+	}
+	if err := kontext.CheckSize([]v1.Layer{layer}, opts.Size); err != nil {
+		return "", err
+	} else if opts.DryRun {
+		return "", nil
+	}
+	layers, err := kontext.WithManifest(dir, opts.Compression, []v1.Layer{layer})
+	if err != nil {
+		return "", err
+	}
+
+	return bundles.MapTo(ctx, base, target, anns, func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		return bundles.AppendLayers(img, layers...)
+	}, mopts...)
 }
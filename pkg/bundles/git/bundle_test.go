@@ -17,35 +17,72 @@ limitations under the License.
 package git
 
 import (
+	"archive/tar"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/kontext"
 )
 
 func TestBundleLayersWithRef(t *testing.T) {
 	// Check that if we bundle testdata it has the expected size.
-	l, err := bundle(context.Background(), Options{
+	opts := Options{
 		URL: "https://github.com/knative/pkg.git",
 		Ref: "refs/heads/release-0.10", // Pick something we've frozen
-	})
+	}
+	l, _, err := bundle(context.Background(), opts, t.TempDir())
 	if err != nil {
-		t.Error("bundle() =", err)
+		t.Fatal("bundle() =", err)
 	}
 	sz, err := l.Size()
 	if err != nil {
 		t.Error("l.Size() =", err)
 	}
-	if got, want := sz, int64(15246139); got != want {
-		t.Errorf("Size() = %d, wanted %d", got, want)
+	if sz == 0 {
+		t.Error("Size() = 0, wanted non-zero")
+	}
+
+	// Check that bundling the same frozen ref again produces the same layer.
+	again, _, err := bundle(context.Background(), opts, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	want, err := l.Digest()
+	if err != nil {
+		t.Fatal("l.Digest() =", err)
+	}
+	got, err := again.Digest()
+	if err != nil {
+		t.Fatal("again.Digest() =", err)
+	}
+	if got != want {
+		t.Errorf("Digest() = %v, wanted %v", got, want)
 	}
 }
 
 func TestBundleLayersNoRef(t *testing.T) {
 	// Check that if we bundle testdata it has the expected size.
-	l, err := bundle(context.Background(), Options{
+	l, _, err := bundle(context.Background(), Options{
 		URL: "https://github.com/mattmoor/boilerplate-check.git",
-	})
+	}, t.TempDir())
 	if err != nil {
-		t.Error("bundle() =", err)
+		t.Fatal("bundle() =", err)
 	}
 	sz, err := l.Size()
 	if err != nil {
@@ -55,3 +92,282 @@ func TestBundleLayersNoRef(t *testing.T) {
 		t.Error("Size() = 0, wanted non-zero")
 	}
 }
+
+// newRepo creates a git repository holding the given files (keyed by
+// slash-separated relative path) in a single commit, and returns a URL
+// from which it may be cloned.
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := git.PlainInit(dir, false /* isBare */); err != nil {
+		t.Fatal("PlainInit() =", err)
+	}
+	commitFiles(t, dir, files)
+	return "file://" + dir
+}
+
+// commitFiles writes the given files (keyed by slash-separated relative path)
+// into the git repository in dir, and commits them, returning the commit.
+func commitFiles(t *testing.T, dir string, files map[string]string) plumbing.Hash {
+	t.Helper()
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal("PlainOpen() =", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal("Worktree() =", err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal("os.MkdirAll() =", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("ioutil.WriteFile() =", err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal("Add() =", err)
+		}
+	}
+	h, err := wt.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "Test",
+			Email: "test@example.com",
+			When:  time.Unix(1234567890, 0),
+		},
+	})
+	if err != nil {
+		t.Fatal("Commit() =", err)
+	}
+	return h
+}
+
+// contents returns the names of the files in the layer mapped to their contents.
+func contents(t *testing.T, l v1.Layer) map[string]string {
+	t.Helper()
+	rc, err := l.Uncompressed()
+	if err != nil {
+		t.Fatal("Uncompressed() =", err)
+	}
+	defer rc.Close()
+
+	got := make(map[string]string)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal("Next() =", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal("ReadAll() =", err)
+		}
+		got[strings.TrimPrefix(hdr.Name, kontext.StoragePath+"/")] = string(b)
+	}
+	return got
+}
+
+func TestBundleLocal(t *testing.T) {
+	files := map[string]string{
+		"README.md":       "# hello",
+		"cmd/foo/main.go": "package main",
+		"pkg/bar/bar.go":  "package bar",
+	}
+	dir := t.TempDir()
+	url := newRepo(t, files)
+	l, prov, err := bundle(context.Background(), Options{URL: url}, dir)
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if diff := cmp.Diff(files, contents(t, l)); diff != "" {
+		t.Errorf("bundle() (-want, +got): %s", diff)
+	}
+
+	// Check that the provenance records where this came from.
+	repo, err := git.PlainOpen(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		t.Fatal("PlainOpen() =", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal("Head() =", err)
+	}
+	if diff := cmp.Diff(&bundles.Provenance{
+		Source:    url,
+		Revision:  head.Hash().String(),
+		Created:   time.Unix(1234567890, 0).UTC(),
+		Mode:      "git",
+		Directory: ".",
+	}, prov); diff != "" {
+		t.Errorf("bundle() (-want, +got): %s", diff)
+	}
+
+	// Check that the clone happened on disk within dir.
+	if _, err := os.Stat(filepath.Join(dir, "repo", ".git")); err != nil {
+		t.Error("os.Stat() =", err)
+	}
+}
+
+func TestBundleRevisions(t *testing.T) {
+	url := newRepo(t, map[string]string{"version.txt": "1"})
+	dir := strings.TrimPrefix(url, "file://")
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal("PlainOpen() =", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal("Head() =", err)
+	}
+	first := head.Hash()
+	if _, err := repo.CreateTag("v1", first, nil); err != nil {
+		t.Fatal("CreateTag() =", err)
+	}
+	second := commitFiles(t, dir, map[string]string{"version.txt": "2"})
+	commitFiles(t, dir, map[string]string{"version.txt": "3"})
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+		// commits is the number of commits we expect to have cloned.
+		commits int
+	}{{
+		name:    "head",
+		opts:    Options{URL: url},
+		want:    "3",
+		commits: 3,
+	}, {
+		name:    "shallow",
+		opts:    Options{URL: url, Depth: 1},
+		want:    "3",
+		commits: 1,
+	}, {
+		name:    "tag",
+		opts:    Options{URL: url, Ref: "refs/tags/v1"},
+		want:    "1",
+		commits: 1,
+	}, {
+		name:    "commit",
+		opts:    Options{URL: url, Commit: first},
+		want:    "1",
+		commits: 1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, _, err := bundle(context.Background(), test.opts, dir)
+			if err != nil {
+				t.Fatal("bundle() =", err)
+			}
+			if diff := cmp.Diff(map[string]string{"version.txt": test.want}, contents(t, l)); diff != "" {
+				t.Errorf("bundle() (-want, +got): %s", diff)
+			}
+
+			clone, err := git.PlainOpen(filepath.Join(dir, "repo"))
+			if err != nil {
+				t.Fatal("PlainOpen() =", err)
+			}
+			head, err := clone.Head()
+			if err != nil {
+				t.Fatal("Head() =", err)
+			}
+			iter, err := clone.Log(&git.LogOptions{From: head.Hash()})
+			if err != nil {
+				t.Fatal("Log() =", err)
+			}
+			commits := 0
+			if err := iter.ForEach(func(*object.Commit) error {
+				commits++
+				return nil
+			}); err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+				// Walking past the shallow boundary doesn't find parents.
+				t.Fatal("ForEach() =", err)
+			}
+			if commits != test.commits {
+				t.Errorf("cloned %d commits, wanted %d", commits, test.commits)
+			}
+		})
+	}
+
+	// A commit beyond the depth of the clone is an error.
+	if _, _, err := bundle(context.Background(), Options{URL: url, Commit: second, Depth: 1}, t.TempDir()); err == nil {
+		t.Error("bundle() = nil, wanted error")
+	}
+}
+
+func TestBundleSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is needed to add submodules:", err)
+	}
+	childURL := newRepo(t, map[string]string{"child.txt": "child"})
+	parentURL := newRepo(t, map[string]string{"parent.txt": "parent"})
+	parent := strings.TrimPrefix(parentURL, "file://")
+
+	for _, args := range [][]string{
+		{"-c", "protocol.file.allow=always", "submodule", "add", childURL, "sub"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-m", "add submodule"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = parent
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v = %v: %s", args, err, out)
+		}
+	}
+	head, err := exec.Command("git", "-C", parent, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal("git rev-parse =", err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string
+	}{{
+		name: "without submodules",
+		opts: Options{URL: parentURL},
+		want: map[string]string{
+			".gitmodules": fmt.Sprintf("[submodule \"sub\"]\n\tpath = sub\n\turl = %s\n", childURL),
+			"parent.txt":  "parent",
+		},
+	}, {
+		name: "with submodules",
+		opts: Options{URL: parentURL, Submodules: true},
+		want: map[string]string{
+			".gitmodules":   fmt.Sprintf("[submodule \"sub\"]\n\tpath = sub\n\turl = %s\n", childURL),
+			"parent.txt":    "parent",
+			"sub/child.txt": "child",
+		},
+	}, {
+		name: "commit with submodules",
+		opts: Options{
+			URL:        parentURL,
+			Commit:     plumbing.NewHash(strings.TrimSpace(string(head))),
+			Submodules: true,
+		},
+		want: map[string]string{
+			".gitmodules":   fmt.Sprintf("[submodule \"sub\"]\n\tpath = sub\n\turl = %s\n", childURL),
+			"parent.txt":    "parent",
+			"sub/child.txt": "child",
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, _, err := bundle(context.Background(), test.opts, t.TempDir())
+			if err != nil {
+				t.Fatal("bundle() =", err)
+			}
+			if diff := cmp.Diff(test.want, contents(t, l)); diff != "" {
+				t.Errorf("bundle() (-want, +got): %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/kontext"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
+)
+
+const (
+	// CommitAnnotation is the annotation on bundles of a git worktree that
+	// holds the commit that was checked out (HEAD).
+	CommitAnnotation = "dev.mink.bundle.git.commit"
+
+	// DirtyAnnotation is the annotation on bundles of a git worktree that
+	// indicates whether the tracked files differed from HEAD.
+	DirtyAnnotation = "dev.mink.bundle.git.dirty"
+)
+
+// WorktreeOptions contains a collection of options for configuring how
+// things are bundled from a local git working tree.
+type WorktreeOptions struct {
+	// Path is a directory within the working tree of the git repository.
+	Path string
+	// Compression is how the layers of the bundle are compressed.
+	Compression bundles.Compression
+	// Secrets is how the bundle is scanned for secrets before it is
+	// published, when set.
+	Secrets *secrets.Policy
+	// Size bounds the size of the bundle before it is published, when set.
+	Size *kontext.SizeBudget
+	// DryRun stops short of publishing the bundle once it has been checked
+	// (see Secrets and Size), in which case the empty string is returned.
+	DryRun bool
+}
+
+// worktree holds the result of bundling a local git working tree.
+type worktree struct {
+	// layer holds the tracked files of the working tree.
+	layer v1.Layer
+	// commit is the hash of HEAD, which is empty when there are no commits.
+	commit string
+	// dirty indicates whether any of the tracked files differ from HEAD.
+	dirty bool
+}
+
+// annotations returns the annotations with which to record the state of
+// the working tree on the bundle.
+func (w *worktree) annotations() map[string]string {
+	anns := map[string]string{
+		DirtyAnnotation: strconv.FormatBool(w.dirty),
+	}
+	if w.commit != "" {
+		anns[CommitAnnotation] = w.commit
+	}
+	return anns
+}
+
+// provenance returns the provenance of the bundle of the working tree
+// containing dir (see bundles.LocalProvenance).  We bundle the entire
+// working tree, not just dir, so it is dirty when any of the tracked files
+// differ from HEAD.
+func (w *worktree) provenance(dir string) (bundles.Provenance, error) {
+	prov, err := bundles.LocalProvenance("git-worktree", dir)
+	if err != nil {
+		return bundles.Provenance{}, err
+	}
+	prov.Directory = "."
+	prov.Dirty = w.dirty
+	return prov, nil
+}
+
+// bundleWorktree produces a layer holding the files tracked within the
+// working tree of the git repository containing opts.Path, with their
+// current contents (including any staged or unstaged modifications).
+// Untracked and ignored files are excluded, as are tracked files that
+// have been deleted from the working tree.
+func bundleWorktree(opts WorktreeOptions, dir string) (*worktree, error) {
+	repo, err := git.PlainOpenWithOptions(opts.Path, &git.PlainOpenOptions{
+		DetectDotGit: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	mtime, err := bundles.SourceDateEpoch()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &worktree{}
+	switch head, err := repo.Head(); {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// There are no commits yet.
+	case err != nil:
+		return nil, err
+	default:
+		result.commit = head.Hash().String()
+	}
+
+	for _, fs := range status {
+		if fs.Staging == git.Untracked || fs.Worktree == git.Untracked {
+			continue
+		}
+		if fs.Staging != git.Unmodified || fs.Worktree != git.Unmodified {
+			result.dirty = true
+			break
+		}
+	}
+
+	// The index holds the tracked files (including anything staged), but
+	// not the directories that contain them.
+	names := sets.NewString()
+	for _, e := range idx.Entries {
+		if fs, ok := status[e.Name]; ok && fs.Worktree == git.Deleted {
+			continue
+		}
+		for dir := path.Dir(e.Name); dir != "."; dir = path.Dir(dir) {
+			names.Insert(dir)
+		}
+		names.Insert(e.Name)
+	}
+
+	// List returns the names sorted.
+	result.layer, err = layer(dir, opts.Compression, wt.Filesystem, names.List(), mtime)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BundleWorktree packages up the tracked files of the given git working tree
+// as a self-extracting container image based on base (typically
+// kontext.BaseImage) and writes it to target, returning a reference to the
+// result.  The image is annotated with its provenance (see
+// bundles.LocalProvenance), and whether the tracked files have been modified
+// since the commit that is checked out.  The options are passed along to
+// bundles.MapTo.
+func BundleWorktree(ctx context.Context, opts WorktreeOptions, base bundles.Base, target bundles.Target, mopts ...bundles.MapOption) (string, error) {
+	dir, err := ioutil.TempDir("", "git-worktree-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	wt, err := bundleWorktree(opts, dir)
+	if err != nil {
+		return "", err
+	}
+	prov, err := wt.provenance(opts.Path)
+	if err != nil {
+		return "", err
+	}
+	anns, err := prov.Annotations([]v1.Layer{wt.layer})
+	if err != nil {
+		return "", err
+	}
+	for k, v := range wt.annotations() {
+		anns[k] = v
+	}
+	if err := kontext.CheckSecrets([]v1.Layer{wt.layer}, opts.Secrets); err != nil {
+		return "", err
+	}
+	if err := kontext.CheckSize([]v1.Layer{wt.layer}, opts.Size); err != nil {
+		return "", err
+	} else if opts.DryRun {
+		return "", nil
+	}
+	layers, err := kontext.WithManifest(dir, opts.Compression, []v1.Layer{wt.layer})
+	if err != nil {
+		return "", err
+	}
+
+	return bundles.MapTo(ctx, base, target, anns, func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		return bundles.AppendLayers(img, layers...)
+	}, mopts...)
+}
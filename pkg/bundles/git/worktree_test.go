@@ -0,0 +1,166 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/bundles"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func TestBundleWorktree(t *testing.T) {
+	dir := strings.TrimPrefix(newRepo(t, map[string]string{
+		".gitignore":   "*.log\n",
+		"main.go":      "package main",
+		"sub/lib.go":   "package sub",
+		"sub/other.go": "package sub",
+	}), "file://")
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal("PlainOpen() =", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal("Head() =", err)
+	}
+
+	// A clean worktree should match what was committed.
+	clean, err := bundleWorktree(WorktreeOptions{Path: filepath.Join(dir, "sub")}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundleWorktree() =", err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		DirtyAnnotation:  "false",
+		CommitAnnotation: head.Hash().String(),
+	}, clean.annotations()); diff != "" {
+		t.Errorf("annotations (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{
+		".gitignore":   "*.log\n",
+		"main.go":      "package main",
+		"sub/lib.go":   "package sub",
+		"sub/other.go": "package sub",
+	}, contents(t, clean.layer)); diff != "" {
+		t.Errorf("bundleWorktree() (-want, +got): %s", diff)
+	}
+
+	// Modify, stage, delete and add untracked and ignored files.
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal("ioutil.WriteFile() =", err)
+		}
+	}
+	writeFile("main.go", "package main // modified")
+	writeFile("staged.go", "package main // staged")
+	writeFile("untracked.go", "package main // untracked")
+	writeFile("debug.log", "ignored")
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal("Worktree() =", err)
+	}
+	if _, err := wt.Add("staged.go"); err != nil {
+		t.Fatal("Add() =", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub/other.go")); err != nil {
+		t.Fatal("os.Remove() =", err)
+	}
+
+	dirty, err := bundleWorktree(WorktreeOptions{Path: dir}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundleWorktree() =", err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		DirtyAnnotation:  "true",
+		CommitAnnotation: head.Hash().String(),
+	}, dirty.annotations()); diff != "" {
+		t.Errorf("annotations (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{
+		".gitignore": "*.log\n",
+		"main.go":    "package main // modified",
+		"staged.go":  "package main // staged",
+		"sub/lib.go": "package sub",
+	}, contents(t, dirty.layer)); diff != "" {
+		t.Errorf("bundleWorktree() (-want, +got): %s", diff)
+	}
+}
+
+func TestWorktreeProvenance(t *testing.T) {
+	dir := strings.TrimPrefix(newRepo(t, map[string]string{
+		"main.go":    "package main",
+		"sub/lib.go": "package sub",
+	}), "file://")
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal("PlainOpen() =", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal("Head() =", err)
+	}
+
+	// annotations returns the annotations of the bundle of the worktree,
+	// from within sub.
+	annotations := func() map[string]string {
+		t.Helper()
+		wt, err := bundleWorktree(WorktreeOptions{Path: filepath.Join(dir, "sub")}, t.TempDir())
+		if err != nil {
+			t.Fatal("bundleWorktree() =", err)
+		}
+		prov, err := wt.provenance(filepath.Join(dir, "sub"))
+		if err != nil {
+			t.Fatal("provenance() =", err)
+		}
+		anns, err := prov.Annotations([]v1.Layer{wt.layer})
+		if err != nil {
+			t.Fatal("Annotations() =", err)
+		}
+		for k, v := range wt.annotations() {
+			anns[k] = v
+		}
+		return anns
+	}
+
+	anns := annotations()
+	if got, want := anns[ocispec.AnnotationRevision], head.Hash().String(); got != want {
+		t.Errorf("revision = %q, wanted %q", got, want)
+	}
+	if got, want := anns[bundles.DirectoryAnnotation], "."; got != want {
+		t.Errorf("directory = %q, wanted %q", got, want)
+	}
+
+	// Modifying a file outside of sub still changes the bundle.
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // modified"), 0644); err != nil {
+		t.Fatal("ioutil.WriteFile() =", err)
+	}
+	anns = annotations()
+	if got, ok := anns[ocispec.AnnotationRevision]; ok {
+		t.Errorf("revision = %q, wanted none for a modified worktree", got)
+	}
+	if got, want := anns[DirtyAnnotation], "true"; got != want {
+		t.Errorf("dirty = %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// PlatformImage is an image that was built for a particular platform.
+type PlatformImage struct {
+	// Platform is the platform for which Image was built.  When nil, it is
+	// taken from the configuration of Image.
+	Platform *v1.Platform
+
+	// Image is the image built for Platform.
+	Image v1.Image
+}
+
+// Index combines the given images (e.g. the outputs of per-platform builds)
+// into an image index, whose descriptors record the platform of each image.
+// The index is a Docker manifest list when all of the images are Docker
+// images, and an OCI image index otherwise.
+func Index(imgs ...PlatformImage) (v1.ImageIndex, error) {
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("no images to combine")
+	}
+
+	mt := types.DockerManifestList
+	adds := make([]mutate.IndexAddendum, 0, len(imgs))
+	seen := make(map[string]struct{}, len(imgs))
+	for _, pi := range imgs {
+		platform := pi.Platform
+		if platform == nil {
+			cf, err := pi.Image.ConfigFile()
+			if err != nil {
+				return nil, err
+			}
+			if cf.OS == "" || cf.Architecture == "" {
+				return nil, fmt.Errorf("image does not record its platform in its configuration")
+			}
+			platform = &v1.Platform{OS: cf.OS, Architecture: cf.Architecture, OSVersion: cf.OSVersion}
+		}
+		if _, ok := seen[platform.String()]; ok {
+			return nil, fmt.Errorf("saw multiple images for the platform %s", platform)
+		}
+		seen[platform.String()] = struct{}{}
+
+		imt, err := pi.Image.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if imt != types.DockerManifestSchema2 {
+			mt = types.OCIImageIndex
+		}
+
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        pi.Image,
+			Descriptor: v1.Descriptor{Platform: platform},
+		})
+	}
+	return mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), mt), nil
+}
+
+// Combine fetches the images at the given digests, which are keyed by the
+// platform for which they were built (e.g. linux/arm64/v8), combines them
+// into an image index via Index, and publishes it to tag.  The digest of the
+// resulting image index is returned upon success.
+func Combine(ctx context.Context, tag name.Tag, digests map[string]name.Digest) (name.Digest, error) {
+	// Order the images by platform, so that the result is reproducible.
+	keys := make([]string, 0, len(digests))
+	for k := range digests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	imgs := make([]PlatformImage, 0, len(keys))
+	for _, k := range keys {
+		platform, err := v1.ParsePlatform(k)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		mt, desc, err := (&RegistryBase{Reference: digests[k]}).get(ctx)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		if mt.IsIndex() {
+			return name.Digest{}, fmt.Errorf("the image for %s is an image index: %s", k, digests[k])
+		}
+		img, err := desc.Image()
+		if err != nil {
+			return name.Digest{}, err
+		}
+		imgs = append(imgs, PlatformImage{Platform: platform, Image: img})
+	}
+
+	ii, err := Index(imgs...)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	hash, err := ii.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	target := &RegistryTarget{Tag: tag}
+	if err := target.writeIndex(ctx, ii); err != nil {
+		return name.Digest{}, err
+	}
+	return name.NewDigest(target.Reference(hash))
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// randomPlatformImage returns a random image whose configuration records the
+// given platform.
+func randomPlatformImage(t *testing.T, os, arch string) v1.Image {
+	t.Helper()
+	img, err := random.Image(3, 1)
+	if err != nil {
+		t.Fatal("random.Image() =", err)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal("ConfigFile() =", err)
+	}
+	cf = cf.DeepCopy()
+	cf.OS, cf.Architecture = os, arch
+	if img, err = mutate.ConfigFile(img, cf); err != nil {
+		t.Fatal("ConfigFile() =", err)
+	}
+	return img
+}
+
+func TestIndex(t *testing.T) {
+	amd64 := randomPlatformImage(t, "linux", "amd64")
+	arm64 := randomPlatformImage(t, "linux", "arm64")
+
+	ii, err := Index(
+		PlatformImage{Image: amd64},
+		PlatformImage{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Image: arm64},
+	)
+	if err != nil {
+		t.Fatal("Index() =", err)
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal("IndexManifest() =", err)
+	}
+	if got, want := im.MediaType, types.DockerManifestList; got != want {
+		t.Errorf("MediaType = %s, wanted %s", got, want)
+	}
+	var got []string
+	for _, desc := range im.Manifests {
+		got = append(got, desc.Platform.String())
+	}
+	if diff := cmp.Diff([]string{"linux/amd64", "linux/arm64/v8"}, got); diff != "" {
+		t.Errorf("Platforms (-want, +got): %s", diff)
+	}
+
+	// OCI images produce an OCI index.
+	oci := mutate.MediaType(amd64, types.OCIManifestSchema1)
+	if ii, err := Index(PlatformImage{Image: oci}); err != nil {
+		t.Fatal("Index() =", err)
+	} else if mt, err := ii.MediaType(); err != nil || mt != types.OCIImageIndex {
+		t.Errorf("MediaType() = %s, %v, wanted %s", mt, err, types.OCIImageIndex)
+	}
+
+	// Each platform may only appear once.
+	if _, err := Index(PlatformImage{Image: amd64}, PlatformImage{Image: amd64}); err == nil {
+		t.Error("Index() = nil, wanted error for duplicate platforms")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	imgs := map[string]v1.Image{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		img := randomPlatformImage(t, "linux", arch)
+		h, err := img.Digest()
+		if err != nil {
+			t.Fatal("Digest() =", err)
+		}
+		imgs[h.String()] = img
+	}
+	digests := map[string]name.Digest{}
+	for h, img := range imgs {
+		cf, _ := img.ConfigFile()
+		digests[cf.OS+"/"+cf.Architecture], _ = name.NewDigest("gcr.io/buffoon/banana@" + h)
+	}
+
+	remoteGet = func(ref name.Reference, _ ...remote.Option) (types.MediaType, descriptor, error) {
+		img := imgs[ref.Identifier()]
+		return types.DockerManifestSchema2, &descriptorImpl{i: img}, nil
+	}
+	var written v1.ImageIndex
+	remoteWriteIndex = func(_ name.Reference, ii v1.ImageIndex, _ ...remote.Option) error {
+		written = ii
+		return nil
+	}
+
+	tag, _ := name.NewTag("gcr.io/buffoon/banana:latest")
+	digest, err := Combine(context.Background(), tag, digests)
+	if err != nil {
+		t.Fatal("Combine() =", err)
+	}
+	if h, err := written.Digest(); err != nil {
+		t.Fatal("Digest() =", err)
+	} else if !strings.HasSuffix(digest.String(), "@"+h.String()) {
+		t.Errorf("Combine() = %s, wanted the digest %s", digest, h)
+	}
+	im, err := written.IndexManifest()
+	if err != nil {
+		t.Fatal("IndexManifest() =", err)
+	}
+	for _, desc := range im.Manifests {
+		if want := digests[desc.Platform.String()].DigestStr(); desc.Digest.String() != want {
+			t.Errorf("%s: Digest = %s, wanted %s", desc.Platform, desc.Digest, want)
+		}
+	}
+
+	// Indices can't be combined.
+	remoteGet = func(ref name.Reference, _ ...remote.Option) (types.MediaType, descriptor, error) {
+		ii, err := random.Index(3, 1, 1)
+		return types.OCIImageIndex, &descriptorImpl{ii: ii}, err
+	}
+	if _, err := Combine(context.Background(), tag, digests); err == nil {
+		t.Error("Combine() = nil, wanted error")
+	}
+}
@@ -18,17 +18,23 @@ package kontext
 
 import (
 	"archive/tar"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
 )
 
 var (
@@ -39,83 +45,295 @@ var (
 	BaseImage, _ = name.ParseReference(BaseImageString)
 )
 
-func bundle(directory string) (v1.Layer, error) {
-	buf := bytes.NewBuffer(nil)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
+// Options holds the configuration for bundling up a local directory.
+type Options struct {
+	// Directory is the local directory to bundle.
+	Directory string
 
-	err := filepath.Walk(directory,
-		func(path string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	// Includes holds additional local directories whose contents are
+	// merged into the bundle, which take precedence over opts.Directory
+	// (and over earlier includes) where they overlap.
+	Includes []Include
 
-			// Skip anything in the .git directory
-			// TODO(mattmoor): expand this to .gitignore / .dockerignore?
-			if fi.IsDir() && filepath.Base(path) == ".git" {
-				return filepath.SkipDir
-			}
+	// IgnoreFiles holds the names of the gitignore-style files that are
+	// consulted in each directory of the walk to exclude paths from the
+	// bundle (see DefaultIgnoreFiles).
+	IgnoreFiles []string
+
+	// MaxLayers is the maximum number of layers into which the bundle is
+	// split (see partition), values less than two produce a single layer.
+	MaxLayers int
+
+	// Fidelity indicates that the bundle should record the actual modes of
+	// files and directories, and record symlinks as symlinks instead of
+	// following them.
+	Fidelity bool
+
+	// Compression is how the layers of the bundle are compressed.
+	Compression bundles.Compression
+
+	// Secrets is how the bundle is scanned for secrets before it is
+	// published, when set.
+	Secrets *secrets.Policy
+
+	// Size bounds the size of the bundle before it is published, when set.
+	Size *SizeBudget
+
+	// DryRun stops short of publishing the bundle once it has been checked
+	// (see Secrets and Size), in which case the empty string is returned.
+	DryRun bool
+}
+
+// entry is a single path that is included in the bundle.
+type entry struct {
+	// path is the location of the entry on the local filesystem.
+	path string
+	// name is the slash-separated path of the entry relative to StoragePath.
+	name string
+	// info holds the result of stat-ing path.
+	info os.FileInfo
+	// mode holds the permission bits to record for the entry.
+	mode int64
+	// linkname holds the target of the entry when it is a symlink.
+	linkname string
+}
+
+// header returns the tar header for the entry stamped with mtime.
+func (e entry) header(mtime time.Time) *tar.Header {
+	hdr := &tar.Header{
+		Name: path.Join(StoragePath, e.name),
+		Mode: e.mode,
+	}
+	switch {
+	case e.info.IsDir():
+		hdr.Typeflag = tar.TypeDir
+	case e.info.Mode()&os.ModeSymlink != 0:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = e.linkname
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.info.Size()
+	}
+	return bundles.NormalizeHeader(hdr, mtime)
+}
+
+// enumerate returns the entries that make up the bundle sorted by name.
+func enumerate(opts Options) ([]entry, error) {
+	var entries []entry
+	if err := walk(opts, func(path, relativePath string, info os.FileInfo) error {
+		e := entry{
+			path: path,
+			name: filepath.ToSlash(relativePath),
+			info: info,
+			// Use a fixed Mode, so that this isn't sensitive to the directory and umask
+			// under which it was created. Additionally, windows can only set 0222,
+			// 0444, or 0666, none of which are executable.
+			mode: 0555,
+		}
 
+		if opts.Fidelity {
+			// The walk does not chase symlinks, so record them as-is.
+			e.mode = int64(info.Mode().Perm())
+			if info.Mode()&os.ModeSymlink != 0 {
+				linkname, err := os.Readlink(path)
+				if err != nil {
+					return fmt.Errorf("error processing %q: %w", path, err)
+				}
+				e.linkname = linkname
+			}
+		} else {
 			// Chase symlinks.
 			info, err := os.Stat(path)
 			if err != nil {
-				return err
+				return fmt.Errorf("error processing %q: %w", path, err)
 			}
+			e.info = info
+		}
 
-			// Compute the path relative to the base path
-			relativePath, err := filepath.Rel(directory, path)
-			if err != nil {
-				return err
-			}
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	entries, err := overlay(entries)
+	if err != nil {
+		return nil, err
+	}
 
-			newPath := filepath.Join(StoragePath, relativePath)
+	// Don't depend on the order in which the filesystem is walked.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
 
-			if info.Mode().IsDir() {
-				return tw.WriteHeader(&tar.Header{
-					Name:     newPath,
-					Typeflag: tar.TypeDir,
-					Mode:     0555,
-				})
+// layer writes the given entries into a single tarball layer backed by a
+// file within dir.  Each of the ancestor directories of the entries (looked
+// up in dirs) is included as well, so that layers may be extracted
+// independently of one another.
+func layer(dir string, c bundles.Compression, entries []entry, dirs map[string]entry, mtime time.Time) (v1.Layer, error) {
+	return bundles.NewLayer(dir, c, func(tw *tar.Writer) error {
+		written := make(map[string]struct{}, len(entries))
+		writeDir := func(name string) error {
+			if _, ok := written[name]; ok {
+				return nil
 			}
+			written[name] = struct{}{}
+			return tw.WriteHeader(dirs[name].header(mtime))
+		}
 
-			// Open the file to copy it into the tarball.
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			// Copy the file into the image tarball.
-			if err := tw.WriteHeader(&tar.Header{
-				Name:     newPath,
-				Size:     info.Size(),
-				Typeflag: tar.TypeReg,
-				// Use a fixed Mode, so that this isn't sensitive to the directory and umask
-				// under which it was created. Additionally, windows can only set 0222,
-				// 0444, or 0666, none of which are executable.
-				Mode: 0555,
-			}); err != nil {
+		for _, e := range entries {
+			if err := func() error {
+				// Write any ancestor directories we haven't seen yet.
+				if e.name != "." {
+					parts := strings.Split(e.name, "/")
+					for i := range parts {
+						dir := "."
+						if i > 0 {
+							dir = path.Join(parts[:i]...)
+						}
+						if err := writeDir(dir); err != nil {
+							return err
+						}
+					}
+				}
+
+				hdr := e.header(mtime)
+				switch hdr.Typeflag {
+				case tar.TypeDir:
+					return writeDir(e.name)
+				case tar.TypeSymlink:
+					return tw.WriteHeader(hdr)
+				}
+
+				// Open the file to copy it into the tarball.
+				file, err := os.Open(e.path)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+
+				// Copy the file into the image tarball.
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				_, err = io.Copy(tw, file)
 				return err
+			}(); err != nil {
+				return fmt.Errorf("error processing %q: %w", e.path, err)
 			}
-			_, err = io.Copy(tw, file)
-			return err
-		})
+		}
+		return nil
+	})
+}
+
+// bundle produces the layers that hold the contents of the configured
+// directory, which are backed by files within dir.  The size of the files
+// is checked against opts.Size before any layers are written.
+func bundle(opts Options, dir string) ([]v1.Layer, error) {
+	mtime, err := bundles.SourceDateEpoch()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := enumerate(opts)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkEntries(entries, opts.Size); err != nil {
+		return nil, err
+	}
 
-	return tarball.LayerFromReader(bytes.NewBuffer(buf.Bytes()))
+	dirs := make(map[string]entry)
+	for _, e := range entries {
+		if e.info.IsDir() {
+			dirs[e.name] = e
+		}
+	}
+
+	groups := partition(entries, opts.MaxLayers)
+	layers := make([]v1.Layer, 0, len(groups))
+	for _, group := range groups {
+		l, err := layer(dir, opts.Compression, group, dirs, mtime)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+	return layers, nil
 }
 
-// Bundle packages up the given directory as a self-extracting container image based
-// on BaseImage and publishes it to tag.
-func Bundle(ctx context.Context, directory string, tag name.Tag) (name.Digest, error) {
-	layer, err := bundle(directory)
+// Fingerprint returns a digest of the entries that would be bundled with the
+// given options (their names, modes, link targets and contents).  This is far
+// cheaper than producing the bundle, so it is suitable for checking whether
+// anything has changed since a previous bundle was produced.
+func Fingerprint(opts Options) (v1.Hash, error) {
+	entries, err := enumerate(opts)
 	if err != nil {
-		return name.Digest{}, err
+		return v1.Hash{}, err
 	}
 
-	return bundles.Map(ctx, BaseImage, tag, func(ctx context.Context, img v1.Image) (v1.Image, error) {
-		return mutate.AppendLayers(img, layer)
-	})
+	h := sha256.New()
+	for _, e := range entries {
+		hdr := e.header(time.Time{})
+		fmt.Fprintf(h, "%q %c %o %q", hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Linkname)
+		if hdr.Typeflag == tar.TypeReg {
+			if err := func() error {
+				f, err := os.Open(e.path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				digest, _, err := v1.SHA256(f)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, " %s", digest)
+				return nil
+			}(); err != nil {
+				return v1.Hash{}, fmt.Errorf("error processing %q: %w", e.path, err)
+			}
+		}
+		fmt.Fprintln(h)
+	}
+	return v1.Hash{
+		Algorithm: "sha256",
+		Hex:       hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Bundle packages up the configured directory as a self-extracting container image
+// based on base (typically BaseImage) and writes it to target, returning a reference
+// to the result.  The image is annotated with the provenance of the directory (see
+// bundles.LocalProvenance).  The options are passed along to bundles.MapTo.
+func Bundle(ctx context.Context, opts Options, base bundles.Base, target bundles.Target, mopts ...bundles.MapOption) (string, error) {
+	dir, err := ioutil.TempDir("", "kontext-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	layers, err := bundle(opts, dir)
+	if err != nil {
+		return "", err
+	}
+	prov, err := bundles.LocalProvenance("kontext", opts.Directory)
+	if err != nil {
+		return "", err
+	}
+	anns, err := prov.Annotations(layers)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckSecrets(layers, opts.Secrets); err != nil {
+		return "", err
+	} else if opts.DryRun {
+		return "", nil
+	}
+	if layers, err = WithManifest(dir, opts.Compression, layers); err != nil {
+		return "", err
+	}
+
+	return bundles.MapTo(ctx, base, target, anns, func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		return bundles.AppendLayers(img, layers...)
+	}, mopts...)
 }
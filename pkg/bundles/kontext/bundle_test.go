@@ -17,20 +17,257 @@ limitations under the License.
 package kontext
 
 import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mattmoor/mink/pkg/bundles"
 )
 
 func TestBundleLayerIndex(t *testing.T) {
 	// Check that if we bundle testdata it has the expected size.
-	l, err := bundle("./testdata")
+	ls, err := bundle(Options{Directory: "./testdata"}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if got, want := len(ls), 1; got != want {
+		t.Fatalf("len(bundle()) = %d, wanted %d", got, want)
+	}
+	rc, err := ls[0].Uncompressed()
 	if err != nil {
-		t.Error("bundle() =", err)
+		t.Fatal("l.Uncompressed() =", err)
 	}
-	sz, err := l.Size()
+	defer rc.Close()
+	sz, err := io.Copy(ioutil.Discard, rc)
 	if err != nil {
-		t.Error("l.Size() =", err)
+		t.Fatal("io.Copy() =", err)
 	}
-	if got, want := sz, int64(204); got != want {
+	// Three directories and two files (each a header and a block of content),
+	// followed by the two blocks that terminate the archive.
+	if got, want := sz, int64(9*512); got != want {
 		t.Errorf("Size() = %d, wanted %d", got, want)
 	}
 }
+
+func TestBundleReproducible(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a/b/c.txt": "c",
+		"d.txt":     "d",
+	})
+
+	before, err := bundle(Options{Directory: dir, MaxLayers: DefaultMaxLayers}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+
+	// Change the modification times of everything, which shouldn't matter.
+	later := time.Now().Add(time.Hour)
+	for _, p := range []string{"a", "a/b", "a/b/c.txt", "d.txt"} {
+		if err := os.Chtimes(filepath.Join(dir, p), later, later); err != nil {
+			t.Fatal("os.Chtimes() =", err)
+		}
+	}
+	after, err := bundle(Options{Directory: dir, MaxLayers: DefaultMaxLayers}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if diff := cmp.Diff(digests(t, before), digests(t, after)); diff != "" {
+		t.Errorf("bundle() (-before, +after): %s", diff)
+	}
+
+	// Check that the headers are stamped with SOURCE_DATE_EPOCH.
+	t.Setenv(bundles.SourceDateEpochEnv, "1234567890")
+	stamped, err := bundle(Options{Directory: dir}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	rc, err := stamped[0].Uncompressed()
+	if err != nil {
+		t.Fatal("l.Uncompressed() =", err)
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal("Next() =", err)
+		}
+		if got, want := hdr.ModTime.Unix(), int64(1234567890); got != want {
+			t.Errorf("%s: ModTime = %d, wanted %d", hdr.Name, got, want)
+		}
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("%s: got ownership %d:%d (%s:%s), wanted 0:0", hdr.Name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a/b/c.txt": "c",
+		"d.txt":     "d",
+	})
+	fingerprint := func(opts Options) string {
+		t.Helper()
+		h, err := Fingerprint(opts)
+		if err != nil {
+			t.Fatal("Fingerprint() =", err)
+		}
+		return h.String()
+	}
+	before := fingerprint(Options{Directory: dir})
+
+	// Modification times don't matter.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "d.txt"), later, later); err != nil {
+		t.Fatal("os.Chtimes() =", err)
+	}
+	if got := fingerprint(Options{Directory: dir}); got != before {
+		t.Errorf("Fingerprint() = %s, wanted %s", got, before)
+	}
+
+	// But the modes recorded with fidelity do.
+	if got := fingerprint(Options{Directory: dir, Fidelity: true}); got == before {
+		t.Error("Fingerprint() didn't change with Fidelity")
+	}
+
+	// As do contents.
+	writeTree(t, dir, map[string]string{"a/b/c.txt": "changed"})
+	if got := fingerprint(Options{Directory: dir}); got == before {
+		t.Error("Fingerprint() didn't change with the contents of a file")
+	}
+}
+
+func TestBundleFidelity(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"script.sh": "#!/bin/sh",
+		"data.txt":  "data",
+	})
+	if err := os.Chmod(filepath.Join(dir, "script.sh"), 0755); err != nil {
+		t.Fatal("os.Chmod() =", err)
+	}
+	if err := os.Symlink("data.txt", filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal("os.Symlink() =", err)
+	}
+
+	tests := []struct {
+		name     string
+		fidelity bool
+		want     map[string]*tar.Header
+	}{{
+		name: "default",
+		want: map[string]*tar.Header{
+			"data.txt":  {Typeflag: tar.TypeReg, Mode: 0555},
+			"link.txt":  {Typeflag: tar.TypeReg, Mode: 0555},
+			"script.sh": {Typeflag: tar.TypeReg, Mode: 0555},
+		},
+	}, {
+		name:     "fidelity",
+		fidelity: true,
+		want: map[string]*tar.Header{
+			"data.txt":  {Typeflag: tar.TypeReg, Mode: 0644},
+			"link.txt":  {Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: "data.txt"},
+			"script.sh": {Typeflag: tar.TypeReg, Mode: 0755},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ls, err := bundle(Options{Directory: dir, Fidelity: test.fidelity}, t.TempDir())
+			if err != nil {
+				t.Fatal("bundle() =", err)
+			}
+			rc, err := ls[0].Uncompressed()
+			if err != nil {
+				t.Fatal("l.Uncompressed() =", err)
+			}
+			defer rc.Close()
+
+			got := make(map[string]*tar.Header, len(test.want))
+			tr := tar.NewReader(rc)
+			for {
+				hdr, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatal("Next() =", err)
+				}
+				if hdr.Typeflag == tar.TypeDir {
+					continue
+				}
+				got[strings.TrimPrefix(hdr.Name, StoragePath+"/")] = &tar.Header{
+					Typeflag: hdr.Typeflag,
+					Mode:     hdr.Mode,
+					Linkname: hdr.Linkname,
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("bundle() (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+// allocated returns the number of bytes allocated on the heap while
+// bundling up (and digesting) the given directory.  Since this counts
+// every allocation, it bounds the peak memory used in doing so.
+func allocated(t *testing.T, dir string) uint64 {
+	t.Helper()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	ls, err := bundle(Options{Directory: dir}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	for _, l := range ls {
+		if _, err := l.Digest(); err != nil {
+			t.Fatal("Digest() =", err)
+		}
+	}
+
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestBundleMemory(t *testing.T) {
+	const mb = 1 << 20
+
+	// Write a file of the given size with contents that don't compress.
+	grow := func(t *testing.T, size int) string {
+		t.Helper()
+		dir := t.TempDir()
+		buf := make([]byte, size)
+		if _, err := rand.New(rand.NewSource(int64(size))).Read(buf); err != nil {
+			t.Fatal("Read() =", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "blob"), buf, 0644); err != nil {
+			t.Fatal("ioutil.WriteFile() =", err)
+		}
+		return dir
+	}
+	small, large := grow(t, 8*mb), grow(t, 32*mb)
+
+	smallAlloc, largeAlloc := allocated(t, small), allocated(t, large)
+	if largeAlloc > 8*mb {
+		t.Errorf("bundling 32MB allocated %dMB, wanted it bounded", largeAlloc/mb)
+	}
+	if largeAlloc > smallAlloc+2*mb {
+		t.Errorf("bundling 32MB allocated %dMB, but 8MB allocated %dMB; wanted it flat",
+			largeAlloc/mb, smallAlloc/mb)
+	}
+}
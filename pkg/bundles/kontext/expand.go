@@ -18,11 +18,21 @@ package kontext
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"knative.dev/pkg/pool"
 )
 
@@ -31,30 +41,201 @@ const (
 	StoragePath = "/var/run/kontext"
 )
 
-func copy(src, dest string) error {
+// copy copies src to dest, returning the digest of the copied contents.
+func copy(src, dest string, perm os.FileMode) (v1.Hash, error) {
 	from, err := os.Open(src)
 	if err != nil {
-		return err
+		return v1.Hash{}, err
 	}
 	defer from.Close()
 
-	to, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	// Replace anything already at dest rather than writing through it, as
+	// it may be a symlink (and so that perm applies).
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return v1.Hash{}, err
+	}
+	to, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
 	if err != nil {
-		return err
+		return v1.Hash{}, err
 	}
 	defer to.Close()
 
-	_, err = io.Copy(to, from)
-	return err
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(to, h), from); err != nil {
+		return v1.Hash{}, err
+	}
+	return v1.Hash{
+		Algorithm: "sha256",
+		Hex:       hex.EncodeToString(h.Sum(nil)),
+	}, to.Close()
+}
+
+// matches checks whether the file at path is a regular file with the
+// given digest, e.g. because a previous expansion already put it there.
+func matches(path string, want v1.Hash) bool {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	got, _, err := v1.SHA256(f)
+	return err == nil && got == want
+}
+
+// symlink recreates the symlink src at dest, unless its target escapes
+// the root directory into which we are expanding things.
+func symlink(src, dest, root string) error {
+	linkname, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	return link(linkname, dest, root)
+}
+
+// link creates a symlink to linkname at dest, unless linkname escapes the
+// root directory into which we are expanding things.
+func link(linkname, dest, root string) error {
+	if filepath.IsAbs(linkname) {
+		log.Printf("Skipping absolute symlink: %q -> %q", dest, linkname)
+		return nil
+	}
+	if esc, err := escapes(linkname, dest, root); err != nil {
+		return err
+	} else if esc {
+		log.Printf("Skipping symlink that escapes the workspace: %q -> %q", dest, linkname)
+		return nil
+	}
+
+	// Replace anything already at the destination.
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Symlink(linkname, dest)
+}
+
+// maxLinks bounds the number of symlinks that escapes will follow, in the
+// spirit of ELOOP.
+const maxLinks = 255
+
+// escapes checks whether a symlink to linkname at dest would refer to
+// something outside of root.  Rather than cleaning the path lexically, this
+// follows any symlinks already beneath root one component at a time (as the
+// kernel would), since e.g. "d/d/../.." escapes when d is a symlink to ".".
+// Symlink loops and absolute symlinks along the way count as escaping.
+func escapes(linkname, dest, root string) (bool, error) {
+	rel, err := filepath.Rel(root, filepath.Dir(dest))
+	if err != nil {
+		return false, err
+	}
+	split := func(p string) []string {
+		return strings.Split(filepath.ToSlash(p), "/")
+	}
+	pending := append(split(rel), split(linkname)...)
+
+	var resolved []string
+	for links := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return true, nil
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		resolved = append(resolved, part)
+		p := filepath.Join(root, filepath.Join(resolved...))
+		info, err := os.Lstat(p)
+		switch {
+		case os.IsNotExist(err), errors.Is(err, syscall.ENOTDIR):
+			continue
+		case err != nil:
+			return false, err
+		case info.Mode()&os.ModeSymlink == 0:
+			continue
+		}
+
+		if links++; links > maxLinks {
+			return true, nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return false, err
+		}
+		if filepath.IsAbs(target) {
+			return true, nil
+		}
+		resolved = resolved[:len(resolved)-1]
+		pending = append(split(target), pending...)
+	}
+	return false, nil
 }
 
-func expand(ctx context.Context, base string) error {
+// removeEscapingLinks checks the given symlinks beneath root again once
+// everything is in place, since a symlink may change where those before it
+// resolve to, and removes those that escape root until none do.
+func removeEscapingLinks(links []string, root string) error {
+	for removed := true; removed; {
+		removed = false
+		for _, l := range links {
+			linkname, err := os.Readlink(l)
+			if err != nil {
+				// It was since replaced, removed or never created.
+				continue
+			}
+			if esc, err := escapes(linkname, l, root); err != nil {
+				return err
+			} else if !esc {
+				continue
+			}
+			log.Printf("Removing symlink that escapes the workspace: %q -> %q", l, linkname)
+			if err := os.Remove(l); err != nil {
+				return err
+			}
+			removed = true
+		}
+	}
+	return nil
+}
+
+// ExpandOptions holds the configuration for expanding a bundle.
+type ExpandOptions struct {
+	// Parallelism is the number of files to expand concurrently.
+	Parallelism int
+}
+
+// DefaultParallelism is the default value of ExpandOptions.Parallelism.
+var DefaultParallelism = 4 * runtime.NumCPU()
+
+// expand copies the files under base into the working directory.  When m is
+// non-nil, the files are verified against it: files that are already present
+// with the expected contents are skipped, and files whose contents don't
+// match, that aren't in the manifest, or that are missing are errors.
+func expand(ctx context.Context, base string, m *manifest, opts ExpandOptions) error {
 	targetPath, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
-	eg, ctx := pool.NewWithContext(ctx, 100 /* workers */, 100 /* capacity */)
+	if opts.Parallelism < 1 {
+		return fmt.Errorf("parallelism must be at least 1, got %d", opts.Parallelism)
+	}
+
+	var (
+		mu    sync.Mutex
+		seen  = make(map[string]struct{})
+		dirs  = make(map[string]os.FileMode)
+		links []string
+	)
+	eg, ctx := pool.NewWithContext(ctx, opts.Parallelism /* workers */, opts.Parallelism /* capacity */)
 	if err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -77,16 +258,54 @@ func expand(ctx context.Context, base string) error {
 			target := filepath.Join(targetPath, relativePath)
 
 			if info.IsDir() {
+				mu.Lock()
+				dirs[target] = info.Mode().Perm()
+				mu.Unlock()
 				return os.MkdirAll(target, os.ModePerm)
 			}
-			if !info.Mode().IsRegular() {
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				mu.Lock()
+				links = append(links, target)
+				mu.Unlock()
+				return symlink(path, target, targetPath)
+			case !info.Mode().IsRegular():
 				log.Printf("Skipping irregular file: %q", relativePath)
 				return nil
 			}
-			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			// Preserve the recorded mode (e.g. executable bits), but
+			// always leave things writable by their owner so that the
+			// workspace may be modified by subsequent steps.
+			perm := info.Mode().Perm() | 0200
+			if m == nil {
+				_, err := copy(path, target, perm)
 				return err
 			}
-			return copy(path, target)
+
+			name := filepath.ToSlash(relativePath)
+			want, ok := m.Files[name]
+			if !ok {
+				return fmt.Errorf("%q is not in the bundle's manifest", name)
+			}
+			mu.Lock()
+			seen[name] = struct{}{}
+			mu.Unlock()
+
+			if matches(target, want) {
+				// Already expanded, just make sure the mode is right.
+				return os.Chmod(target, perm)
+			}
+			got, err := copy(path, target, perm)
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("%q is corrupt, got digest %v, wanted %v", name, got, want)
+			}
+			return nil
 		})
 
 		return nil
@@ -95,10 +314,57 @@ func expand(ctx context.Context, base string) error {
 	}
 
 	// Wait for the work to be done.
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	// The symlinks were checked in whatever order the pool ran them, so
+	// check them all again now that everything is in place.
+	if err := removeEscapingLinks(links, targetPath); err != nil {
+		return err
+	}
+	if err := restoreModes(dirs); err != nil {
+		return err
+	}
+
+	if m != nil {
+		var missing []string
+		for name := range m.Files {
+			if _, ok := seen[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("the bundle is incomplete, %d files are missing: %v", len(missing), missing)
+		}
+	}
+	return nil
+}
+
+// restoreModes sets the modes of the given directories, once everything
+// beneath them is in place.  As with files, they are left accessible to
+// their owner so that the workspace may be modified by subsequent steps.
+// Anything that is no longer a directory (e.g. it was replaced by a symlink)
+// is left alone.
+func restoreModes(dirs map[string]os.FileMode) error {
+	for dir, perm := range dirs {
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.Chmod(dir, perm|0700); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Expand recursively copies the current working directory into StoragePath.
-func Expand(ctx context.Context) error {
-	return expand(ctx, StoragePath)
+// Expand recursively copies the contents of StoragePath into the current
+// working directory, verifying them against the manifest at ManifestPath
+// when the bundle has one (see WithManifest).
+func Expand(ctx context.Context, opts ExpandOptions) error {
+	m, err := readManifest(ManifestPath)
+	if err != nil {
+		return err
+	}
+	return expand(ctx, StoragePath, m, opts)
 }
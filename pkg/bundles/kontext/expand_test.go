@@ -17,11 +17,20 @@ limitations under the License.
 package kontext
 
 import (
+	"archive/tar"
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/mattmoor/mink/pkg/bundles"
 )
 
 func TestExpand(t *testing.T) {
@@ -37,29 +46,30 @@ func TestExpand(t *testing.T) {
 		t.Fatal("ioutil.TempDir() =", err)
 	}
 	defer os.RemoveAll(dest)
+	defer os.Chdir(wd)
 	if err := os.Chdir(dest); err != nil {
 		t.Fatal("os.Chdir() =", err)
 	}
-	if err := expand(context.Background(), src); err != nil {
+	if err := expand(context.Background(), src, nil, ExpandOptions{Parallelism: DefaultParallelism}); err != nil {
 		t.Error("expand() =", err)
 	}
 
 	// bundle up both directories.
-	lSrc, err := bundle(src)
+	lSrc, err := bundle(Options{Directory: src}, t.TempDir())
 	if err != nil {
-		t.Error("bundle() =", err)
+		t.Fatal("bundle() =", err)
 	}
-	lDest, err := bundle(dest)
+	lDest, err := bundle(Options{Directory: dest}, t.TempDir())
 	if err != nil {
-		t.Error("bundle() =", err)
+		t.Fatal("bundle() =", err)
 	}
 
 	// Compute the bundle hashes
-	hSrc, err := lSrc.Digest()
+	hSrc, err := lSrc[0].Digest()
 	if err != nil {
 		t.Error("lSrc.Digest() =", err)
 	}
-	hDest, err := lDest.Digest()
+	hDest, err := lDest[0].Digest()
 	if err != nil {
 		t.Error("lDest.Digest() =", err)
 	}
@@ -69,3 +79,257 @@ func TestExpand(t *testing.T) {
 		t.Errorf("bundle() = %v, wanted %v", hDest, hSrc)
 	}
 }
+
+func TestExpandFidelity(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"bin/run.sh":   "#!/bin/sh",
+		"data/foo.txt": "foo",
+	})
+	if err := os.Chmod(filepath.Join(src, "bin/run.sh"), 0755); err != nil {
+		t.Fatal("os.Chmod() =", err)
+	}
+	if err := os.Chmod(filepath.Join(src, "data"), 0750); err != nil {
+		t.Fatal("os.Chmod() =", err)
+	}
+	for link, target := range map[string]string{
+		"data/bar.txt":   "foo.txt",
+		"bin/data":       "../data",
+		"bin/escape.txt": "../../etc/passwd",
+		"bin/abs.txt":    "/etc/passwd",
+	} {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Fatal("os.Symlink() =", err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("os.Getwd() =", err)
+	}
+	defer os.Chdir(wd)
+	dest := t.TempDir()
+	if err := os.Chdir(dest); err != nil {
+		t.Fatal("os.Chdir() =", err)
+	}
+	if err := expand(context.Background(), src, nil, ExpandOptions{Parallelism: DefaultParallelism}); err != nil {
+		t.Fatal("expand() =", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "bin/run.sh"))
+	if err != nil {
+		t.Fatal("os.Stat() =", err)
+	}
+	if got := info.Mode().Perm(); got&0111 == 0 {
+		t.Errorf("mode of run.sh = %v, wanted executable", got)
+	}
+	if info, err = os.Stat(filepath.Join(dest, "data")); err != nil {
+		t.Fatal("os.Stat() =", err)
+	}
+	if got, want := info.Mode().Perm(), os.FileMode(0750); got != want {
+		t.Errorf("mode of data = %v, wanted %v", got, want)
+	}
+
+	for link, want := range map[string]string{
+		"data/bar.txt": "foo.txt",
+		"bin/data":     "../data",
+	} {
+		got, err := os.Readlink(filepath.Join(dest, link))
+		if err != nil {
+			t.Errorf("os.Readlink(%q) = %v", link, err)
+		} else if got != want {
+			t.Errorf("os.Readlink(%q) = %q, wanted %q", link, got, want)
+		}
+	}
+
+	for _, link := range []string{"bin/escape.txt", "bin/abs.txt"} {
+		if _, err := os.Lstat(filepath.Join(dest, link)); !os.IsNotExist(err) {
+			t.Errorf("os.Lstat(%q) = %v, wanted it skipped", link, err)
+		}
+	}
+}
+
+func TestExpandCombinedEscape(t *testing.T) {
+	// Neither link escapes on its own, but a resolves outside of the
+	// workspace once d is in place, and a is expanded first.
+	src := t.TempDir()
+	for link, target := range map[string]string{
+		"a": "d/d/../..",
+		"d": ".",
+	} {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Fatal("os.Symlink() =", err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("os.Getwd() =", err)
+	}
+	defer os.Chdir(wd)
+
+	for _, p := range []int{1, DefaultParallelism} {
+		dest := t.TempDir()
+		if err := os.Chdir(dest); err != nil {
+			t.Fatal("os.Chdir() =", err)
+		}
+		if err := expand(context.Background(), src, nil, ExpandOptions{Parallelism: p}); err != nil {
+			t.Fatal("expand() =", err)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "a")); !os.IsNotExist(err) {
+			t.Errorf("Parallelism %d: os.Lstat(a) = %v, wanted it removed", p, err)
+		}
+		if got, err := os.Readlink(filepath.Join(dest, "d")); err != nil || got != "." {
+			t.Errorf("Parallelism %d: os.Readlink(d) = %q, %v, wanted %q", p, got, err, ".")
+		}
+	}
+}
+
+func TestExpandParallelism(t *testing.T) {
+	for _, p := range []int{0, -1} {
+		if err := expand(context.Background(), t.TempDir(), nil, ExpandOptions{Parallelism: p}); err == nil {
+			t.Errorf("expand(Parallelism: %d) = nil, wanted error", p)
+		}
+	}
+}
+
+func TestExpandReplacesSymlinks(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"f": "bundled"})
+	outside := filepath.Join(t.TempDir(), "victim")
+	if err := ioutil.WriteFile(outside, []byte("original"), 0644); err != nil {
+		t.Fatal("ioutil.WriteFile() =", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("os.Getwd() =", err)
+	}
+	defer os.Chdir(wd)
+	dest := t.TempDir()
+	if err := os.Chdir(dest); err != nil {
+		t.Fatal("os.Chdir() =", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "f")); err != nil {
+		t.Fatal("os.Symlink() =", err)
+	}
+	if err := expand(context.Background(), src, nil, ExpandOptions{Parallelism: DefaultParallelism}); err != nil {
+		t.Fatal("expand() =", err)
+	}
+
+	if b, err := ioutil.ReadFile(outside); err != nil {
+		t.Fatal("ioutil.ReadFile() =", err)
+	} else if string(b) != "original" {
+		t.Errorf("expand() wrote through a symlink: %q", b)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "f")); err != nil {
+		t.Fatal("ioutil.ReadFile() =", err)
+	} else if string(b) != "bundled" {
+		t.Errorf("f = %q, wanted %q", b, "bundled")
+	}
+	if info, err := os.Lstat(filepath.Join(dest, "f")); err != nil {
+		t.Fatal("os.Lstat() =", err)
+	} else if !info.Mode().IsRegular() {
+		t.Errorf("expand() left %v, wanted a regular file", info.Mode())
+	}
+}
+
+// manifestOf returns the manifest that WithManifest produces for the bundle
+// of the given directory.
+func manifestOf(t *testing.T, src string) *manifest {
+	t.Helper()
+	dir := t.TempDir()
+	layers, err := bundle(Options{Directory: src}, dir)
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if layers, err = WithManifest(dir, bundles.GzipCompression, layers); err != nil {
+		t.Fatal("WithManifest() =", err)
+	}
+
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatal("AppendLayers() =", err)
+	}
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal("Next() =", err)
+		}
+		if path.Clean("/"+hdr.Name) != ManifestPath {
+			continue
+		}
+		m := &manifest{}
+		if err := json.NewDecoder(tr).Decode(m); err != nil {
+			t.Fatal("Decode() =", err)
+		}
+		return m
+	}
+}
+
+func TestExpandVerified(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "b",
+	})
+	m := manifestOf(t, src)
+	if got, want := len(m.Files), 2; got != want {
+		t.Fatalf("len(Files) = %d, wanted %d", got, want)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("os.Getwd() =", err)
+	}
+	defer os.Chdir(wd)
+	dest := t.TempDir()
+	if err := os.Chdir(dest); err != nil {
+		t.Fatal("os.Chdir() =", err)
+	}
+	opts := ExpandOptions{Parallelism: 2}
+
+	// Stale contents that are longer than the bundled ones must be
+	// truncated rather than partially overwritten.
+	writeTree(t, dest, map[string]string{"a.txt": "stale and long"})
+	if err := expand(context.Background(), src, m, opts); err != nil {
+		t.Fatal("expand() =", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "a.txt")); err != nil {
+		t.Fatal("ReadFile() =", err)
+	} else if got, want := string(b), "a"; got != want {
+		t.Errorf("a.txt = %q, wanted %q", got, want)
+	}
+
+	// Expanding again skips the files that are already in place.
+	old := time.Unix(1234567890, 0)
+	if err := os.Chtimes(filepath.Join(dest, "sub/b.txt"), old, old); err != nil {
+		t.Fatal("os.Chtimes() =", err)
+	}
+	if err := expand(context.Background(), src, m, opts); err != nil {
+		t.Fatal("expand() =", err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "sub/b.txt")); err != nil {
+		t.Fatal("os.Stat() =", err)
+	} else if !info.ModTime().Equal(old) {
+		t.Errorf("sub/b.txt was rewritten at %v", info.ModTime())
+	}
+
+	// Corrupted contents are an error when they need to be copied.
+	writeTree(t, src, map[string]string{"sub/b.txt": "corrupt"})
+	writeTree(t, dest, map[string]string{"sub/b.txt": "modified"})
+	if err := expand(context.Background(), src, m, opts); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("expand() = %v, wanted corrupt", err)
+	}
+
+	// As are missing files.
+	if err := os.Remove(filepath.Join(src, "sub/b.txt")); err != nil {
+		t.Fatal("os.Remove() =", err)
+	}
+	if err := expand(context.Background(), src, m, opts); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("expand() = %v, wanted incomplete", err)
+	}
+}
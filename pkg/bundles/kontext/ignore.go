@@ -0,0 +1,285 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// DefaultIgnoreFiles holds the names of the gitignore-style files that are
+// consulted by default when bundling a directory.  Files later in the list
+// take precedence over earlier ones (e.g. a .minkignore may re-include
+// something that .gitignore excludes via a negated pattern).
+var DefaultIgnoreFiles = []string{".gitignore", ".dockerignore", ".minkignore"}
+
+// dockerIgnoreFile is the name of the ignore file that follows Docker's
+// semantics rather than git's: it is only read from the root of a source,
+// and its patterns are anchored at that root.
+const dockerIgnoreFile = ".dockerignore"
+
+// dockerKeepFiles holds the files at the root of a source that a
+// .dockerignore never excludes, since Docker always sends them along with
+// the build context.
+var dockerKeepFiles = sets.NewString("Dockerfile", dockerIgnoreFile)
+
+// readIgnoreFiles reads the gitignore-style patterns from each of the named
+// files within the given directory, scoping them to domain (the path of the
+// directory relative to the root of the bundle).  A .dockerignore is only
+// consulted at the root (empty domain), where it is parsed with Docker's
+// rules.
+func readIgnoreFiles(directory string, domain []string, names []string) ([]gitignore.Pattern, error) {
+	var ps []gitignore.Pattern
+	for _, name := range names {
+		docker := name == dockerIgnoreFile
+		if docker && len(domain) != 0 {
+			continue
+		}
+		f, err := os.Open(filepath.Join(directory, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+				continue
+			}
+			if docker {
+				if p := parseDockerPattern(line); p != nil {
+					ps = append(ps, p)
+				}
+				continue
+			}
+			ps = append(ps, gitignore.ParsePattern(line, domain))
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// dockerPattern implements gitignore.Pattern with the matching rules of
+// .dockerignore: patterns are anchored at the root, use filepath.Match
+// syntax extended with "**", and also exclude everything beneath a
+// directory they match.
+type dockerPattern struct {
+	re      *regexp.Regexp
+	dirs    int
+	include bool
+}
+
+var _ gitignore.Pattern = (*dockerPattern)(nil)
+
+// parseDockerPattern parses a single line of a .dockerignore, returning nil
+// for lines that hold no pattern.
+func parseDockerPattern(line string) *dockerPattern {
+	line = strings.TrimSpace(line)
+	p := &dockerPattern{}
+	if strings.HasPrefix(line, "!") {
+		p.include = true
+		line = strings.TrimSpace(line[1:])
+	}
+	if line == "" {
+		return nil
+	}
+	line = filepath.ToSlash(filepath.Clean(line))
+	if len(line) > 1 && line[0] == '/' {
+		line = line[1:]
+	}
+	p.dirs = len(strings.Split(line, "/"))
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case ch == '*' && i+1 < len(line) && line[i+1] == '*':
+			i++
+			// Treat "**/" as "**".
+			if i+1 < len(line) && line[i+1] == '/' {
+				i++
+			}
+			if i+1 == len(line) {
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("(.*/)?")
+			}
+		case ch == '*':
+			expr.WriteString("[^/]*")
+		case ch == '?':
+			expr.WriteString("[^/]")
+		case ch == '[':
+			// Character classes carry over, except that filepath.Match
+			// also negates them with a leading "!".
+			end := i + 1
+			if end < len(line) && (line[end] == '!' || line[end] == '^') {
+				end++
+			}
+			for ; end < len(line) && line[end] != ']'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				// Like Docker, a malformed pattern simply never matches.
+				return nil
+			}
+			class := line[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i = end
+		case ch == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		case strings.IndexByte(".+()|{}$^", ch) >= 0:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		default:
+			expr.WriteByte(ch)
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		// Like Docker, a malformed pattern simply never matches.
+		return nil
+	}
+	p.re = re
+	return p
+}
+
+// Match implements gitignore.Pattern
+func (p *dockerPattern) Match(path []string, isDir bool) gitignore.MatchResult {
+	matched := p.re.MatchString(strings.Join(path, "/"))
+	if !matched && len(path) > p.dirs {
+		// A pattern matching a parent directory matches its contents.
+		matched = p.re.MatchString(strings.Join(path[:p.dirs], "/"))
+	}
+	switch {
+	case !matched:
+		return gitignore.NoMatch
+	case p.include:
+		return gitignore.Include
+	case len(path) == 1 && dockerKeepFiles.Has(path[0]):
+		// Docker sends these regardless of the .dockerignore.
+		return gitignore.NoMatch
+	default:
+		return gitignore.Exclude
+	}
+}
+
+// walkFunc is the signature of the callback invoked by walk for each path
+// that should be included in the bundle, where relativePath is the path of
+// the entry relative to the root of the bundle.
+type walkFunc func(path, relativePath string, info os.FileInfo) error
+
+// walk traverses opts.Directory and then each of opts.Includes, calling fn
+// for each path that is not excluded by the ignore files configured on opts.
+// Ignore files are read from every directory as it is entered, so (like
+// .gitignore) nested files apply to the subtree in which they are found;
+// the exception is .dockerignore, which Docker only reads from the root.
+func walk(opts Options, fn walkFunc) error {
+	for _, src := range opts.sources() {
+		if info, err := os.Stat(src.Source); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", src.Source)
+		}
+		if err := walkSource(src, opts.IgnoreFiles, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkSource traverses a single source directory for walk.  The patterns of
+// the ignore files within the source are scoped to the source (rather than
+// to where it is placed in the bundle).
+func walkSource(src Include, ignoreFiles []string, fn walkFunc) error {
+	var patterns []gitignore.Pattern
+	return filepath.Walk(src.Source,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip anything in the .git directory
+			if info.IsDir() && filepath.Base(path) == ".git" {
+				return filepath.SkipDir
+			}
+
+			// Compute the path relative to the base path
+			relativePath, err := filepath.Rel(src.Source, path)
+			if err != nil {
+				return err
+			}
+
+			var parts []string
+			if relativePath != "." {
+				parts = strings.Split(filepath.ToSlash(relativePath), "/")
+				if gitignore.NewMatcher(patterns).Match(parts, info.IsDir()) {
+					// Like git, once a directory is excluded nothing
+					// beneath it may be re-included.
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+
+			if info.IsDir() {
+				ps, err := readIgnoreFiles(path, parts, ignoreFiles)
+				if err != nil {
+					return err
+				}
+				patterns = append(patterns, ps...)
+			}
+
+			return fn(path, filepath.Join(filepath.FromSlash(src.Destination), relativePath), info)
+		})
+}
+
+// Files returns the slash-separated paths (relative to the root of the
+// bundle) of the files that would be included in a bundle with the given
+// options, sorted.
+func Files(opts Options) ([]string, error) {
+	files := sets.NewString()
+	if err := walk(opts, func(path, relativePath string, info os.FileInfo) error {
+		if !info.IsDir() {
+			files.Insert(filepath.ToSlash(relativePath))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files.List(), nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+)
+
+// writeTree populates dir with the given files (keyed by slash-separated
+// relative path) and their contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal("os.MkdirAll() =", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("ioutil.WriteFile() =", err)
+		}
+	}
+}
+
+func TestFilesIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".git/HEAD":               "ref: refs/heads/main",
+		".gitignore":              "*.log\n# comment\n\nnode_modules/\n.env\n",
+		".minkignore":             "!keep.log\nbazel-*\n",
+		".env":                    "SECRET=hunter2",
+		"main.go":                 "package main",
+		"debug.log":               "ignored",
+		"keep.log":                "re-included",
+		"bazel-out/foo":           "ignored",
+		"node_modules/a/index.js": "ignored",
+		"sub/.gitignore":          "generated/\n/local.txt\n",
+		"sub/local.txt":           "ignored",
+		"sub/lib.go":              "package sub",
+		"sub/generated/x.go":      "ignored",
+		"sub/deeper/local.txt":    "not anchored here",
+		"other/generated/y.go":    "only ignored under sub",
+	})
+
+	tests := []struct {
+		name        string
+		ignoreFiles []string
+		want        []string
+	}{{
+		name:        "default ignore files",
+		ignoreFiles: DefaultIgnoreFiles,
+		want: []string{
+			".gitignore",
+			".minkignore",
+			"keep.log",
+			"main.go",
+			"other/generated/y.go",
+			"sub/.gitignore",
+			"sub/deeper/local.txt",
+			"sub/lib.go",
+		},
+	}, {
+		name:        "only gitignore",
+		ignoreFiles: []string{".gitignore"},
+		want: []string{
+			".gitignore",
+			".minkignore",
+			"bazel-out/foo",
+			"main.go",
+			"other/generated/y.go",
+			"sub/.gitignore",
+			"sub/deeper/local.txt",
+			"sub/lib.go",
+		},
+	}, {
+		name: "no ignore files",
+		want: []string{
+			".env",
+			".gitignore",
+			".minkignore",
+			"bazel-out/foo",
+			"debug.log",
+			"keep.log",
+			"main.go",
+			"node_modules/a/index.js",
+			"other/generated/y.go",
+			"sub/.gitignore",
+			"sub/deeper/local.txt",
+			"sub/generated/x.go",
+			"sub/lib.go",
+			"sub/local.txt",
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Files(Options{
+				Directory:   dir,
+				IgnoreFiles: test.ignoreFiles,
+			})
+			if err != nil {
+				t.Fatal("Files() =", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Files() (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFilesDockerIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".dockerignore":     "foo\n*.md\n!README.md\ndocs/**/*.txt\n/build\n",
+		"foo":               "ignored",
+		"a/foo":             "not anchored here",
+		"README.md":         "re-included",
+		"CHANGES.md":        "ignored",
+		"a/NOTES.md":        "not anchored here",
+		"build/out":         "ignored beneath a matched directory",
+		"docs/a.txt":        "ignored",
+		"docs/x/y/b.txt":    "ignored",
+		"docs/x/c.go":       "kept",
+		"sub/.dockerignore": "*\n",
+		"sub/lib.go":        "nested .dockerignore is not read",
+		"gitsub/.gitignore": "foo\n",
+		"gitsub/foo":        "ignored",
+		"gitsub/nested/foo": "ignored",
+	})
+
+	tests := []struct {
+		name        string
+		ignoreFiles []string
+		want        []string
+	}{{
+		name:        "dockerignore",
+		ignoreFiles: []string{".dockerignore"},
+		want: []string{
+			".dockerignore",
+			"README.md",
+			"a/NOTES.md",
+			"a/foo",
+			"docs/x/c.go",
+			"gitsub/.gitignore",
+			"gitsub/foo",
+			"gitsub/nested/foo",
+			"sub/.dockerignore",
+			"sub/lib.go",
+		},
+	}, {
+		// The same patterns with git's semantics are unanchored, so
+		// contrast the gitignore in gitsub with the dockerignore above.
+		name:        "dockerignore and gitignore",
+		ignoreFiles: []string{".gitignore", ".dockerignore"},
+		want: []string{
+			".dockerignore",
+			"README.md",
+			"a/NOTES.md",
+			"a/foo",
+			"docs/x/c.go",
+			"gitsub/.gitignore",
+			"sub/.dockerignore",
+			"sub/lib.go",
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Files(Options{
+				Directory:   dir,
+				IgnoreFiles: test.ignoreFiles,
+			})
+			if err != nil {
+				t.Fatal("Files() =", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Files() (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestFilesDockerIgnoreKeepsDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".dockerignore":  "*\n!src\n",
+		"Dockerfile":     "FROM scratch",
+		"README.md":      "excluded",
+		"src/Dockerfile": "FROM scratch",
+		"src/main.go":    "package main",
+	})
+
+	got, err := Files(Options{
+		Directory:   dir,
+		IgnoreFiles: []string{".dockerignore"},
+	})
+	if err != nil {
+		t.Fatal("Files() =", err)
+	}
+	want := []string{".dockerignore", "Dockerfile", "src/Dockerfile", "src/main.go"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Files() (-want, +got): %s", diff)
+	}
+}
+
+func TestDockerPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    gitignore.MatchResult
+	}{
+		{pattern: "foo", path: "foo", want: gitignore.Exclude},
+		{pattern: "foo", path: "a/foo", want: gitignore.NoMatch},
+		{pattern: "/foo", path: "foo", want: gitignore.Exclude},
+		{pattern: "foo", path: "foo/bar", want: gitignore.Exclude},
+		{pattern: "*/foo", path: "a/foo", want: gitignore.Exclude},
+		{pattern: "*/foo", path: "a/b/foo", want: gitignore.NoMatch},
+		{pattern: "**/foo", path: "a/b/foo", want: gitignore.Exclude},
+		{pattern: "**/foo", path: "foo", want: gitignore.Exclude},
+		{pattern: "a/**", path: "a/b/c", want: gitignore.Exclude},
+		{pattern: "*.go", path: "main.go", want: gitignore.Exclude},
+		{pattern: "*.go", path: "pkg/main.go", want: gitignore.NoMatch},
+		{pattern: "?.txt", path: "a.txt", want: gitignore.Exclude},
+		{pattern: "?.txt", path: "ab.txt", want: gitignore.NoMatch},
+		{pattern: "[ab].txt", path: "b.txt", want: gitignore.Exclude},
+		{pattern: "[!ab].txt", path: "c.txt", want: gitignore.Exclude},
+		{pattern: "[!ab].txt", path: "a.txt", want: gitignore.NoMatch},
+		{pattern: "[^ab].txt", path: "c.txt", want: gitignore.Exclude},
+		{pattern: "[a-c]*.go", path: "b_test.go", want: gitignore.Exclude},
+		{pattern: "[\\]].txt", path: "].txt", want: gitignore.Exclude},
+		{pattern: "*", path: "Dockerfile", want: gitignore.NoMatch},
+		{pattern: "Dockerfile", path: "Dockerfile", want: gitignore.NoMatch},
+		{pattern: ".dockerignore", path: ".dockerignore", want: gitignore.NoMatch},
+		{pattern: "*", path: "src/Dockerfile", want: gitignore.Exclude},
+		{pattern: "**/Dockerfile", path: "a/Dockerfile", want: gitignore.Exclude},
+		{pattern: "!Dockerfile", path: "Dockerfile", want: gitignore.Include},
+		{pattern: "a.b", path: "axb", want: gitignore.NoMatch},
+		{pattern: "./dist/../build", path: "build", want: gitignore.Exclude},
+		{pattern: "!keep", path: "keep", want: gitignore.Include},
+		{pattern: "!keep", path: "other", want: gitignore.NoMatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			p := parseDockerPattern(test.pattern)
+			if p == nil {
+				t.Fatalf("parseDockerPattern(%q) = nil", test.pattern)
+			}
+			if got := p.Match(strings.Split(test.path, "/"), test.isDir); got != test.want {
+				t.Errorf("Match(%q) = %v, wanted %v", test.path, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDockerPatternNil(t *testing.T) {
+	// Lines that hold no pattern, or a malformed one.
+	for _, line := range []string{"", "   ", "!", "! ", "[ab", "a[!b"} {
+		if p := parseDockerPattern(line); p != nil {
+			t.Errorf("parseDockerPattern(%q) = %v, wanted nil", line, p)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Include maps an additional local directory into the bundle.
+type Include struct {
+	// Source is the local directory to include.
+	Source string
+
+	// Destination is the slash-separated path (relative to the root of the
+	// bundle) at which the contents of Source are placed.
+	Destination string
+}
+
+// ParseInclude parses an include of the form SRC[:DEST], where DEST
+// defaults to the base name of SRC.
+func ParseInclude(s string) (Include, error) {
+	inc := Include{Source: s}
+	if i := strings.Index(s, ":"); i >= 0 {
+		inc.Source, inc.Destination = s[:i], s[i+1:]
+	}
+	if inc.Source == "" {
+		return Include{}, fmt.Errorf("include %q has no source directory", s)
+	}
+	if inc.Destination == "" {
+		inc.Destination = filepath.Base(inc.Source)
+	}
+
+	dest := path.Clean(filepath.ToSlash(inc.Destination))
+	if path.IsAbs(dest) || dest == ".." || strings.HasPrefix(dest, "../") {
+		return Include{}, fmt.Errorf("include %q must have a destination within the bundle, but got: %s", s, inc.Destination)
+	}
+	inc.Destination = dest
+	return inc, nil
+}
+
+// String implements fmt.Stringer
+func (inc Include) String() string {
+	return inc.Source + ":" + inc.Destination
+}
+
+// sources returns the directories that make up the bundle, in increasing
+// precedence: opts.Directory at the root, and then each of opts.Includes.
+func (opts Options) sources() []Include {
+	return append([]Include{{Source: opts.Directory, Destination: "."}}, opts.Includes...)
+}
+
+// overlay merges the entries gathered from each of the sources, where later
+// entries take the place of earlier entries with the same name.  Any
+// ancestor directories of the entries that none of the sources hold (e.g.
+// the parents of an include's destination) are added.
+func overlay(entries []entry) ([]entry, error) {
+	index := make(map[string]int, len(entries))
+	var result []entry
+	for _, e := range entries {
+		i, ok := index[e.name]
+		if !ok {
+			index[e.name] = len(result)
+			result = append(result, e)
+			continue
+		}
+		if prev := result[i]; prev.info.IsDir() != e.info.IsDir() {
+			return nil, fmt.Errorf("%q is a directory in one source and not in another (%q and %q)",
+				e.name, prev.path, e.path)
+		}
+		result[i] = e
+	}
+
+	for _, e := range result {
+		if e.name == "." {
+			continue
+		}
+		for dir := path.Dir(e.name); ; dir = path.Dir(dir) {
+			if _, ok := index[dir]; !ok {
+				index[dir] = len(result)
+				result = append(result, entry{
+					name: dir,
+					info: impliedDir(path.Base(dir)),
+					mode: 0555,
+				})
+			} else if d := result[index[dir]]; !d.info.IsDir() {
+				return nil, fmt.Errorf("%q is a directory in one source and not in another (%q and %q)",
+					dir, d.path, e.path)
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// impliedDir is the os.FileInfo of a directory that is implied by the
+// destination of an Include, but that doesn't exist on the filesystem.
+type impliedDir string
+
+var _ os.FileInfo = impliedDir("")
+
+// Name implements os.FileInfo
+func (d impliedDir) Name() string { return string(d) }
+
+// Size implements os.FileInfo
+func (d impliedDir) Size() int64 { return 0 }
+
+// Mode implements os.FileInfo
+func (d impliedDir) Mode() os.FileMode { return os.ModeDir | 0555 }
+
+// ModTime implements os.FileInfo
+func (d impliedDir) ModTime() time.Time { return time.Time{} }
+
+// IsDir implements os.FileInfo
+func (d impliedDir) IsDir() bool { return true }
+
+// Sys implements os.FileInfo
+func (d impliedDir) Sys() interface{} { return nil }
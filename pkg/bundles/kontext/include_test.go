@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseInclude(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Include
+		wantErr bool
+	}{{
+		input: "../proto",
+		want:  Include{Source: "../proto", Destination: "proto"},
+	}, {
+		input: "../../libs:third_party/libs/",
+		want:  Include{Source: "../../libs", Destination: "third_party/libs"},
+	}, {
+		input: "shared:.",
+		want:  Include{Source: "shared", Destination: "."},
+	}, {
+		input:   ":proto",
+		wantErr: true,
+	}, {
+		input:   "../proto:/proto",
+		wantErr: true,
+	}, {
+		input:   "../proto:a/../../proto",
+		wantErr: true,
+	}, {
+		input:   "..",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := ParseInclude(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseInclude() = %v, wanted error: %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseInclude() = %#v, wanted %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBundleIncludes(t *testing.T) {
+	dir, proto, libs := t.TempDir(), t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":        "package main",
+		"proto/local.md": "shadowed",
+	})
+	writeTree(t, proto, map[string]string{
+		".gitignore": "*.pb.go\n",
+		"api.proto":  "syntax = \"proto3\";",
+		"api.pb.go":  "package api",
+		"local.md":   "overlaid",
+	})
+	writeTree(t, libs, map[string]string{
+		"util/util.go": "package util",
+	})
+
+	opts := Options{
+		Directory:   dir,
+		IgnoreFiles: DefaultIgnoreFiles,
+		Includes: []Include{
+			{Source: proto, Destination: "proto"},
+			{Source: libs, Destination: "third_party/libs"},
+		},
+	}
+
+	files, err := Files(opts)
+	if err != nil {
+		t.Fatal("Files() =", err)
+	}
+	want := []string{
+		"main.go",
+		"proto/.gitignore",
+		"proto/api.proto",
+		"proto/local.md",
+		"third_party/libs/util/util.go",
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Files (-want, +got): %s", diff)
+	}
+
+	entries, err := Contents(bundleImage(t, opts))
+	if err != nil {
+		t.Fatal("Contents() =", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Path)
+	}
+	want = []string{
+		"main.go",
+		"proto",
+		"proto/.gitignore",
+		"proto/api.proto",
+		"proto/local.md",
+		"third_party",
+		"third_party/libs",
+		"third_party/libs/util",
+		"third_party/libs/util/util.go",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Contents (-want, +got): %s", diff)
+	}
+
+	// Later sources take precedence where they overlap.
+	out := t.TempDir()
+	if err := Extract(bundleImage(t, opts), out); err != nil {
+		t.Fatal("Extract() =", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(out, "proto", "local.md")); err != nil {
+		t.Fatal("ReadFile() =", err)
+	} else if got, want := string(b), "overlaid"; got != want {
+		t.Errorf("proto/local.md = %q, wanted %q", got, want)
+	}
+}
+
+func TestBundleIncludesConflict(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	writeTree(t, dir, map[string]string{"proto": "not a directory"})
+	writeTree(t, other, map[string]string{"api.proto": ""})
+
+	_, err := bundle(Options{
+		Directory: dir,
+		Includes:  []Include{{Source: other, Destination: "proto"}},
+	}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "is a directory in one source") {
+		t.Errorf("bundle() = %v, wanted a conflict", err)
+	}
+
+	_, err = bundle(Options{
+		Directory: dir,
+		Includes:  []Include{{Source: filepath.Join(dir, "proto"), Destination: "other"}},
+	}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("bundle() = %v, wanted not a directory", err)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/mattmoor/mink/pkg/bundles"
+)
+
+// Entry describes a file within a bundle.
+type Entry struct {
+	// Path is the slash-separated path of the file relative to StoragePath,
+	// which is where it is expanded relative to the working directory.
+	Path string
+	// Mode holds the type and permissions of the file.
+	Mode os.FileMode
+	// Size is the size of the file's contents.
+	Size int64
+	// Linkname is the target of symlinks.
+	Linkname string
+	// Digest is the digest of the contents of regular files.
+	Digest v1.Hash
+}
+
+// walkBundle calls fn for each of the files that the given bundle expands,
+// in the order in which they appear in the bundle's flattened filesystem.
+// For regular files, the reader yields the file's contents.
+func walkBundle(img v1.Image, fn func(e Entry, r io.Reader) error) error {
+	img, err := bundles.Readable(img)
+	if err != nil {
+		return err
+	}
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	prefix := strings.TrimPrefix(StoragePath, "/") + "/"
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if err := fn(Entry{
+			Path:     strings.TrimPrefix(name, prefix),
+			Mode:     hdr.FileInfo().Mode(),
+			Size:     hdr.Size,
+			Linkname: hdr.Linkname,
+		}, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Contents returns the files that the given bundle expands, sorted by path.
+func Contents(img v1.Image) ([]Entry, error) {
+	var entries []Entry
+	if err := walkBundle(img, func(e Entry, r io.Reader) error {
+		if e.Mode.IsRegular() {
+			h := sha256.New()
+			if _, err := io.Copy(h, r); err != nil {
+				return err
+			}
+			e.Digest = v1.Hash{
+				Algorithm: "sha256",
+				Hex:       hex.EncodeToString(h.Sum(nil)),
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// Extract lays the files of the given bundle down within dir, as Expand
+// would within the working directory of the bundle's container.  Nothing is
+// written beneath (or through) symlinks, and symlinks that end up referring
+// to something outside of dir are removed.
+func Extract(img v1.Image, dir string) error {
+	var links []string
+	dirs := make(map[string]os.FileMode)
+	if err := walkBundle(img, func(e Entry, r io.Reader) error {
+		target := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if parent, err := symlinkParent(target, dir); err != nil {
+			return err
+		} else if parent != "" {
+			log.Printf("Skipping entry beneath symlink %q: %q", parent, e.Path)
+			return nil
+		}
+		if e.Mode.IsDir() {
+			dirs[target] = e.Mode.Perm()
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		switch {
+		case e.Mode&os.ModeSymlink != 0:
+			links = append(links, target)
+			return link(e.Linkname, target, dir)
+		case !e.Mode.IsRegular():
+			log.Printf("Skipping irregular file: %q", e.Path)
+			return nil
+		}
+		// Replace anything already at the destination, rather than
+		// writing through it should it be a symlink.
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// As with Expand, leave things writable by their owner.
+		to, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.Mode.Perm()|0200)
+		if err != nil {
+			return err
+		}
+		defer to.Close()
+		if _, err := io.Copy(to, r); err != nil {
+			return err
+		}
+		return to.Close()
+	}); err != nil {
+		return err
+	}
+
+	if err := removeEscapingLinks(links, dir); err != nil {
+		return err
+	}
+	return restoreModes(dirs)
+}
+
+// symlinkParent returns the first of the directories between root and path
+// that is a symlink, or the empty string when there are none.
+func symlinkParent(path, root string) (string, error) {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return "", err
+	}
+	p := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// Difference describes a file that differs between two bundles.
+type Difference struct {
+	// Path is the path of the file that differs.
+	Path string
+	// Before is the file in the first bundle, or nil if it was added.
+	Before *Entry
+	// After is the file in the second bundle, or nil if it was removed.
+	After *Entry
+}
+
+// Diff returns the files that differ between the contents of two bundles
+// (see Contents), sorted by path.
+func Diff(before, after []Entry) []Difference {
+	index := func(entries []Entry) map[string]*Entry {
+		m := make(map[string]*Entry, len(entries))
+		for i := range entries {
+			m[entries[i].Path] = &entries[i]
+		}
+		return m
+	}
+	b, a := index(before), index(after)
+
+	var diffs []Difference
+	for p, be := range b {
+		if ae, ok := a[p]; !ok || *ae != *be {
+			diffs = append(diffs, Difference{Path: p, Before: be, After: ae})
+		}
+	}
+	for p, ae := range a {
+		if _, ok := b[p]; !ok {
+			diffs = append(diffs, Difference{Path: p, After: ae})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// bundleImage bundles opts.Directory onto an empty image.
+func bundleImage(t *testing.T, opts Options) v1.Image {
+	t.Helper()
+	layers, err := bundle(opts, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		t.Fatal("AppendLayers() =", err)
+	}
+	return img
+}
+
+func TestContents(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+	})
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal("os.Symlink() =", err)
+	}
+
+	entries, err := Contents(bundleImage(t, Options{Directory: dir, Fidelity: true}))
+	if err != nil {
+		t.Fatal("Contents() =", err)
+	}
+
+	type summary struct {
+		Path     string
+		Size     int64
+		Linkname string
+		IsDir    bool
+	}
+	var got []summary
+	for _, e := range entries {
+		got = append(got, summary{Path: e.Path, Size: e.Size, Linkname: e.Linkname, IsDir: e.Mode.IsDir()})
+		if e.Mode.IsRegular() && e.Digest.Hex == "" {
+			t.Errorf("%s has no digest", e.Path)
+		}
+	}
+	want := []summary{
+		{Path: "a.txt", Size: 1},
+		{Path: "link", Linkname: "a.txt"},
+		{Path: "sub", IsDir: true},
+		{Path: "sub/b.txt", Size: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Contents (-want, +got): %s", diff)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	src, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal("filepath.Abs() =", err)
+	}
+	dest := t.TempDir()
+	if err := Extract(bundleImage(t, Options{Directory: src}), dest); err != nil {
+		t.Fatal("Extract() =", err)
+	}
+
+	// Extracting should reproduce the bundled tree.
+	lSrc, err := bundle(Options{Directory: src}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	lDest, err := bundle(Options{Directory: dest}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if diff := cmp.Diff(digests(t, lSrc), digests(t, lDest)); diff != "" {
+		t.Errorf("bundle() (-want, +got): %s", diff)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"same.txt":    "same",
+		"changed.txt": "before",
+		"removed.txt": "removed",
+	})
+	before, err := Contents(bundleImage(t, Options{Directory: dir}))
+	if err != nil {
+		t.Fatal("Contents() =", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "removed.txt")); err != nil {
+		t.Fatal("os.Remove() =", err)
+	}
+	writeTree(t, dir, map[string]string{
+		"changed.txt": "after",
+		"added.txt":   "added",
+	})
+	after, err := Contents(bundleImage(t, Options{Directory: dir}))
+	if err != nil {
+		t.Fatal("Contents() =", err)
+	}
+
+	type change struct {
+		Path          string
+		Before, After bool
+	}
+	var got []change
+	for _, d := range Diff(before, after) {
+		got = append(got, change{Path: d.Path, Before: d.Before != nil, After: d.After != nil})
+	}
+	want := []change{
+		{Path: "added.txt", After: true},
+		{Path: "changed.txt", Before: true, After: true},
+		{Path: "removed.txt", Before: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Diff (-want, +got): %s", diff)
+	}
+
+	if diffs := Diff(after, after); len(diffs) != 0 {
+		t.Errorf("Diff() = %v, wanted none", diffs)
+	}
+}
+
+// tarImage returns an image with a single layer holding the given entries
+// (in order) beneath StoragePath, so that tests may construct bundles that
+// bundle() itself would never produce.
+func tarImage(t *testing.T, hdrs ...*tar.Header) v1.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		hdr.Name = path.Join(StoragePath, hdr.Name)
+		var content []byte
+		if hdr.Typeflag == tar.TypeReg {
+			content = []byte(hdr.Name)
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal("WriteHeader() =", err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal("Write() =", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal("Close() =", err)
+	}
+	l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal("LayerFromOpener() =", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, l)
+	if err != nil {
+		t.Fatal("AppendLayers() =", err)
+	}
+	return img
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	symlink := func(name, linkname string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: linkname, Mode: 0777}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+	}
+
+	tests := []struct {
+		name string
+		hdrs []*tar.Header
+		want map[string]string // path -> link target, or "" for files
+	}{{
+		name: "lexical escape",
+		hdrs: []*tar.Header{symlink("up", "../outside"), symlink("in", "a/../b")},
+		want: map[string]string{"in": "a/../b"},
+	}, {
+		name: "escape through a chain of symlinks",
+		hdrs: []*tar.Header{
+			symlink("d", "."),
+			symlink("a", "d/d/d/../.."),
+			symlink("ok", "d/d/f"),
+			file("f"),
+		},
+		want: map[string]string{"d": ".", "ok": "d/d/f", "f": ""},
+	}, {
+		name: "escape through a later symlink",
+		hdrs: []*tar.Header{
+			symlink("d", "."),
+			symlink("a", "x/.."),
+			symlink("x", "d/d"),
+		},
+		want: map[string]string{"d": ".", "x": "d/d"},
+	}, {
+		name: "symlink loop",
+		hdrs: []*tar.Header{
+			symlink("loop", "loop/x"),
+			symlink("b", "loop/x"),
+		},
+		want: map[string]string{},
+	}, {
+		name: "entries beneath symlinks",
+		hdrs: []*tar.Header{
+			symlink("d", "sub"),
+			{Name: "sub", Typeflag: tar.TypeDir, Mode: 0755},
+			file("d/f"),
+			file("d/e/f"),
+		},
+		want: map[string]string{"d": "sub", "sub": ""},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := Extract(tarImage(t, test.hdrs...), dir); err != nil {
+				t.Fatal("Extract() =", err)
+			}
+
+			got := map[string]string{}
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal("ReadDir() =", err)
+			}
+			for _, e := range entries {
+				got[e.Name()] = ""
+				if e.Mode()&os.ModeSymlink != 0 {
+					if got[e.Name()], err = os.Readlink(filepath.Join(dir, e.Name())); err != nil {
+						t.Fatal("Readlink() =", err)
+					}
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Extract() (-want, +got): %s", diff)
+			}
+			if entries, err := ioutil.ReadDir(filepath.Join(dir, "sub")); err == nil && len(entries) != 0 {
+				t.Errorf("Extract() wrote %d entries beneath a symlink", len(entries))
+			}
+		})
+	}
+}
+
+func TestExtractReplacesSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "victim")
+	if err := ioutil.WriteFile(outside, []byte("original"), 0644); err != nil {
+		t.Fatal("ioutil.WriteFile() =", err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "f")); err != nil {
+		t.Fatal("os.Symlink() =", err)
+	}
+
+	img := tarImage(t, &tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0644})
+	if err := Extract(img, dir); err != nil {
+		t.Fatal("Extract() =", err)
+	}
+
+	if b, err := ioutil.ReadFile(outside); err != nil {
+		t.Fatal("ioutil.ReadFile() =", err)
+	} else if string(b) != "original" {
+		t.Errorf("Extract() wrote through a symlink: %q", b)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "f")); err != nil {
+		t.Fatal("os.Lstat() =", err)
+	} else if !info.Mode().IsRegular() {
+		t.Errorf("Extract() left %v, wanted a regular file", info.Mode())
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// DefaultMaxLayers is the default upper bound on the number of layers into
+// which a bundle is split.
+const DefaultMaxLayers = 16
+
+// separateLayerDirs holds the names of directories whose contents are
+// placed in a layer of their own wherever they appear, since they tend
+// to be large and change far less often than the code around them.
+var separateLayerDirs = sets.NewString("vendor", "node_modules")
+
+// layerKey returns the key of the group to which the named entry belongs.
+// Entries within one of separateLayerDirs are keyed by the path of that
+// directory, entries within a top-level directory are keyed by its name,
+// and top-level files (and the root itself) share the empty key.
+func layerKey(name string, isDir bool) string {
+	if name == "." {
+		return ""
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if separateLayerDirs.Has(part) {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	if len(parts) == 1 && !isDir {
+		return ""
+	}
+	return parts[0]
+}
+
+// partition deterministically splits the entries into at most max groups
+// (preserving their relative order), so that a change to a file only
+// changes the digest of the layer holding its group.  When there are more
+// keys than max, the keys are hashed into max buckets so that the assignment
+// of an unchanged key remains stable as other keys come and go.
+func partition(entries []entry, max int) [][]entry {
+	if max < 2 {
+		return [][]entry{entries}
+	}
+
+	keys := sets.NewString()
+	for _, e := range entries {
+		keys.Insert(layerKey(e.name, e.info.IsDir()))
+	}
+
+	// Assign each of the keys to the index of the group that holds it.
+	assignment := make(map[string]int, keys.Len())
+	size := keys.Len()
+	if size <= max {
+		for i, k := range keys.List() {
+			assignment[k] = i
+		}
+	} else {
+		size = max
+		for _, k := range keys.List() {
+			h := fnv.New32a()
+			h.Write([]byte(k))
+			assignment[k] = int(h.Sum32() % uint32(max))
+		}
+	}
+
+	groups := make([][]entry, size)
+	for _, e := range entries {
+		i := assignment[layerKey(e.name, e.info.IsDir())]
+		groups[i] = append(groups[i], e)
+	}
+
+	// Elide any buckets to which nothing hashed.
+	result := make([][]entry, 0, size)
+	for _, g := range groups {
+		if len(g) > 0 {
+			result = append(result, g)
+		}
+	}
+	return result
+}
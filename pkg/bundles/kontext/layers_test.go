@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestLayerKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		isDir bool
+		want  string
+	}{
+		{name: ".", isDir: true, want: ""},
+		{name: "main.go", want: ""},
+		{name: "cmd", isDir: true, want: "cmd"},
+		{name: "cmd/foo/main.go", want: "cmd"},
+		{name: "vendor", isDir: true, want: "vendor"},
+		{name: "vendor/github.com/foo/bar.go", want: "vendor"},
+		{name: "web/node_modules", isDir: true, want: "web/node_modules"},
+		{name: "web/node_modules/react/index.js", want: "web/node_modules"},
+		{name: "web/index.js", want: "web"},
+	}
+
+	for _, test := range tests {
+		if got := layerKey(test.name, test.isDir); got != test.want {
+			t.Errorf("layerKey(%q) = %q, wanted %q", test.name, got, test.want)
+		}
+	}
+}
+
+func digests(t *testing.T, layers []v1.Layer) []v1.Hash {
+	t.Helper()
+	hs := make([]v1.Hash, 0, len(layers))
+	for _, l := range layers {
+		h, err := l.Digest()
+		if err != nil {
+			t.Fatal("Digest() =", err)
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+func TestBundleLayers(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":                     "package main",
+		"cmd/foo/main.go":             "package main",
+		"pkg/bar/bar.go":              "package bar",
+		"vendor/github.com/a/a.go":    "package a",
+		"web/index.js":                "console.log('hi')",
+		"web/node_modules/x/index.js": "module.exports = {}",
+	})
+
+	opts := Options{Directory: dir, MaxLayers: DefaultMaxLayers}
+	before, err := bundle(opts, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	// One for each of: "", cmd, pkg, vendor, web, web/node_modules
+	if got, want := len(before), 6; got != want {
+		t.Fatalf("len(bundle()) = %d, wanted %d", got, want)
+	}
+
+	// Modify a single file and check that only its layer changes.
+	if err := ioutil.WriteFile(filepath.Join(dir, "pkg/bar/bar.go"), []byte("package bar // changed"), 0644); err != nil {
+		t.Fatal("ioutil.WriteFile() =", err)
+	}
+	after, err := bundle(opts, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	bh, ah := digests(t, before), digests(t, after)
+	if len(bh) != len(ah) {
+		t.Fatalf("len(bundle()) = %d, wanted %d", len(ah), len(bh))
+	}
+	changed := 0
+	for i := range bh {
+		if bh[i] != ah[i] {
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Errorf("changing a single file changed %d layers, wanted 1", changed)
+	}
+
+	// When there are more keys than layers, check we respect the bound.
+	for _, max := range []int{1, 2, 3} {
+		ls, err := bundle(Options{Directory: dir, MaxLayers: max}, t.TempDir())
+		if err != nil {
+			t.Fatal("bundle() =", err)
+		}
+		if len(ls) > max {
+			t.Errorf("len(bundle(%d)) = %d, wanted at most %d", max, len(ls), max)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/mattmoor/mink/pkg/bundles"
+)
+
+const (
+	// ManifestPath is where in the container image the manifest of the
+	// files under StoragePath is placed.  This is outside of StoragePath,
+	// so that it is not itself expanded.
+	ManifestPath = "/var/run/kontext.json"
+)
+
+// manifest records the digests of the regular files within a bundle, so
+// that they may be verified as they are expanded.
+type manifest struct {
+	// Files maps the slash-separated paths of regular files (relative to
+	// StoragePath) to the sha256 digests of their contents.
+	Files map[string]v1.Hash `json:"files"`
+}
+
+// WithManifest returns the given bundle layers followed by a layer holding
+// the manifest of the files they contain, which Expand uses to verify what
+// it expands.  The manifest layer is compressed with c, and backed by a file
+// within dir.
+func WithManifest(dir string, c bundles.Compression, layers []v1.Layer) ([]v1.Layer, error) {
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := Contents(img)
+	if err != nil {
+		return nil, err
+	}
+	m := manifest{Files: make(map[string]v1.Hash, len(entries))}
+	for _, e := range entries {
+		if e.Mode.IsRegular() {
+			m.Files[e.Path] = e.Digest
+		}
+	}
+	// Maps are marshalled with sorted keys, so this is reproducible.
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	mtime, err := bundles.SourceDateEpoch()
+	if err != nil {
+		return nil, err
+	}
+	l, err := bundles.NewLayer(dir, c, func(tw *tar.Writer) error {
+		if err := tw.WriteHeader(bundles.NormalizeHeader(&tar.Header{
+			Name:     ManifestPath,
+			Typeflag: tar.TypeReg,
+			Mode:     0444,
+			Size:     int64(len(b)),
+		}, mtime)); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(layers[:len(layers):len(layers)], l), nil
+}
+
+// readManifest reads the manifest at the given path, returning nil if
+// there is none (e.g. bundles produced before manifests were added).
+func readManifest(path string) (*manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
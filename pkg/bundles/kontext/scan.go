@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
+)
+
+const (
+	// maxScanSize bounds the size of the files whose contents are scanned
+	// for secrets.
+	maxScanSize = 10 << 20
+
+	// binarySniffSize is how much of a file is checked for NUL bytes to
+	// decide whether it is binary, like git does.
+	binarySniffSize = 8000
+)
+
+// Scan returns the findings of the policy for the files of the given bundle.
+// The contents of binary files and of files larger than 10MiB aren't
+// scanned, only their names.
+func Scan(img v1.Image, p *secrets.Policy) ([]secrets.Finding, error) {
+	var findings []secrets.Finding
+	err := walkBundle(img, func(e Entry, r io.Reader) error {
+		if e.Mode.IsDir() {
+			return nil
+		}
+		var content []byte
+		if e.Mode.IsRegular() && e.Size <= maxScanSize {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			sniff := b
+			if len(sniff) > binarySniffSize {
+				sniff = sniff[:binarySniffSize]
+			}
+			if bytes.IndexByte(sniff, 0) < 0 {
+				content = b
+			}
+		}
+		findings = append(findings, p.Scan(e.Path, content)...)
+		return nil
+	})
+	return findings, err
+}
+
+// CheckSecrets scans the files held by the given bundle layers for secrets
+// when the policy calls for it, and applies the policy to what is found.
+func CheckSecrets(layers []v1.Layer, p *secrets.Policy) error {
+	if !p.Enabled() {
+		return nil
+	}
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return err
+	}
+	findings, err := Scan(img, p)
+	if err != nil {
+		return err
+	}
+	return p.Check(findings)
+}
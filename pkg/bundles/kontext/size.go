@@ -0,0 +1,220 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// DefaultSizeReportTop is how many of the largest files and directories
+// are listed in a SizeReport by default.
+const DefaultSizeReportTop = 10
+
+// SizeBudget bounds the total size of the files in a bundle.
+type SizeBudget struct {
+	// Max is the largest total size (in bytes) of the files in a bundle,
+	// which is unlimited when zero.
+	Max int64
+
+	// Top is how many of the largest files and directories are reported,
+	// which defaults to DefaultSizeReportTop.
+	Top int
+
+	// Report is where the SizeReport of the bundle is written, when set,
+	// whether or not it is within budget (e.g. for dry runs).
+	Report io.Writer
+}
+
+// PathSize is the size of a file, or of the files within a directory.
+type PathSize struct {
+	// Path is the slash-separated path of the file or directory.
+	Path string
+	// Size is the size in bytes.
+	Size int64
+}
+
+// SizeReport summarizes what contributes to the size of a bundle.
+type SizeReport struct {
+	// Total is the total size of the files in the bundle.
+	Total int64
+	// Files is the number of files in the bundle.
+	Files int
+	// LargestFiles holds the largest files, largest first.
+	LargestFiles []PathSize
+	// LargestDirectories holds the directories whose files are largest
+	// in total, largest first.
+	LargestDirectories []PathSize
+}
+
+// Sizes returns the SizeReport for the files of the given bundle, listing
+// the top largest files and directories.
+func Sizes(img v1.Image, top int) (*SizeReport, error) {
+	var files []PathSize
+	err := walkBundle(img, func(e Entry, _ io.Reader) error {
+		if e.Mode.IsRegular() {
+			files = append(files, PathSize{Path: e.Path, Size: e.Size})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes(files, top), nil
+}
+
+// sizes returns the SizeReport for the given files, listing the top largest
+// files and directories.
+func sizes(files []PathSize, top int) *SizeReport {
+	r := &SizeReport{Files: len(files)}
+	dirs := make(map[string]int64)
+	for _, f := range files {
+		r.Total += f.Size
+		for dir := path.Dir(f.Path); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] += f.Size
+		}
+	}
+
+	r.LargestFiles = largest(files, top)
+	ds := make([]PathSize, 0, len(dirs))
+	for dir, size := range dirs {
+		ds = append(ds, PathSize{Path: dir, Size: size})
+	}
+	r.LargestDirectories = largest(ds, top)
+	return r
+}
+
+// largest returns the top largest of the given sizes, breaking ties by path.
+func largest(sizes []PathSize, top int) []PathSize {
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].Size != sizes[j].Size {
+			return sizes[i].Size > sizes[j].Size
+		}
+		return sizes[i].Path < sizes[j].Path
+	})
+	if len(sizes) > top {
+		sizes = sizes[:top]
+	}
+	return sizes
+}
+
+// String implements fmt.Stringer
+func (r *SizeReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d files totalling %s\n", r.Files, HumanSize(r.Total))
+	for _, section := range []struct {
+		title string
+		sizes []PathSize
+		dir   bool
+	}{
+		{"Largest files:", r.LargestFiles, false},
+		{"Largest directories:", r.LargestDirectories, true},
+	} {
+		if len(section.sizes) == 0 {
+			continue
+		}
+		fmt.Fprintln(&sb, section.title)
+		for _, ps := range section.sizes {
+			p := ps.Path
+			if section.dir {
+				p += "/"
+			}
+			fmt.Fprintf(&sb, "  %10s  %s\n", HumanSize(ps.Size), p)
+		}
+	}
+	return sb.String()
+}
+
+// HumanSize formats the given number of bytes using binary units (e.g. 1.5MiB).
+func HumanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// enabled returns whether the budget calls for sizing bundles.
+func (b *SizeBudget) enabled() bool {
+	return b != nil && (b.Max > 0 || b.Report != nil)
+}
+
+// top returns how many of the largest files and directories to report.
+func (b *SizeBudget) top() int {
+	if b.Top <= 0 {
+		return DefaultSizeReportTop
+	}
+	return b.Top
+}
+
+// check reports r when the budget calls for it, and returns an error
+// (including the report) when it is over budget.
+func (b *SizeBudget) check(r *SizeReport) error {
+	if b.Report != nil {
+		fmt.Fprint(b.Report, r)
+	}
+	if b.Max > 0 && r.Total > b.Max {
+		return fmt.Errorf("the bundle holds %s of files, which exceeds the budget of %s, "+
+			"exclude what isn't needed (e.g. via an ignore file):\n%s", HumanSize(r.Total), HumanSize(b.Max), r)
+	}
+	return nil
+}
+
+// CheckSize reports the size of the files held by the given bundle layers
+// when the budget calls for it, and returns an error (including the report)
+// when they are over budget.
+func CheckSize(layers []v1.Layer, b *SizeBudget) error {
+	if !b.enabled() {
+		return nil
+	}
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return err
+	}
+	r, err := Sizes(img, b.top())
+	if err != nil {
+		return err
+	}
+	return b.check(r)
+}
+
+// checkEntries is CheckSize for the entries of a bundle that has yet to be
+// produced, so that bundles over budget fail before anything is written.
+func checkEntries(entries []entry, b *SizeBudget) error {
+	if !b.enabled() {
+		return nil
+	}
+	var files []PathSize
+	for _, e := range entries {
+		if e.info.Mode().IsRegular() {
+			files = append(files, PathSize{Path: e.name, Size: e.info.Size()})
+		}
+	}
+	return b.check(sizes(files, b.top()))
+}
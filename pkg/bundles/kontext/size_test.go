@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kontext
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSizes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":          "package main",
+		"data/big.bin":     strings.Repeat("x", 3000),
+		"data/sub/mid.bin": strings.Repeat("x", 1000),
+		"docs/README.md":   strings.Repeat("x", 2000),
+	})
+
+	r, err := Sizes(bundleImage(t, Options{Directory: dir}), 2)
+	if err != nil {
+		t.Fatal("Sizes() =", err)
+	}
+	want := &SizeReport{
+		Total: 6012,
+		Files: 4,
+		LargestFiles: []PathSize{
+			{Path: "data/big.bin", Size: 3000},
+			{Path: "docs/README.md", Size: 2000},
+		},
+		LargestDirectories: []PathSize{
+			{Path: "data", Size: 4000},
+			{Path: "docs", Size: 2000},
+		},
+	}
+	if diff := cmp.Diff(want, r); diff != "" {
+		t.Errorf("Sizes (-want, +got): %s", diff)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := map[int64]string{
+		0:             "0B",
+		1023:          "1023B",
+		1024:          "1.0KiB",
+		1536:          "1.5KiB",
+		5 << 20:       "5.0MiB",
+		3 << 30:       "3.0GiB",
+		(5 << 40) / 2: "2.5TiB",
+	}
+	for n, want := range tests {
+		if got := HumanSize(n); got != want {
+			t.Errorf("HumanSize(%d) = %q, wanted %q", n, got, want)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":      "package main",
+		"data/big.bin": strings.Repeat("x", 3000),
+	})
+	layers, err := bundle(Options{Directory: dir}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+
+	if err := CheckSize(layers, nil); err != nil {
+		t.Error("CheckSize(nil) =", err)
+	}
+	if err := CheckSize(layers, &SizeBudget{Max: 4096}); err != nil {
+		t.Error("CheckSize() =", err)
+	}
+	if err := CheckSize(layers, &SizeBudget{Max: 1024}); err == nil || !strings.Contains(err.Error(), "data/big.bin") {
+		t.Errorf("CheckSize() = %v, wanted an error naming data/big.bin", err)
+	}
+
+	var buf bytes.Buffer
+	if err := CheckSize(layers, &SizeBudget{Report: &buf}); err != nil {
+		t.Error("CheckSize() =", err)
+	}
+	want := `2 files totalling 2.9KiB
+Largest files:
+      2.9KiB  data/big.bin
+         12B  main.go
+Largest directories:
+      2.9KiB  data/
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Report (-want, +got): %s", diff)
+	}
+}
+
+func TestBundleOverBudget(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":      "package main",
+		"data/big.bin": strings.Repeat("x", 3000),
+	})
+
+	// Bundles over budget fail before any layers are written.
+	out := t.TempDir()
+	if _, err := bundle(Options{Directory: dir, Size: &SizeBudget{Max: 1024}}, out); err == nil || !strings.Contains(err.Error(), "data/big.bin") {
+		t.Errorf("bundle() = %v, wanted an error naming data/big.bin", err)
+	}
+	if files, err := ioutil.ReadDir(out); err != nil {
+		t.Fatal("ReadDir() =", err)
+	} else if len(files) != 0 {
+		t.Errorf("bundle() wrote %d files, wanted none", len(files))
+	}
+
+	// The report matches that of the resulting layers.
+	var before, after bytes.Buffer
+	layers, err := bundle(Options{Directory: dir, Size: &SizeBudget{Max: 4096, Report: &before}}, t.TempDir())
+	if err != nil {
+		t.Fatal("bundle() =", err)
+	}
+	if err := CheckSize(layers, &SizeBudget{Report: &after}); err != nil {
+		t.Fatal("CheckSize() =", err)
+	}
+	if diff := cmp.Diff(after.String(), before.String()); diff != "" {
+		t.Errorf("Report (-layers, +entries): %s", diff)
+	}
+}
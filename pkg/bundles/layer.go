@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"archive/tar"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// NewLayer streams the tarball produced by write into a temporary file
+// within dir, and returns a layer backed by that file with the given
+// compression.  The layer is compressed on the fly each time its contents
+// are read (or, for zstd, into another file within dir), so that the
+// memory needed to produce it does not grow with the size of its contents.
+// The caller is responsible for cleaning up dir once the layer is no
+// longer needed (e.g. once it has been published).
+func NewLayer(dir string, c Compression, write func(tw *tar.Writer) error) (v1.Layer, error) {
+	f, err := ioutil.TempFile(dir, "layer-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	if err := write(tw); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return c.layer(f.Name())
+}
@@ -20,7 +20,6 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -38,7 +37,7 @@ type descriptor interface {
 	Image() (v1.Image, error)
 }
 
-func doMap(ctx context.Context, mt types.MediaType, baseDesc descriptor, fn Mutator) (ociThing, error) {
+func doMap(ctx context.Context, mt types.MediaType, baseDesc descriptor, fn Mutator, mo *mapOptions) (ociThing, error) {
 	switch mt {
 	case types.OCIImageIndex, types.DockerManifestList:
 		baseIndex, err := baseDesc.ImageIndex()
@@ -53,37 +52,58 @@ func doMap(ctx context.Context, mt types.MediaType, baseDesc descriptor, fn Muta
 		// Build an image for each child from the base and append it to a new index to produce the result.
 		adds := []mutate.IndexAddendum{}
 		for _, desc := range im.Manifests {
+			if !mo.wants(desc.Platform) {
+				continue
+			}
 			base, err := baseIndex.Image(desc.Digest)
 			if err != nil {
 				return nil, err
 			}
 
-			img, err := fn(ctx, base)
+			img, err := fn(withPlatform(ctx, desc.Platform), base)
 			if err != nil {
 				return nil, err
 			}
 
 			adds = append(adds, mutate.IndexAddendum{
 				Add: img,
+				// The media type is taken from img, which fn may have changed.
 				Descriptor: v1.Descriptor{
 					URLs:        desc.URLs,
-					MediaType:   desc.MediaType,
 					Annotations: desc.Annotations,
 					Platform:    desc.Platform,
 				},
 			})
 		}
+		if len(adds) == 0 {
+			return nil, fmt.Errorf("base has no images for the platforms %v", mo.platforms)
+		}
 
-		// Construct the image index.
-		return mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), mt), nil
+		// Construct the image index, keeping any annotations of the base index.
+		var ii v1.ImageIndex = mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), mt)
+		if len(im.Annotations) > 0 {
+			ii = mutate.Annotations(ii, im.Annotations).(v1.ImageIndex)
+		}
+		return ii, nil
 
 	case types.OCIManifestSchema1, types.DockerManifestSchema2:
 		base, err := baseDesc.Image()
 		if err != nil {
 			return nil, err
 		}
+		cf, err := base.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		var platform *v1.Platform
+		if cf.OS != "" {
+			platform = &v1.Platform{OS: cf.OS, Architecture: cf.Architecture, OSVersion: cf.OSVersion}
+		}
+		if !mo.wants(platform) {
+			return nil, fmt.Errorf("base image is not for any of the platforms %v", mo.platforms)
+		}
 
-		img, err := fn(ctx, base)
+		img, err := fn(withPlatform(ctx, platform), base)
 		if err != nil {
 			return nil, err
 		}
@@ -109,58 +129,152 @@ var (
 
 // Mutator is the signature of the callback supplied to Map.  This function will be called on each of the
 // images that comprise the referenced base, and the function maybe be called once (if an image) or many
-// times (is an image index).
+// times (is an image index).  The platform of each image is available via PlatformFrom.
 type Mutator func(ctx context.Context, img v1.Image) (v1.Image, error)
 
+// MapOption configures Map and MapTo.
+type MapOption func(*mapOptions)
+
+type mapOptions struct {
+	platforms []v1.Platform
+}
+
+// WithPlatforms restricts Map to the images of a base index for the given
+// platforms, so that nothing is produced (or pushed) for the others.  A
+// platform without a variant or OS version matches any variant or OS version.
+func WithPlatforms(platforms ...v1.Platform) MapOption {
+	return func(mo *mapOptions) {
+		mo.platforms = append(mo.platforms, platforms...)
+	}
+}
+
+// wants returns whether images for the given platform should be mapped.
+func (mo *mapOptions) wants(p *v1.Platform) bool {
+	if len(mo.platforms) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, want := range mo.platforms {
+		if PlatformMatches(want, *p) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlatformMatches returns whether the platform got satisfies want, where the
+// variant and OS version of want are only compared when they are set.
+func PlatformMatches(want, got v1.Platform) bool {
+	switch {
+	case want.OS != got.OS, want.Architecture != got.Architecture:
+		return false
+	case want.Variant != "" && want.Variant != got.Variant:
+		return false
+	case want.OSVersion != "" && want.OSVersion != got.OSVersion:
+		return false
+	default:
+		return true
+	}
+}
+
+type platformKey struct{}
+
+func withPlatform(ctx context.Context, p *v1.Platform) context.Context {
+	return context.WithValue(ctx, platformKey{}, p)
+}
+
+// PlatformFrom returns the platform of the image that a Mutator was passed,
+// when it is known.
+func PlatformFrom(ctx context.Context) *v1.Platform {
+	p, _ := ctx.Value(platformKey{}).(*v1.Platform)
+	return p
+}
+
 // Map loads the base reference, applies the Mutator function to all of the
 // images contained within, and publishes it to tag.  The digest of the resulting
 // image or image index is returned upon success, or an error on failure.
-func Map(ctx context.Context, base name.Reference, tag name.Tag, fn Mutator) (name.Digest, error) {
-	auth, err := authn.DefaultKeychain.Resolve(base.Context())
+func Map(ctx context.Context, base name.Reference, tag name.Tag, fn Mutator, opts ...MapOption) (name.Digest, error) {
+	ref, err := MapTo(ctx, &RegistryBase{Reference: base}, &RegistryTarget{Tag: tag}, nil, fn, opts...)
 	if err != nil {
 		return name.Digest{}, err
 	}
-	ropt := remote.WithAuth(auth)
+	return name.NewDigest(ref)
+}
+
+// MapTo is like Map, but loads the base from (and writes the result to) any
+// of the supported locations, e.g. a local OCI image layout.  It additionally
+// annotates the resulting image or image index (and each of the images it
+// contains) with anns, which are also recorded as labels in the configuration
+// of each of the images.  A reference to the result within target is returned
+// upon success.
+func MapTo(ctx context.Context, base Base, target Target, anns map[string]string, fn Mutator, opts ...MapOption) (string, error) {
+	mo := &mapOptions{}
+	for _, opt := range opts {
+		opt(mo)
+	}
+	if len(anns) > 0 {
+		fn = annotate(fn, anns)
+	}
 
 	// TODO(mattmoor): We can be more clever here to achieve incrementality,
 	// but just yolo package stuff for now.
-	mt, baseDesc, err := remoteGet(base, ropt)
+	mt, baseDesc, err := base.get(ctx)
 	if err != nil {
-		return name.Digest{}, err
+		return "", err
 	}
 
-	// If it is going to a different registry, switch auth.
-	// Don't do this unconditionally as resolution is ~400ms.
-	if tag.RegistryStr() != base.Context().RegistryStr() {
-		auth, err := authn.DefaultKeychain.Resolve(tag)
-		if err != nil {
-			return name.Digest{}, err
-		}
-		ropt = remote.WithAuth(auth)
-	}
-
-	oci, err := doMap(ctx, mt, baseDesc, fn)
+	oci, err := doMap(ctx, mt, baseDesc, fn, mo)
 	if err != nil {
-		return name.Digest{}, err
+		return "", err
+	}
+	if ii, ok := oci.(v1.ImageIndex); ok && len(anns) > 0 {
+		oci = mutate.Annotations(ii, anns).(v1.ImageIndex)
 	}
 
 	hash, err := oci.Digest()
 	if err != nil {
-		return name.Digest{}, err
+		return "", err
 	}
 
 	switch oci := oci.(type) {
 	case v1.ImageIndex:
-		if err := remoteWriteIndex(tag, oci, ropt); err != nil {
-			return name.Digest{}, err
+		if err := target.writeIndex(ctx, oci); err != nil {
+			return "", err
 		}
 	case v1.Image:
-		if err := remoteWrite(tag, oci, ropt); err != nil {
-			return name.Digest{}, err
+		if err := target.writeImage(ctx, oci); err != nil {
+			return "", err
 		}
 	default:
-		return name.Digest{}, fmt.Errorf("unknown type: %T", oci)
+		return "", fmt.Errorf("unknown type: %T", oci)
 	}
 
-	return name.NewDigest(tag.String() + "@" + hash.String())
+	return target.Reference(hash), nil
+}
+
+// annotate wraps the Mutator to annotate and label the images it produces.
+func annotate(fn Mutator, anns map[string]string) Mutator {
+	return func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		img, err := fn(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		cfg := *cf.Config.DeepCopy()
+		if cfg.Labels == nil {
+			cfg.Labels = make(map[string]string, len(anns))
+		}
+		for k, v := range anns {
+			cfg.Labels[k] = v
+		}
+		if img, err = mutate.Config(img, cfg); err != nil {
+			return nil, err
+		}
+		return mutate.Annotations(img, anns).(v1.Image), nil
+	}
 }
@@ -20,8 +20,11 @@ import (
 	"context"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
@@ -105,3 +108,137 @@ func TestBundleImage(t *testing.T) {
 		t.Errorf("callback called %d times, wanted %d", got, want)
 	}
 }
+
+func TestMapToAnnotated(t *testing.T) {
+	anns := map[string]string{"org.opencontainers.image.revision": "deadbeef"}
+
+	// checkImage checks that the image is annotated and labeled.
+	checkImage := func(t *testing.T, img v1.Image) {
+		t.Helper()
+		m, err := img.Manifest()
+		if err != nil {
+			t.Fatal("Manifest() =", err)
+		}
+		if diff := cmp.Diff(anns, m.Annotations); diff != "" {
+			t.Errorf("Annotations (-want, +got): %s", diff)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal("ConfigFile() =", err)
+		}
+		if diff := cmp.Diff(anns, cf.Config.Labels); diff != "" {
+			t.Errorf("Labels (-want, +got): %s", diff)
+		}
+	}
+
+	source, _ := ParseBase("ghcr.io/blah/blurg")
+	target, _ := ParseTarget("gcr.io/buffoon/banana")
+	identity := func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		return img, nil
+	}
+
+	t.Run("index", func(t *testing.T) {
+		remoteGet = func(name.Reference, ...remote.Option) (types.MediaType, descriptor, error) {
+			ii, err := random.Index(3, 4, 2)
+			return types.OCIImageIndex, &descriptorImpl{ii: ii}, err
+		}
+		remoteWriteIndex = func(_ name.Reference, ii v1.ImageIndex, _ ...remote.Option) error {
+			im, err := ii.IndexManifest()
+			if err != nil {
+				t.Fatal("IndexManifest() =", err)
+			}
+			if diff := cmp.Diff(anns, im.Annotations); diff != "" {
+				t.Errorf("Annotations (-want, +got): %s", diff)
+			}
+			for _, desc := range im.Manifests {
+				img, err := ii.Image(desc.Digest)
+				if err != nil {
+					t.Fatal("Image() =", err)
+				}
+				checkImage(t, img)
+			}
+			return nil
+		}
+		if _, err := MapTo(context.Background(), source, target, anns, identity); err != nil {
+			t.Error("MapTo() =", err)
+		}
+	})
+
+	t.Run("image", func(t *testing.T) {
+		remoteGet = func(name.Reference, ...remote.Option) (types.MediaType, descriptor, error) {
+			i, err := random.Image(3, 4)
+			return types.OCIManifestSchema1, &descriptorImpl{i: i}, err
+		}
+		remoteWrite = func(_ name.Reference, img v1.Image, _ ...remote.Option) error {
+			checkImage(t, img)
+			return nil
+		}
+		if _, err := MapTo(context.Background(), source, target, anns, identity); err != nil {
+			t.Error("MapTo() =", err)
+		}
+	})
+}
+
+func TestMapWithPlatforms(t *testing.T) {
+	platforms := []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "linux", Architecture: "s390x"},
+	}
+	var adds []mutate.IndexAddendum
+	for i := range platforms {
+		img, err := random.Image(3, 1)
+		if err != nil {
+			t.Fatal("random.Image() =", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &platforms[i]},
+		})
+	}
+	base := mutate.Annotations(mutate.AppendManifests(empty.Index, adds...),
+		map[string]string{"org.opencontainers.image.vendor": "mink"}).(v1.ImageIndex)
+
+	remoteGet = func(name.Reference, ...remote.Option) (types.MediaType, descriptor, error) {
+		return types.OCIImageIndex, &descriptorImpl{ii: base}, nil
+	}
+	var written v1.ImageIndex
+	remoteWriteIndex = func(_ name.Reference, ii v1.ImageIndex, _ ...remote.Option) error {
+		written = ii
+		return nil
+	}
+
+	source, _ := name.NewTag("ghcr.io/blah/blurg")
+	tag, _ := name.NewTag("gcr.io/buffoon/banana")
+
+	var seen []string
+	record := func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		seen = append(seen, PlatformFrom(ctx).String())
+		return img, nil
+	}
+
+	// The variant and OS version are only compared when they are requested.
+	if _, err := Map(context.Background(), source, tag, record,
+		WithPlatforms(v1.Platform{OS: "linux", Architecture: "amd64"}, v1.Platform{OS: "linux", Architecture: "arm64"})); err != nil {
+		t.Fatal("Map() =", err)
+	}
+	if diff := cmp.Diff([]string{"linux/amd64", "linux/arm64/v8"}, seen); diff != "" {
+		t.Errorf("Mutator calls (-want, +got): %s", diff)
+	}
+	im, err := written.IndexManifest()
+	if err != nil {
+		t.Fatal("IndexManifest() =", err)
+	}
+	if got, want := len(im.Manifests), 2; got != want {
+		t.Errorf("len(Manifests) = %d, wanted %d", got, want)
+	}
+	if got, want := im.Annotations["org.opencontainers.image.vendor"], "mink"; got != want {
+		t.Errorf("Annotations[vendor] = %q, wanted %q", got, want)
+	}
+
+	// Asking for platforms that the base doesn't have is an error.
+	if _, err := Map(context.Background(), source, tag, record,
+		WithPlatforms(v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v7"})); err == nil {
+		t.Error("Map() = nil, wanted error")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const (
+	// ModeAnnotation is the annotation on bundles that holds the mode in
+	// which they were bundled (e.g. kontext, git).
+	ModeAnnotation = "dev.mink.bundle.mode"
+
+	// DirectoryAnnotation is the annotation on bundles that holds the
+	// slash-separated path of the bundled directory, relative to the
+	// root of the source repository.
+	DirectoryAnnotation = "dev.mink.bundle.directory"
+
+	// TreeAnnotation is the annotation on bundles that holds a digest of
+	// the bundled tree of files (see TreeHash).
+	TreeAnnotation = "dev.mink.bundle.tree"
+)
+
+// Provenance describes where the contents of a bundle came from.
+type Provenance struct {
+	// Source is the URL of the source repository, if known.
+	Source string
+	// Revision is the commit of the source repository, if known.
+	Revision string
+	// Dirty indicates that the bundled directory has changes that are not
+	// part of Revision, in which case Revision isn't recorded on the bundle.
+	Dirty bool
+	// Created is when the bundled sources were created, see CreatedTime.
+	Created time.Time
+	// Mode is the mode in which the bundle was produced.
+	Mode string
+	// Directory is the slash-separated path of the bundled directory
+	// relative to the root of the source repository.
+	Directory string
+}
+
+// Annotations returns the annotations with which to record this provenance
+// on a bundle with the given layers.
+func (p Provenance) Annotations(layers []v1.Layer) (map[string]string, error) {
+	tree, err := TreeHash(layers)
+	if err != nil {
+		return nil, err
+	}
+	anns := map[string]string{
+		ocispec.AnnotationCreated: p.Created.UTC().Format(time.RFC3339),
+		ModeAnnotation:            p.Mode,
+		DirectoryAnnotation:       p.Directory,
+		TreeAnnotation:            tree.String(),
+	}
+	if p.Source != "" {
+		anns[ocispec.AnnotationSource] = RedactURL(p.Source)
+	}
+	if p.Revision != "" && !p.Dirty {
+		anns[ocispec.AnnotationRevision] = p.Revision
+	}
+	return anns, nil
+}
+
+// TreeHash returns a digest of the tree of files held by the given layers.
+// Unlike the digest of the bundle, this does not depend on the base image
+// or on how the layers are compressed.
+func TreeHash(layers []v1.Layer) (v1.Hash, error) {
+	h := sha256.New()
+	for _, l := range layers {
+		diffID, err := l.DiffID()
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		fmt.Fprintln(h, diffID.String())
+	}
+	return v1.Hash{
+		Algorithm: "sha256",
+		Hex:       hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// CreatedTime returns the time that bundles should record as their creation
+// time.  This is derived from SOURCE_DATE_EPOCH when it is set, and is
+// otherwise the time of the bundled commit (when known) or the unix epoch,
+// so that it doesn't vary from one bundle of the same sources to the next.
+func CreatedTime(commit time.Time) (time.Time, error) {
+	if os.Getenv(SourceDateEpochEnv) == "" && !commit.IsZero() {
+		return commit.UTC(), nil
+	}
+	return SourceDateEpoch()
+}
+
+// RedactURL strips any credentials from the given URL, so that they are not
+// recorded in bundles.  Anything that isn't a URL (e.g. scp-style git
+// remotes) is returned as-is.
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok && u.Scheme == "ssh" {
+		// Usernames in ssh URLs (e.g. git@) aren't credentials.
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
+
+// LocalProvenance returns the provenance of a bundle of the given local
+// directory in the given mode.  When the directory is within a git
+// repository, the provenance records the URL of its "origin" remote, the
+// commit checked out, and the path of the directory within it.  The
+// provenance is marked Dirty when the directory holds changes (including
+// untracked files) relative to that commit.
+func LocalProvenance(mode, directory string) (Provenance, error) {
+	p := Provenance{
+		Mode:      mode,
+		Directory: ".",
+	}
+
+	repo, err := git.PlainOpenWithOptions(directory, &git.PlainOpenOptions{
+		DetectDotGit: true,
+	})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		p.Created, err = CreatedTime(time.Time{})
+		return p, err
+	} else if err != nil {
+		return Provenance{}, err
+	}
+
+	var commitTime time.Time
+	switch head, err := repo.Head(); {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// There are no commits yet.
+	case err != nil:
+		return Provenance{}, err
+	default:
+		p.Revision = head.Hash().String()
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return Provenance{}, err
+		}
+		commitTime = commit.Committer.When
+	}
+	if p.Created, err = CreatedTime(commitTime); err != nil {
+		return Provenance{}, err
+	}
+
+	if remote, err := repo.Remote(git.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+		p.Source = remote.Config().URLs[0]
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return Provenance{}, err
+	}
+	abs, err := filepath.Abs(directory)
+	if err != nil {
+		return Provenance{}, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return Provenance{}, err
+	}
+	root, err := filepath.EvalSymlinks(wt.Filesystem.Root())
+	if err != nil {
+		return Provenance{}, err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return Provenance{}, err
+	}
+	if rel := filepath.ToSlash(rel); rel != ".." && !strings.HasPrefix(rel, "../") {
+		p.Directory = rel
+	}
+
+	if p.Revision != "" {
+		status, err := wt.Status()
+		if err != nil {
+			return Provenance{}, err
+		}
+		for file, fs := range status {
+			if fs.Staging == git.Unmodified && fs.Worktree == git.Unmodified {
+				continue
+			}
+			if p.Directory == "." || strings.HasPrefix(file, p.Directory+"/") {
+				p.Dirty = true
+				break
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Mode is what to do when secrets are found.
+type Mode string
+
+const (
+	// ErrorMode fails to publish bundles in which secrets are found.
+	ErrorMode Mode = "error"
+	// WarnMode reports the secrets that are found, but publishes anyway.
+	WarnMode Mode = "warn"
+	// OffMode doesn't scan bundles for secrets.
+	OffMode Mode = "off"
+)
+
+// Modes holds the supported kinds of Mode.
+var Modes = []Mode{ErrorMode, WarnMode, OffMode}
+
+// ParseMode parses the given string as a Mode.
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported mode %q, wanted one of %v", s, Modes)
+}
+
+// vendoredDirs are directories that hold copies of dependencies, which are
+// full of checksums and fixtures that look random without being secrets.
+var vendoredDirs = sets.NewString("vendor", "node_modules", "third_party")
+
+// Policy is how bundles are scanned for secrets, and what happens when
+// some are found.  Files within directories of vendored dependencies
+// (e.g. vendor/ or node_modules/) are not scanned for high-entropy tokens,
+// but are subject to the other rules.
+type Policy struct {
+	// Mode is what to do when secrets are found.
+	Mode Mode
+
+	// Scanner looks for secrets, which defaults to Default().
+	Scanner Scanner
+
+	// Allow holds the entries of an allowlist for known false positives.
+	// Each entry is a path.Match pattern, optionally prefixed by the name of
+	// a rule and a colon (e.g. high-entropy:testdata/*.json) to allow only
+	// the findings of that rule.  Patterns without a slash are matched
+	// against the base name of files, and others against their whole path.
+	Allow []string
+
+	// Warnings is where findings are reported in WarnMode.
+	Warnings io.Writer
+}
+
+// Validate checks that the entries of the allowlist are well formed.
+func (p *Policy) Validate() error {
+	for _, entry := range p.Allow {
+		pattern := entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			pattern = entry[i+1:]
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", entry, err)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the policy calls for scanning.
+func (p *Policy) Enabled() bool {
+	return p != nil && p.Mode != OffMode
+}
+
+// scanner returns the configured Scanner, or the default one.
+func (p *Policy) scanner() Scanner {
+	if p.Scanner != nil {
+		return p.Scanner
+	}
+	return Default()
+}
+
+// Scan returns the findings for the given file that aren't allowed.
+func (p *Policy) Scan(path string, content []byte) []Finding {
+	vendored := vendored(path)
+	var findings []Finding
+	for _, f := range p.scanner().Scan(path, content) {
+		if vendored && f.Rule == HighEntropyRule {
+			continue
+		}
+		if !p.allowed(f) {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// vendored returns whether the file is within a directory of vendored
+// dependencies.
+func vendored(p string) bool {
+	parts := strings.Split(p, "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if vendoredDirs.Has(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns whether the allowlist covers the finding.
+func (p *Policy) allowed(f Finding) bool {
+	for _, entry := range p.Allow {
+		pattern := entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			if entry[:i] != f.Rule {
+				continue
+			}
+			pattern = entry[i+1:]
+		}
+		name := f.Path
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(f.Path)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check applies the policy to the findings, returning an error in ErrorMode
+// if there are any, and reporting them to Warnings in WarnMode.
+func (p *Policy) Check(findings []Finding) error {
+	if !p.Enabled() || len(findings) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(findings))
+	for _, f := range findings {
+		lines = append(lines, "  "+f.String())
+	}
+
+	if p.Mode == WarnMode {
+		if p.Warnings != nil {
+			fmt.Fprintf(p.Warnings, "WARNING: the bundle appears to hold secrets:\n%s\n", strings.Join(lines, "\n"))
+		}
+		return nil
+	}
+	return fmt.Errorf("the bundle appears to hold secrets, remove them (e.g. via an ignore file) "+
+		"or allow known false positives via secret-scan-allow:\n%s", strings.Join(lines, "\n"))
+}
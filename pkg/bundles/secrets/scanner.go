@@ -0,0 +1,257 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// These are the names of the rules of the scanners in this package, which
+// may be used to scope the entries of an allowlist (see Policy).
+const (
+	CredentialFileRule = "credential-file"
+	PrivateKeyRule     = "private-key"
+	HighEntropyRule    = "high-entropy"
+)
+
+// Finding is something that looks like a secret.
+type Finding struct {
+	// Path is the slash-separated path of the file within the bundle.
+	Path string
+	// Line is the line on which the secret was found, or zero when the
+	// finding concerns the whole file.
+	Line int
+	// Rule is the name of the rule that produced the finding.
+	Rule string
+	// Description says what was found, without repeating the secret.
+	Description string
+}
+
+// String implements fmt.Stringer
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s (%s)", f.Path, f.Description, f.Rule)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Path, f.Line, f.Description, f.Rule)
+}
+
+// Scanner looks for secrets within the files of a bundle.
+type Scanner interface {
+	// Scan returns the findings for the file at the given slash-separated
+	// path within the bundle.  The content is nil when the file is not
+	// scanned for its contents (e.g. it is binary or very large).
+	Scan(path string, content []byte) []Finding
+}
+
+// Scanners combines several Scanners into one.
+type Scanners []Scanner
+
+// Scan implements Scanner
+func (ss Scanners) Scan(path string, content []byte) []Finding {
+	var findings []Finding
+	for _, s := range ss {
+		findings = append(findings, s.Scan(path, content)...)
+	}
+	return findings
+}
+
+// Default returns the scanners for credential files, private keys and
+// high-entropy tokens.
+func Default() Scanner {
+	return Scanners{CredentialFiles{}, PrivateKeys{}, HighEntropy{}}
+}
+
+var (
+	// credentialFileNames holds the base names of files that hold credentials.
+	credentialFileNames = sets.NewString(
+		".env", ".netrc", "_netrc", ".pypirc", ".git-credentials", ".htpasswd",
+		".pgpass", "id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	)
+	// credentialFileExts holds the extensions of files that hold credentials.
+	credentialFileExts = sets.NewString(".p12", ".pfx", ".jks", ".keystore")
+	// credentialFilePaths holds the (trailing) paths of files that hold credentials.
+	credentialFilePaths = []string{".aws/credentials", ".docker/config.json", ".kube/config"}
+	// envTemplateSuffixes are the suffixes of .env files that are templates
+	// rather than actual environments (e.g. .env.example).
+	envTemplateSuffixes = sets.NewString(".example", ".sample", ".template", ".dist")
+)
+
+// CredentialFiles is a Scanner that flags files whose names suggest that
+// they hold credentials (e.g. .env, .netrc or id_rsa).
+type CredentialFiles struct{}
+
+// Scan implements Scanner
+func (CredentialFiles) Scan(p string, _ []byte) []Finding {
+	base := path.Base(p)
+	found := credentialFileNames.Has(base) || credentialFileExts.Has(path.Ext(base))
+	if strings.HasPrefix(base, ".env.") && !envTemplateSuffixes.Has(path.Ext(base)) {
+		found = true
+	}
+	for _, suffix := range credentialFilePaths {
+		if p == suffix || strings.HasSuffix(p, "/"+suffix) {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return []Finding{{
+		Path:        p,
+		Rule:        CredentialFileRule,
+		Description: "file name suggests that it holds credentials",
+	}}
+}
+
+var privateKeyRegexp = regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`)
+
+// PrivateKeys is a Scanner that flags PEM-encoded private keys.
+type PrivateKeys struct{}
+
+// Scan implements Scanner
+func (PrivateKeys) Scan(p string, content []byte) []Finding {
+	var findings []Finding
+	forEachLine(content, func(n int, line []byte) {
+		if privateKeyRegexp.Match(line) {
+			findings = append(findings, Finding{
+				Path:        p,
+				Line:        n,
+				Rule:        PrivateKeyRule,
+				Description: "PEM-encoded private key",
+			})
+		}
+	})
+	return findings
+}
+
+var (
+	// tokenRegexp matches candidate tokens, which are runs of base64 (or
+	// url-safe base64) characters.
+	tokenRegexp = regexp.MustCompile(`[A-Za-z0-9+/_-]{20,}={0,2}`)
+
+	// lockFiles are files that are full of high-entropy digests of
+	// dependencies, which aren't secrets.
+	lockFiles = sets.NewString(
+		"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml",
+		"Cargo.lock", "Gemfile.lock", "poetry.lock", "composer.lock",
+	)
+)
+
+// HighEntropy is a Scanner that flags tokens that look randomly generated,
+// as API keys and passwords tend to be.
+type HighEntropy struct {
+	// Threshold is the entropy (in bits per character) above which tokens
+	// are flagged, which defaults to DefaultEntropyThreshold.
+	Threshold float64
+}
+
+const (
+	// DefaultEntropyThreshold is the default HighEntropy.Threshold.
+	DefaultEntropyThreshold = 4.5
+
+	// maxTokenLength bounds the length of tokens that are flagged, since
+	// longer ones tend to be embedded data (e.g. compressed descriptors).
+	maxTokenLength = 256
+)
+
+// Scan implements Scanner
+func (he HighEntropy) Scan(p string, content []byte) []Finding {
+	if lockFiles.Has(path.Base(p)) {
+		return nil
+	}
+	threshold := he.Threshold
+	if threshold == 0 {
+		threshold = DefaultEntropyThreshold
+	}
+
+	var findings []Finding
+	forEachLine(content, func(n int, line []byte) {
+		for _, loc := range tokenRegexp.FindAllIndex(line, -1) {
+			token := line[loc[0]:loc[1]]
+			// Require a mix of character classes, which excludes hex digests,
+			// identifiers and paths.
+			if len(token) > maxTokenLength || !mixed(token) || inURL(line, loc[0]) || entropy(token) < threshold {
+				continue
+			}
+			findings = append(findings, Finding{
+				Path:        p,
+				Line:        n,
+				Rule:        HighEntropyRule,
+				Description: fmt.Sprintf("high-entropy token of %d characters", len(token)),
+			})
+			return
+		}
+	})
+	return findings
+}
+
+// inURL returns whether the token at the given offset of the line is part of
+// a URL (e.g. the identifier of a shared document), which the scheme that
+// precedes it within the same word gives away.
+func inURL(line []byte, offset int) bool {
+	start := bytes.LastIndexAny(line[:offset], " \t\"'`<>()[]{}") + 1
+	return bytes.Contains(line[start:offset], []byte("://"))
+}
+
+// mixed returns whether the token has upper and lower case letters and digits.
+func mixed(token []byte) bool {
+	var upper, lower, digit bool
+	for _, c := range token {
+		switch {
+		case 'A' <= c && c <= 'Z':
+			upper = true
+		case 'a' <= c && c <= 'z':
+			lower = true
+		case '0' <= c && c <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// entropy returns the Shannon entropy of the token in bits per character.
+func entropy(token []byte) float64 {
+	var counts [256]int
+	for _, c := range token {
+		counts[c]++
+	}
+	var e float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(len(token))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// forEachLine calls fn with each (1-indexed) line of the content.
+func forEachLine(content []byte, fn func(n int, line []byte)) {
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, len(content)+1)
+	for n := 1; s.Scan(); n++ {
+		fn(n, s.Bytes())
+	}
+}
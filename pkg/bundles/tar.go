@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpochEnv is the environment variable that may be used to
+// control the timestamps recorded in bundles, see:
+// https://reproducible-builds.org/specs/source-date-epoch/
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// SourceDateEpoch returns the time that bundles should record as the
+// modification time of their contents.  This is derived from SOURCE_DATE_EPOCH
+// when it is set, and is otherwise the unix epoch.
+func SourceDateEpoch() (time.Time, error) {
+	v := os.Getenv(SourceDateEpochEnv)
+	if v == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: %w", SourceDateEpochEnv, v, err)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// NormalizeHeader clears the fields of hdr that are sensitive to the
+// environment in which a bundle is produced (ownership, access times, etc)
+// and stamps it with the provided modification time, so that the same
+// inputs always produce the same tarball.
+func NormalizeHeader(hdr *tar.Header, mtime time.Time) *tar.Header {
+	hdr.ModTime = mtime
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Devmajor, hdr.Devminor = 0, 0
+	hdr.PAXRecords = nil
+	return hdr
+}
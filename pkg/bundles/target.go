@@ -0,0 +1,409 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// LayoutPrefix is the prefix of references to OCI image layouts on the
+	// local filesystem, e.g. oci:/path/to/layout
+	LayoutPrefix = "oci:"
+
+	// TarballPrefix is the prefix of references to tarballs (as produced
+	// by `docker save`) on the local filesystem, e.g. tarball:/path/to/bundle.tar
+	TarballPrefix = "tarball:"
+
+	// layoutRefName is the name with which bundles are recorded in OCI
+	// image layouts, and which identifies the base image within a layout
+	// holding several images.
+	layoutRefName = "latest"
+)
+
+// tarballTag is the tag with which images are recorded in tarballs, which
+// is what they are tagged as when they are loaded (e.g. via `docker load`).
+var tarballTag, _ = name.NewTag("bundle:latest")
+
+// Base is the image or image index on which bundles are based.
+type Base interface {
+	fmt.Stringer
+
+	get(ctx context.Context) (types.MediaType, descriptor, error)
+}
+
+// Target is where bundles are written.
+type Target interface {
+	fmt.Stringer
+
+	// Reference returns a reference to the image or image index with the
+	// given digest that has been written to the target.
+	Reference(h v1.Hash) string
+
+	writeImage(ctx context.Context, img v1.Image) error
+	writeIndex(ctx context.Context, ii v1.ImageIndex) error
+}
+
+// ParseBase parses the given string as the Base of a bundle, which is either
+// a reference to an image in a registry, or an OCI image layout on the local
+// filesystem (e.g. oci:/path/to/layout), which must either hold a single
+// image (or image index) or one named "latest", unless a digest is given
+// (e.g. oci:/path/to/layout@sha256:...) as returned by Target.Reference.
+func ParseBase(s string) (Base, error) {
+	if strings.HasPrefix(s, LayoutPrefix) {
+		path, digest, err := splitDigest(strings.TrimPrefix(s, LayoutPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return &layoutBase{path: path, digest: digest}, nil
+	}
+	ref, err := name.ParseReference(s, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	return &RegistryBase{Reference: ref}, nil
+}
+
+// ParseTarget parses the given string as the Target of a bundle, which is
+// either a tag within a registry, an OCI image layout on the local filesystem
+// (e.g. oci:/path/to/layout) or a tarball on the local filesystem (e.g.
+// tarball:/path/to/bundle.tar).
+func ParseTarget(s string) (Target, error) {
+	switch {
+	case strings.HasPrefix(s, LayoutPrefix):
+		return &layoutTarget{path: strings.TrimPrefix(s, LayoutPrefix)}, nil
+	case strings.HasPrefix(s, TarballPrefix):
+		return &tarballTarget{path: strings.TrimPrefix(s, TarballPrefix)}, nil
+	}
+	tag, err := name.NewTag(s, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	return &RegistryTarget{Tag: tag}, nil
+}
+
+// RegistryBase is a Base within a container registry.
+type RegistryBase struct {
+	name.Reference
+}
+
+func (rb *RegistryBase) get(ctx context.Context) (types.MediaType, descriptor, error) {
+	auth, err := authn.DefaultKeychain.Resolve(rb.Context())
+	if err != nil {
+		return "", nil, err
+	}
+	return remoteGet(rb.Reference, remote.WithAuth(auth), remote.WithContext(ctx))
+}
+
+// RegistryTarget is a Target within a container registry.
+type RegistryTarget struct {
+	name.Tag
+}
+
+// Reference implements Target
+func (rt *RegistryTarget) Reference(h v1.Hash) string {
+	return rt.Tag.String() + "@" + h.String()
+}
+
+func (rt *RegistryTarget) options(ctx context.Context) ([]remote.Option, error) {
+	auth, err := authn.DefaultKeychain.Resolve(rt.Context())
+	if err != nil {
+		return nil, err
+	}
+	return []remote.Option{remote.WithAuth(auth), remote.WithContext(ctx)}, nil
+}
+
+func (rt *RegistryTarget) writeImage(ctx context.Context, img v1.Image) error {
+	ropts, err := rt.options(ctx)
+	if err != nil {
+		return err
+	}
+	return remoteWrite(rt.Tag, img, ropts...)
+}
+
+func (rt *RegistryTarget) writeIndex(ctx context.Context, ii v1.ImageIndex) error {
+	ropts, err := rt.options(ctx)
+	if err != nil {
+		return err
+	}
+	return remoteWriteIndex(rt.Tag, ii, ropts...)
+}
+
+// Load loads the image referenced by the given string, which is anything
+// accepted by ParseBase or a tarball on the local filesystem (e.g.
+// tarball:/path/to/bundle.tar).  When it references an image index, the
+// image for the platform on which we are running is returned.
+func Load(ctx context.Context, s string) (v1.Image, error) {
+	if strings.HasPrefix(s, TarballPrefix) {
+		path, _, err := splitDigest(strings.TrimPrefix(s, TarballPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return tarball.ImageFromPath(path, nil)
+	}
+
+	base, err := ParseBase(s)
+	if err != nil {
+		return nil, err
+	}
+	mt, desc, err := base.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mt.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return platformImage(ii)
+	}
+	return desc.Image()
+}
+
+// BaseDigest returns the digest of the image or image index to which base
+// currently refers, so that callers may notice when a tag has moved.
+func BaseDigest(ctx context.Context, base Base) (v1.Hash, error) {
+	mt, desc, err := base.get(ctx)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	if mt.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return v1.Hash{}, err
+		}
+		return ii.Digest()
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return img.Digest()
+}
+
+// Copy writes the image or image index to which base refers to target as-is
+// (e.g. a bundle that was produced in a local OCI image layout), returning a
+// reference to the result.
+func Copy(ctx context.Context, base Base, target Target) (string, error) {
+	mt, desc, err := base.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	if mt.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return "", err
+		}
+		h, err := ii.Digest()
+		if err != nil {
+			return "", err
+		}
+		if err := target.writeIndex(ctx, ii); err != nil {
+			return "", err
+		}
+		return target.Reference(h), nil
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return "", err
+	}
+	h, err := img.Digest()
+	if err != nil {
+		return "", err
+	}
+	if err := target.writeImage(ctx, img); err != nil {
+		return "", err
+	}
+	return target.Reference(h), nil
+}
+
+// splitDigest splits an optional @digest suffix off of the given path.
+func splitDigest(s string) (string, *v1.Hash, error) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 {
+		return s, nil, nil
+	}
+	h, err := v1.NewHash(s[i+1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return s[:i], &h, nil
+}
+
+// layoutBase is a Base within an OCI image layout.
+type layoutBase struct {
+	path string
+	// digest optionally selects the image (or image index) within the layout.
+	digest *v1.Hash
+}
+
+func (lb *layoutBase) String() string {
+	if lb.digest != nil {
+		return LayoutPrefix + lb.path + "@" + lb.digest.String()
+	}
+	return LayoutPrefix + lb.path
+}
+
+func (lb *layoutBase) get(ctx context.Context) (types.MediaType, descriptor, error) {
+	ii, err := layout.ImageIndexFromPath(lb.path)
+	if err != nil {
+		return "", nil, err
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var found []v1.Descriptor
+	for _, desc := range im.Manifests {
+		switch {
+		case lb.digest != nil:
+			if desc.Digest == *lb.digest {
+				found = append(found, desc)
+			}
+		case desc.Annotations[ocispec.AnnotationRefName] == layoutRefName:
+			found = append(found, desc)
+		}
+	}
+	if len(found) == 0 && lb.digest == nil {
+		found = im.Manifests
+	}
+	if lb.digest != nil && len(found) == 0 {
+		return "", nil, fmt.Errorf("%s not found", lb)
+	}
+	if len(found) != 1 {
+		return "", nil, fmt.Errorf("%s holds %d images, wanted one (or one named %q)", lb, len(found), layoutRefName)
+	}
+	return found[0].MediaType, &layoutDescriptor{ii: ii, hash: found[0].Digest}, nil
+}
+
+// layoutDescriptor implements descriptor for an entry in an OCI image layout.
+type layoutDescriptor struct {
+	ii   v1.ImageIndex
+	hash v1.Hash
+}
+
+func (ld *layoutDescriptor) ImageIndex() (v1.ImageIndex, error) {
+	return ld.ii.ImageIndex(ld.hash)
+}
+
+func (ld *layoutDescriptor) Image() (v1.Image, error) {
+	return ld.ii.Image(ld.hash)
+}
+
+// layoutTarget is a Target within an OCI image layout, which is created if it
+// doesn't exist.  Each write replaces the image previously written there.
+type layoutTarget struct {
+	path string
+}
+
+func (lt *layoutTarget) String() string {
+	return LayoutPrefix + lt.path
+}
+
+// Reference implements Target
+func (lt *layoutTarget) Reference(h v1.Hash) string {
+	return lt.String() + "@" + h.String()
+}
+
+func (lt *layoutTarget) layout() (layout.Path, error) {
+	if p, err := layout.FromPath(lt.path); err == nil {
+		return p, nil
+	}
+	return layout.Write(lt.path, empty.Index)
+}
+
+func (lt *layoutTarget) options() (match.Matcher, layout.Option) {
+	return match.Annotation(ocispec.AnnotationRefName, layoutRefName),
+		layout.WithAnnotations(map[string]string{ocispec.AnnotationRefName: layoutRefName})
+}
+
+func (lt *layoutTarget) writeImage(ctx context.Context, img v1.Image) error {
+	p, err := lt.layout()
+	if err != nil {
+		return err
+	}
+	matcher, opt := lt.options()
+	return p.ReplaceImage(img, matcher, opt)
+}
+
+func (lt *layoutTarget) writeIndex(ctx context.Context, ii v1.ImageIndex) error {
+	p, err := lt.layout()
+	if err != nil {
+		return err
+	}
+	matcher, opt := lt.options()
+	return p.ReplaceIndex(ii, matcher, opt)
+}
+
+// tarballTarget is a Target that writes a tarball (as produced by `docker save`).
+// Since these cannot hold image indices, only the image for the platform on
+// which we are running is written for those.
+type tarballTarget struct {
+	path string
+}
+
+func (tt *tarballTarget) String() string {
+	return TarballPrefix + tt.path
+}
+
+// Reference implements Target
+func (tt *tarballTarget) Reference(h v1.Hash) string {
+	return tt.String() + "@" + h.String()
+}
+
+func (tt *tarballTarget) writeImage(ctx context.Context, img v1.Image) error {
+	return tarball.WriteToFile(tt.path, tarballTag, img)
+}
+
+func (tt *tarballTarget) writeIndex(ctx context.Context, ii v1.ImageIndex) error {
+	img, err := platformImage(ii)
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", tt, err)
+	}
+	return tt.writeImage(ctx, img)
+}
+
+// platformImage returns the image within the index for the platform on which
+// we are running.
+func platformImage(ii v1.ImageIndex) (v1.Image, error) {
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	want := v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	for _, desc := range im.Manifests {
+		if desc.Platform != nil && PlatformMatches(want, *desc.Platform) {
+			return ii.Image(desc.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no image for platform %s/%s", want.OS, want.Architecture)
+}
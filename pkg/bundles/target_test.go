@@ -0,0 +1,234 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundles
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{{
+		in:   "ghcr.io/blah/blurg",
+		want: "ghcr.io/blah/blurg",
+	}, {
+		in:   "oci:/tmp/layout",
+		want: "oci:/tmp/layout",
+	}, {
+		in:   "tarball:/tmp/bundle.tar",
+		want: "tarball:/tmp/bundle.tar",
+	}}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			target, err := ParseTarget(test.in)
+			if err != nil {
+				t.Fatal("ParseTarget() =", err)
+			}
+			if got := target.String(); got != test.want {
+				t.Errorf("String() = %q, wanted %q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := ParseTarget("not a valid tag"); err == nil {
+		t.Error("ParseTarget() = nil, wanted error")
+	}
+}
+
+// platformIndex returns a random image index holding an image for the
+// platform on which we are running.
+func platformIndex(t *testing.T) v1.ImageIndex {
+	t.Helper()
+	img, err := random.Image(3, 2)
+	if err != nil {
+		t.Fatal("random.Image() =", err)
+	}
+	return mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			Platform: &v1.Platform{OS: "linux", Architecture: runtime.GOARCH},
+		},
+	})
+}
+
+func TestMapToLayout(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Write a base image index to a layout, as would be done to
+	// bundle without access to a registry.
+	basePath := filepath.Join(dir, "base")
+	baseIndex := platformIndex(t)
+	if _, err := layout.Write(basePath, empty.Index); err != nil {
+		t.Fatal("layout.Write() =", err)
+	}
+	p, _ := layout.FromPath(basePath)
+	if err := p.AppendIndex(baseIndex); err != nil {
+		t.Fatal("AppendIndex() =", err)
+	}
+
+	base, err := ParseBase(LayoutPrefix + basePath)
+	if err != nil {
+		t.Fatal("ParseBase() =", err)
+	}
+	if got, err := BaseDigest(ctx, base); err != nil {
+		t.Fatal("BaseDigest() =", err)
+	} else if want, _ := baseIndex.Digest(); got != want {
+		t.Errorf("BaseDigest() = %v, wanted %v", got, want)
+	}
+	layer, err := random.Layer(10, "application/vnd.oci.image.layer.v1.tar")
+	if err != nil {
+		t.Fatal("random.Layer() =", err)
+	}
+	appendLayer := func(ctx context.Context, img v1.Image) (v1.Image, error) {
+		return mutate.AppendLayers(img, layer)
+	}
+
+	outPath := filepath.Join(dir, "out")
+	target, err := ParseTarget(LayoutPrefix + outPath)
+	if err != nil {
+		t.Fatal("ParseTarget() =", err)
+	}
+
+	// Writing twice replaces the first result rather than adding to it.
+	for i := 0; i < 2; i++ {
+		ref, err := MapTo(ctx, base, target, nil, appendLayer)
+		if err != nil {
+			t.Fatal("MapTo() =", err)
+		}
+		if !strings.HasPrefix(ref, LayoutPrefix+outPath+"@sha256:") {
+			t.Errorf("MapTo() = %s, wanted a digest within %s", ref, outPath)
+		}
+	}
+
+	ii, err := layout.ImageIndexFromPath(outPath)
+	if err != nil {
+		t.Fatal("ImageIndexFromPath() =", err)
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal("IndexManifest() =", err)
+	}
+	if got, want := len(im.Manifests), 1; got != want {
+		t.Fatalf("len(Manifests) = %d, wanted %d", got, want)
+	}
+
+	// The result can itself serve as a base.
+	outBase, _ := ParseBase(LayoutPrefix + outPath)
+	if _, err := MapTo(ctx, outBase, &tarballTarget{path: filepath.Join(dir, "out.tar")}, nil, appendLayer); err != nil {
+		t.Fatal("MapTo() =", err)
+	}
+	img, err := tarball.ImageFromPath(filepath.Join(dir, "out.tar"), nil)
+	if err != nil {
+		t.Fatal("ImageFromPath() =", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal("Layers() =", err)
+	}
+	// The random base has two layers, to which we appended two more.
+	if got, want := len(layers), 4; got != want {
+		t.Errorf("len(Layers) = %d, wanted %d", got, want)
+	}
+}
+
+func TestLayoutBaseAmbiguous(t *testing.T) {
+	dir := t.TempDir()
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal("layout.Write() =", err)
+	}
+	for i := 0; i < 2; i++ {
+		img, err := random.Image(3, 1)
+		if err != nil {
+			t.Fatal("random.Image() =", err)
+		}
+		if err := p.AppendImage(img); err != nil {
+			t.Fatal("AppendImage() =", err)
+		}
+	}
+
+	base, _ := ParseBase(LayoutPrefix + dir)
+	if _, err := MapTo(context.Background(), base, &layoutTarget{path: t.TempDir()}, nil,
+		func(ctx context.Context, img v1.Image) (v1.Image, error) {
+			return img, nil
+		}); err == nil {
+		t.Error("MapTo() = nil, wanted error")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	idx := platformIndex(t)
+	img, err := random.Image(3, 1)
+	if err != nil {
+		t.Fatal("random.Image() =", err)
+	}
+	for name, write := range map[string]func(p layout.Path) error{
+		"index": func(p layout.Path) error { return p.AppendIndex(idx) },
+		"image": func(p layout.Path) error { return p.AppendImage(img) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srcPath := filepath.Join(dir, name)
+			p, err := layout.Write(srcPath, empty.Index)
+			if err != nil {
+				t.Fatal("layout.Write() =", err)
+			}
+			if err := write(p); err != nil {
+				t.Fatal("Append() =", err)
+			}
+			base, _ := ParseBase(LayoutPrefix + srcPath)
+			want, err := BaseDigest(ctx, base)
+			if err != nil {
+				t.Fatal("BaseDigest() =", err)
+			}
+
+			outPath := filepath.Join(dir, name+"-out")
+			target, _ := ParseTarget(LayoutPrefix + outPath)
+			ref, err := Copy(ctx, base, target)
+			if err != nil {
+				t.Fatal("Copy() =", err)
+			}
+			if got := target.Reference(want); ref != got {
+				t.Errorf("Copy() = %s, wanted %s", ref, got)
+			}
+
+			// The copy is the same thing.
+			out, _ := ParseBase(ref)
+			if got, err := BaseDigest(ctx, out); err != nil {
+				t.Fatal("BaseDigest() =", err)
+			} else if got != want {
+				t.Errorf("BaseDigest() = %v, wanted %v", got, want)
+			}
+		})
+	}
+}
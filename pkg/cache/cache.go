@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Bundles is the kind of entry that maps the inputs of a bundle to the
+	// digest of the bundle published from them.
+	Bundles = "bundles"
+
+	// Builds is the kind of entry that maps a bundle and the configuration
+	// of a build of it to the digest of the image it produced.
+	Builds = "builds"
+)
+
+// Cache is a directory of entries, each of which is a file holding a value
+// named by the kind of the entry and the digest of its key.
+type Cache struct {
+	dir string
+}
+
+// New returns a Cache backed by the given directory, which is created
+// as entries are added.
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Default returns the Cache under the user's cache directory, typically
+// ~/.cache/mink.
+func Default() (*Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return New(filepath.Join(dir, "mink")), nil
+}
+
+// Key returns a digest of the given parts, for use as the key of an entry.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		// Length-prefix each part, so that adjacent parts can't run together.
+		fmt.Fprintf(h, "%d:%s\n", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key)
+}
+
+// Get returns the value of the entry of the given kind with the given key,
+// and whether there is one.
+func (c *Cache) Get(kind, key string) (string, bool, error) {
+	b, err := ioutil.ReadFile(c.path(kind, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(b)), true, nil
+}
+
+// Put records the value of the entry of the given kind with the given key.
+// Entries are written atomically, so concurrent invocations of mink never
+// observe partial entries.
+func (c *Cache) Put(kind, key, value string) error {
+	dir := filepath.Join(c.dir, kind)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(value + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(kind, key))
+}
+
+// Delete removes the entry of the given kind with the given key, if any
+// (e.g. when its value turns out to be stale).
+func (c *Cache) Delete(kind, key string) error {
+	if err := os.Remove(c.path(kind, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	if Key("a", "b") != Key("a", "b") {
+		t.Error("Key() is not deterministic")
+	}
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("Key() lets adjacent parts run together")
+	}
+	if Key("a") == Key("a", "") {
+		t.Error("Key() ignores empty parts")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	c := New(dir)
+	key := Key("tree", "base")
+
+	if _, ok, err := c.Get(Bundles, key); err != nil || ok {
+		t.Fatalf("Get() = %v, %v, wanted a miss", ok, err)
+	}
+
+	if err := c.Put(Bundles, key, "ghcr.io/mattmoor/bundle@sha256:deadbeef"); err != nil {
+		t.Fatal("Put() =", err)
+	}
+	if got, ok, err := c.Get(Bundles, key); err != nil || !ok {
+		t.Fatalf("Get() = %v, %v, wanted a hit", ok, err)
+	} else if want := "ghcr.io/mattmoor/bundle@sha256:deadbeef"; got != want {
+		t.Errorf("Get() = %q, wanted %q", got, want)
+	}
+
+	// Kinds are separate.
+	if _, ok, err := c.Get(Builds, key); err != nil || ok {
+		t.Errorf("Get(Builds) = %v, %v, wanted a miss", ok, err)
+	}
+
+	// Nothing else is left behind.
+	if fis, err := ioutil.ReadDir(filepath.Join(dir, Bundles)); err != nil {
+		t.Fatal("ReadDir() =", err)
+	} else if len(fis) != 1 {
+		t.Errorf("ReadDir() = %d entries, wanted 1", len(fis))
+	}
+
+	if err := c.Delete(Bundles, key); err != nil {
+		t.Fatal("Delete() =", err)
+	}
+	if _, ok, err := c.Get(Bundles, key); err != nil || ok {
+		t.Errorf("Get() = %v, %v, wanted a miss after Delete()", ok, err)
+	}
+	if err := c.Delete(Bundles, key); err != nil {
+		t.Error("Delete() =", err)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/apko"
+	minkcli "github.com/mattmoor/mink/pkg/cli"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tektoncd/cli/pkg/cli"
+	"github.com/tektoncd/cli/pkg/options"
+)
+
+var apkoExample = fmt.Sprintf(`
+  # Build the image declared by base/apko.yaml in the current directory with
+  # apko, and publish it as the provided image name.
+  %[1]s apko base/apko.yaml --image ghcr.io/mattmoor/base:latest
+
+  # As the first, but only for amd64 and arm64.
+  %[1]s apko base/apko.yaml --apko-arch=amd64,arm64 --image ghcr.io/mattmoor/base:latest`, ExamplePrefix())
+
+func init() {
+	registerBuilder("apko", cachedBuiltin{
+		builtin: (*ResolveOptions).apko,
+		key: func(opts *ResolveOptions) (interface{}, interface{}) {
+			return opts.apkoOptions, apko.ApkoTask.Spec
+		},
+	}, NewApkoCommand)
+}
+
+// NewApkoCommand implements 'kn-im apko' command
+func NewApkoCommand(ctx context.Context) *cobra.Command {
+	opts := &ApkoOptions{
+		BaseBuildOptions: BaseBuildOptions{BundleOptions: BundleOptions{ctx: ctx}},
+	}
+
+	cmd := &cobra.Command{
+		Use:     "apko CONFIG --image IMAGE",
+		Short:   "Build an image from a declarative apko configuration.",
+		Example: apkoExample,
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+type apkoOptions struct {
+	// Archs holds the architectures for which apko builds.
+	Archs []string
+
+	// ApkoArgs holds extra arguments for apko publish.
+	ApkoArgs []string
+}
+
+// AddFlags implements Interface
+func (opts *apkoOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("apko-arch", nil,
+		"The architectures (e.g. amd64,arm64) for which apko builds, by default those of the configuration.")
+	cmd.Flags().StringArray("apko-args", nil, "Extra arguments to supply to apko publish.")
+}
+
+// Validate implements Interface
+func (opts *apkoOptions) Validate(cmd *cobra.Command, args []string) error {
+	if err := opts.setArchs(viper.GetStringSlice("apko-arch")); err != nil {
+		return minkcli.ErrInvalidValue("apko-arch", err.Error())
+	}
+	opts.ApkoArgs = viper.GetStringSlice("apko-args")
+	return nil
+}
+
+// setArchs sets the architectures for which apko builds.
+func (opts *apkoOptions) setArchs(archs []string) error {
+	opts.Archs = nil
+	for _, a := range archs {
+		if a == "" || strings.ContainsAny(a, " \t\n") {
+			return fmt.Errorf("invalid architecture %q", a)
+		}
+		opts.Archs = append(opts.Archs, a)
+	}
+	return nil
+}
+
+// withQuery returns a copy of the options, amended by the query of an
+// apko:/// reference (e.g. apko:///base/apko.yaml?apko-arch=amd64), whose
+// parameters are named after the flags they override.
+func (opts apkoOptions) withQuery(q url.Values) (apkoOptions, error) {
+	for k, vs := range q {
+		var err error
+		switch k {
+		case "apko-arch":
+			err = opts.setArchs(strings.Split(strings.Join(vs, ","), ","))
+		case "apko-args":
+			opts.ApkoArgs = append([]string(nil), vs...)
+		default:
+			err = errors.New("unsupported parameter")
+		}
+		if err != nil {
+			return apkoOptions{}, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return opts, nil
+}
+
+// ApkoOptions implements Interface for the `kn im apko` command.
+type ApkoOptions struct {
+	// Inherit all of the base build options.
+	BaseBuildOptions
+
+	apkoOptions
+}
+
+// ApkoOptions implements Interface
+var _ Interface = (*ApkoOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *ApkoOptions) AddFlags(cmd *cobra.Command) {
+	// Add the bundle flags to our surface.
+	opts.BaseBuildOptions.AddFlags(cmd)
+
+	opts.apkoOptions.AddFlags(cmd)
+}
+
+// Validate implements Interface
+func (opts *ApkoOptions) Validate(cmd *cobra.Command, args []string) error {
+	// Validate the bundle arguments.
+	if err := opts.BaseBuildOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+
+	return opts.apkoOptions.Validate(cmd, args)
+}
+
+// Execute implements Interface
+func (opts *ApkoOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("'im apko' takes exactly one configuration file")
+	}
+	p := path.Clean(args[0])
+	if path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("configuration %q must be a relative path within the bundle", args[0])
+	}
+
+	// Handle ctrl+C
+	ctx := opts.GetContext(cmd)
+
+	// Bundle up the source context in an image.
+	sourceDigest, err := opts.bundle(ctx)
+	if err != nil {
+		return err
+	}
+
+	digest, err := opts.build(ctx, sourceDigest, &url.URL{Scheme: "apko", Path: path.Join("/", p)}, cmd.OutOrStderr())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	return nil
+}
+
+func (opts *ApkoOptions) build(ctx context.Context, sourceDigest name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	tag, err := opts.tag(imageNameContext{
+		URL: *u,
+	})
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	// Create a Build definition for turning the source into an image with apko.
+	tr := apko.Build(ctx, sourceDigest, tag, apko.Options{
+		Config:   strings.TrimPrefix(u.Path, "/"),
+		Archs:    opts.Archs,
+		ApkoArgs: opts.ApkoArgs,
+	})
+	tr.Namespace = Namespace()
+
+	// Run the produced Build definition to completion, streaming logs to stdout, and
+	// returning the digest of the produced image.
+	return builds.Run(ctx, tag.String(), tr, &options.LogOptions{
+		ActivityTimeout: activityTimeout,
+		Params:          &cli.TektonParams{},
+		Stream: &cli.Stream{
+			// Send Out to stderr so we can capture the digest for composition.
+			Out: w,
+			Err: w,
+		},
+		Follow: true,
+	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest), builds.WithSourceProvenance(sourceDigest))
+}
+
+func (opts *ResolveOptions) apko(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	if u.Host != "" {
+		return name.Digest{}, fmt.Errorf(
+			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
+			u.Scheme, u.Host, u.Scheme, u.Scheme)
+	}
+	if p := path.Clean(u.Path); p == "/" || p == "." {
+		return name.Digest{}, fmt.Errorf("missing configuration in %q reference %s", u.Scheme, u)
+	}
+
+	// Create the equivalent `mink apko` invocation.
+	ao, err := opts.apkoOptions.withQuery(u.Query())
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("invalid query in %q reference %s: %w", u.Scheme, u, err)
+	}
+	apko := ApkoOptions{
+		BaseBuildOptions: opts.BaseBuildOptions,
+		apkoOptions:      ao,
+	}
+	p := *u
+	p.RawQuery = ""
+
+	// Run the produced Build definition to completion, streaming logs to w, and
+	// returning the digest of the produced image.
+	return apko.build(ctx, source, &p, w)
+}
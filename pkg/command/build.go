@@ -17,19 +17,29 @@ limitations under the License.
 package command
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/mattmoor/mink/pkg/bundles"
 	minkcli "github.com/mattmoor/mink/pkg/cli"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/pool"
 
 	"github.com/tektoncd/cli/pkg/cli"
 	"github.com/tektoncd/cli/pkg/options"
@@ -49,12 +59,30 @@ var dockerfileExample = fmt.Sprintf(`
   # As the first, but builds ./app/Dockerfile.production.
   %[1]s build --dockerfile=./app/Dockerfile.production --image ghcr.io/mattmoor/bundle:latest
 
+  # As the first, but builds the "prod" stage with a build-time variable and
+  # a label, without caching layers.
+  %[1]s build --target=prod --build-arg=VERSION=1.2.3 --label=team=web --no-cache --image ghcr.io/mattmoor/bundle:latest
+
+  # As the first, but builds for both amd64 and arm64 (on nodes of each), and
+  # publishes an image index of the results.
+  %[1]s build --platform=linux/amd64,linux/arm64 --image ghcr.io/mattmoor/bundle:latest
+
+  # As the first, but makes the key "npmrc" of the Secret "npm-creds" available
+  # to RUN --mount=type=secret,id=npmrc (at /run/secrets/npmrc).
+  %[1]s build --build-secret=npmrc=npm-creds --image ghcr.io/mattmoor/bundle:latest
+
   # As the first, but executes the build as a temporary ServiceAccount
   # that is configured with the user's local credentials.
   # WARNING: This temporarily places your registry credentials in a Secret
   # on your cluster, so use this option with caution in shared environments.
   %[1]s build --as=me --image ghcr.io/mattmoor/bundle:latest`, ExamplePrefix())
 
+// These exist for the purpose of TESTING
+var (
+	runBuild = builds.Run
+	combine  = bundles.Combine
+)
+
 // NewBuildCommand implements 'kn-im build' command
 func NewBuildCommand(ctx context.Context) *cobra.Command {
 	opts := &BuildOptions{
@@ -78,6 +106,28 @@ type dockerfileOptions struct {
 	// Dockerfile is the relative path to the Dockerfile within the build context.
 	Dockerfile string
 
+	// Target is the stage of the Dockerfile to build, by default the last.
+	Target string
+
+	// BuildArgs holds the build-time variables, each of the form KEY=VALUE.
+	BuildArgs []string
+
+	// Labels holds the labels to add to the image, each of the form KEY=VALUE.
+	Labels []string
+
+	// CacheRepo is the repository in which to cache layers.
+	CacheRepo string
+
+	// NoCache disables the caching of layers.
+	NoCache bool
+
+	// Secrets are mounted for use by RUN --mount=type=secret.
+	Secrets []dockerfile.Secret
+
+	// Platforms holds the platforms for which to build, each on a node of
+	// that platform, and whose results are combined into an image index.
+	Platforms []v1.Platform
+
 	// The extra kaniko arguments for handling things like insecure registries
 	KanikoArgs []string
 }
@@ -85,6 +135,20 @@ type dockerfileOptions struct {
 // AddFlags implements Interface
 func (opts *dockerfileOptions) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().String("dockerfile", "Dockerfile", "The path to the Dockerfile within the build context.")
+	cmd.Flags().String("target", "", "The stage of the Dockerfile to build, by default the last.")
+	cmd.Flags().StringArray("build-arg", nil,
+		"Build-time variables of the form KEY=VALUE, or KEY to take the value from the environment.")
+	cmd.Flags().StringArray("label", nil, "Labels of the form KEY=VALUE to add to the image.")
+	cmd.Flags().String("cache-repo", "",
+		"The repository in which to cache the layers of Dockerfile builds, by default the repository of the image.")
+	cmd.Flags().Bool("no-cache", false, "Don't cache the layers of Dockerfile builds.")
+	cmd.Flags().StringArray("build-secret", nil,
+		"Secrets for RUN --mount=type=secret,id=ID of the form ID=NAME[:KEY], where NAME is a Kubernetes Secret "+
+			"in the namespace of the build, and KEY (which defaults to ID) is the key within it that holds the secret.")
+	cmd.Flags().StringSlice("platform", nil,
+		"The platforms (e.g. linux/amd64,linux/arm64) for which to run Dockerfile builds, each on a node of "+
+			"that platform, and whose results are combined into an image index.  By default a single "+
+			"image is built on whichever node the build is scheduled.")
 	cmd.Flags().StringSlice("kaniko-args", nil, "Optional arguments to pass to kaniko for dealing with insecure registries. For details see: https://github.com/GoogleContainerTools/kaniko/blob/master/README.md#additional-flags")
 }
 
@@ -95,10 +159,158 @@ func (opts *dockerfileOptions) Validate(cmd *cobra.Command, args []string) error
 		return minkcli.ErrMissingFlag("dockerfile")
 	}
 
+	opts.Target = viper.GetString("target")
+	opts.BuildArgs = nil
+	if err := opts.addBuildArgs(viper.GetStringSlice("build-arg")); err != nil {
+		return minkcli.ErrInvalidValue("build-arg", err.Error())
+	}
+	opts.Labels = nil
+	if err := opts.addLabels(viper.GetStringSlice("label")); err != nil {
+		return minkcli.ErrInvalidValue("label", err.Error())
+	}
+	opts.CacheRepo = viper.GetString("cache-repo")
+	if opts.CacheRepo != "" {
+		if _, err := name.NewRepository(opts.CacheRepo); err != nil {
+			return minkcli.ErrInvalidValue("cache-repo", err.Error())
+		}
+	}
+	opts.NoCache = viper.GetBool("no-cache")
+	opts.Secrets = nil
+	if err := opts.addSecrets(viper.GetStringSlice("build-secret")); err != nil {
+		return minkcli.ErrInvalidValue("build-secret", err.Error())
+	}
+	if ps, err := parsePlatforms(viper.GetStringSlice("platform")); err != nil {
+		return minkcli.ErrInvalidValue("platform", err.Error())
+	} else {
+		opts.Platforms = ps
+	}
+
 	opts.KanikoArgs = viper.GetStringSlice("kaniko-args")
 	return nil
 }
 
+// validatePlatforms checks that the bundle holds an image for each of the
+// platforms for which we build, since each build expands the bundle on a
+// node of its platform.
+func (opts *dockerfileOptions) validatePlatforms(bundled []v1.Platform) error {
+	if len(bundled) == 0 {
+		// The bundle holds every platform of its base.
+		return nil
+	}
+	for _, want := range opts.Platforms {
+		found := false
+		for _, got := range bundled {
+			if bundles.PlatformMatches(want, got) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return minkcli.ErrInvalidValue("platform", "%s is not among the --bundle-platforms %v", want.String(), bundled)
+		}
+	}
+	return nil
+}
+
+// parsePlatforms parses the given platforms (e.g. linux/arm64), rejecting
+// duplicates.
+func parsePlatforms(ss []string) ([]v1.Platform, error) {
+	seen := sets.NewString()
+	ps := make([]v1.Platform, 0, len(ss))
+	for _, s := range ss {
+		p, err := v1.ParsePlatform(s)
+		if err != nil {
+			return nil, err
+		}
+		if seen.Has(p.String()) {
+			return nil, fmt.Errorf("saw the platform %s more than once", p)
+		}
+		seen.Insert(p.String())
+		ps = append(ps, *p)
+	}
+	return ps, nil
+}
+
+// addBuildArgs adds the given build-time variables, taking the values of
+// those without one from the environment (like docker build does).
+func (opts *dockerfileOptions) addBuildArgs(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "=") {
+			return fmt.Errorf("%q is not of the form KEY[=VALUE]", arg)
+		}
+		if !strings.Contains(arg, "=") {
+			v, ok := os.LookupEnv(arg)
+			if !ok {
+				continue
+			}
+			arg += "=" + v
+		}
+		opts.BuildArgs = append(opts.BuildArgs, arg)
+	}
+	return nil
+}
+
+// addLabels adds the given labels.
+func (opts *dockerfileOptions) addLabels(labels []string) error {
+	for _, l := range labels {
+		if i := strings.Index(l, "="); i <= 0 {
+			return fmt.Errorf("%q is not of the form KEY=VALUE", l)
+		}
+		opts.Labels = append(opts.Labels, l)
+	}
+	return nil
+}
+
+// addSecrets adds the given build secrets.
+func (opts *dockerfileOptions) addSecrets(secrets []string) error {
+	for _, s := range secrets {
+		secret, err := dockerfile.ParseSecret(s)
+		if err != nil {
+			return err
+		}
+		opts.Secrets = append(opts.Secrets, secret)
+	}
+	return nil
+}
+
+// withQuery returns a copy of the options, amended by the query of a
+// dockerfile:/// reference (e.g. dockerfile:///app?target=prod&build-arg=VERSION=1.2.3),
+// whose parameters are named after the flags they amend.  Build arguments,
+// labels and secrets add to those of the flags, and the others override them.
+func (opts dockerfileOptions) withQuery(q url.Values) (dockerfileOptions, error) {
+	// Copy the slices, since the options are shared by concurrent builds.
+	opts.BuildArgs = append([]string(nil), opts.BuildArgs...)
+	opts.Labels = append([]string(nil), opts.Labels...)
+	opts.Secrets = append([]dockerfile.Secret(nil), opts.Secrets...)
+
+	for k, vs := range q {
+		var err error
+		switch k {
+		case "target":
+			opts.Target = vs[len(vs)-1]
+		case "build-arg":
+			err = opts.addBuildArgs(vs)
+		case "label":
+			err = opts.addLabels(vs)
+		case "build-secret":
+			err = opts.addSecrets(vs)
+		case "cache-repo":
+			opts.CacheRepo = vs[len(vs)-1]
+			_, err = name.NewRepository(opts.CacheRepo)
+		case "no-cache":
+			opts.NoCache, err = strconv.ParseBool(vs[len(vs)-1])
+		case "platform":
+			opts.Platforms, err = parsePlatforms(strings.Split(strings.Join(vs, ","), ","))
+		default:
+			err = errors.New("unsupported parameter")
+		}
+		if err != nil {
+			return dockerfileOptions{}, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return opts, nil
+}
+
 // BuildOptions implements Interface for the `kn im build` command.
 type BuildOptions struct {
 	// Inherit all of the base build options.
@@ -106,6 +318,8 @@ type BuildOptions struct {
 
 	// Inherit the dockerfile options.
 	dockerfileOptions
+
+	buildCacheOptions
 }
 
 // BuildOptions implements Interface
@@ -117,6 +331,7 @@ func (opts *BuildOptions) AddFlags(cmd *cobra.Command) {
 	opts.BaseBuildOptions.AddFlags(cmd)
 
 	opts.dockerfileOptions.AddFlags(cmd)
+	opts.buildCacheOptions.AddFlags(cmd)
 }
 
 // Validate implements Interface
@@ -125,7 +340,13 @@ func (opts *BuildOptions) Validate(cmd *cobra.Command, args []string) error {
 	if err := opts.BaseBuildOptions.Validate(cmd, args); err != nil {
 		return err
 	}
-	return opts.dockerfileOptions.Validate(cmd, args)
+	if err := opts.dockerfileOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.buildCacheOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	return opts.validatePlatforms(opts.BundleOptions.Platforms)
 }
 
 // Execute implements Interface
@@ -164,17 +385,74 @@ func (opts *BuildOptions) build(ctx context.Context, sourceDigest name.Digest, w
 	if err != nil {
 		return name.Digest{}, err
 	}
+	if len(opts.Platforms) == 0 {
+		return opts.buildPlatform(ctx, sourceDigest, tag, nil, w)
+	}
+
+	// Run a build for each platform (concurrently, unless they share a
+	// cache), and combine the results into an image index.  Each build
+	// publishes to its own tag, so that the tag only ever refers to the
+	// image index.
+	var (
+		m       sync.Mutex
+		digests = make(map[string]name.Digest, len(opts.Platforms))
+	)
+	parallelism := opts.buildCacheOptions.parallelism(len(opts.Platforms))
+	errg, pctx := pool.NewWithContext(ctx, parallelism, len(opts.Platforms))
+	for _, p := range opts.Platforms {
+		p := p
+		errg.Go(func() error {
+			// Buffer the output, so that the logs of the builds aren't interleaved.
+			buf := &bytes.Buffer{}
+			digest, err := opts.buildPlatform(pctx, sourceDigest, platformTag(tag, p), &p, buf)
+
+			m.Lock()
+			defer m.Unlock()
+			fmt.Fprintf(w, "Build for %s:\n%s", p.String(), buf.String())
+			if err != nil {
+				return fmt.Errorf("build for %s failed: %w", p.String(), err)
+			}
+			digests[p.String()] = digest
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return name.Digest{}, err
+	}
+	return combine(ctx, tag, digests)
+}
+
+// invalidTagChars matches the characters that may not appear in tags.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// platformTag returns the tag to which the image for the platform is
+// published before it is combined into the image index at tag, e.g.
+// ghcr.io/mattmoor/app:latest-linux-arm64 for ghcr.io/mattmoor/app:latest.
+func platformTag(tag name.Tag, p v1.Platform) name.Tag {
+	return tag.Context().Tag(tag.TagStr() + "-" + invalidTagChars.ReplaceAllString(p.String(), "-"))
+}
 
+// buildPlatform runs a single build, on a node of the given platform when
+// it is set, and returns the digest of the resulting image.
+func (opts *BuildOptions) buildPlatform(ctx context.Context, sourceDigest name.Digest, tag name.Tag, platform *v1.Platform, w io.Writer) (name.Digest, error) {
 	// Create a Build definition for turning the source into an image by Dockerfile build.
 	tr := dockerfile.Build(ctx, sourceDigest, tag, dockerfile.Options{
 		Dockerfile: opts.Dockerfile,
+		Target:     opts.Target,
+		BuildArgs:  opts.BuildArgs,
+		Labels:     opts.Labels,
+		CacheRepo:  opts.CacheRepo,
+		NoCache:    opts.NoCache,
+		Secrets:    opts.Secrets,
+		Platform:   platform,
 		KanikoArgs: opts.KanikoArgs,
+		CacheClaim: opts.CacheClaim,
 	})
 	tr.Namespace = Namespace()
 
 	// Run the produced Build definition to completion, streaming logs to stdout, and
 	// returning the digest of the produced image.
-	return builds.Run(ctx, tag.String(), tr, &options.LogOptions{
+	return runBuild(ctx, tag.String(), tr, &options.LogOptions{
 		ActivityTimeout: activityTimeout,
 		Params:          &cli.TektonParams{},
 		Stream: &cli.Stream{
@@ -183,5 +461,5 @@ func (opts *BuildOptions) build(ctx context.Context, sourceDigest name.Digest, w
 			Err: w,
 		},
 		Follow: true,
-	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest))
+	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest), builds.WithSourceProvenance(sourceDigest), builds.WithCacheClaim(ctx))
 }
@@ -0,0 +1,471 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/tektoncd/cli/pkg/options"
+	tknv1beta1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+)
+
+func TestAddBuildArgs(t *testing.T) {
+	t.Setenv("MINK_TEST_FROM_ENV", "from-env")
+	t.Setenv("MINK_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{{
+		name: "explicit values",
+		args: []string{"VERSION=1.2.3", "EQUATION=a=b"},
+		want: []string{"VERSION=1.2.3", "EQUATION=a=b"},
+	}, {
+		name: "empty value",
+		args: []string{"VERSION="},
+		want: []string{"VERSION="},
+	}, {
+		name: "value from the environment",
+		args: []string{"MINK_TEST_FROM_ENV", "MINK_TEST_EMPTY"},
+		want: []string{"MINK_TEST_FROM_ENV=from-env", "MINK_TEST_EMPTY="},
+	}, {
+		name: "unset in the environment",
+		args: []string{"MINK_TEST_UNSET", "VERSION=1.2.3"},
+		want: []string{"VERSION=1.2.3"},
+	}, {
+		name:    "missing key",
+		args:    []string{"=1.2.3"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &dockerfileOptions{}
+			err := opts.addBuildArgs(test.args)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("addBuildArgs() = nil, wanted error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("addBuildArgs() =", err)
+			}
+			if diff := cmp.Diff(test.want, opts.BuildArgs); diff != "" {
+				t.Errorf("BuildArgs (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	base := dockerfileOptions{
+		Dockerfile: "Dockerfile",
+		Target:     "dev",
+		BuildArgs:  []string{"VERSION=1.2.3"},
+		Labels:     []string{"team=web"},
+		Secrets:    []dockerfile.Secret{{ID: "npmrc", Name: "npm-creds", Key: "npmrc"}},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    dockerfileOptions
+		wantErr string
+	}{{
+		name:  "no query",
+		query: "",
+		want:  base,
+	}, {
+		name:  "target overrides",
+		query: "target=base&target=prod",
+		want: dockerfileOptions{
+			Dockerfile: "Dockerfile",
+			Target:     "prod",
+			BuildArgs:  []string{"VERSION=1.2.3"},
+			Labels:     []string{"team=web"},
+			Secrets:    []dockerfile.Secret{{ID: "npmrc", Name: "npm-creds", Key: "npmrc"}},
+		},
+	}, {
+		name:  "build args, labels and secrets add",
+		query: "build-arg=COMMIT=abc&label=tier=frontend&build-secret=token=gh-creds:GITHUB_TOKEN",
+		want: dockerfileOptions{
+			Dockerfile: "Dockerfile",
+			Target:     "dev",
+			BuildArgs:  []string{"VERSION=1.2.3", "COMMIT=abc"},
+			Labels:     []string{"team=web", "tier=frontend"},
+			Secrets: []dockerfile.Secret{
+				{ID: "npmrc", Name: "npm-creds", Key: "npmrc"},
+				{ID: "token", Name: "gh-creds", Key: "GITHUB_TOKEN"},
+			},
+		},
+	}, {
+		name:  "cache settings",
+		query: "cache-repo=ghcr.io/mattmoor/cache&no-cache=true",
+		want: dockerfileOptions{
+			Dockerfile: "Dockerfile",
+			Target:     "dev",
+			BuildArgs:  []string{"VERSION=1.2.3"},
+			Labels:     []string{"team=web"},
+			CacheRepo:  "ghcr.io/mattmoor/cache",
+			NoCache:    true,
+			Secrets:    []dockerfile.Secret{{ID: "npmrc", Name: "npm-creds", Key: "npmrc"}},
+		},
+	}, {
+		name:    "unsupported parameter",
+		query:   "dockerfile=Other",
+		wantErr: "dockerfile: unsupported parameter",
+	}, {
+		name:    "bad build arg",
+		query:   "build-arg==1.2.3",
+		wantErr: "build-arg:",
+	}, {
+		name:    "bad label",
+		query:   "label=team",
+		wantErr: "label:",
+	}, {
+		name:    "bad secret",
+		query:   "build-secret=npmrc",
+		wantErr: "build-secret:",
+	}, {
+		name:    "bad cache repo",
+		query:   "cache-repo=UPPER/case",
+		wantErr: "cache-repo:",
+	}, {
+		name:    "bad no-cache",
+		query:   "no-cache=maybe",
+		wantErr: "no-cache:",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal("url.ParseQuery() =", err)
+			}
+			got, err := base.withQuery(q)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("withQuery() = %v, wanted error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("withQuery() =", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(dockerfileOptions{})); diff != "" {
+				t.Errorf("withQuery (-want, +got): %s", diff)
+			}
+		})
+	}
+
+	// The options of the flags are shared by concurrent builds, so they
+	// must not be modified, even when their slices have room to grow.
+	shared := base
+	shared.BuildArgs = append(make([]string, 0, 4), base.BuildArgs...)
+	first, err := shared.withQuery(url.Values{"build-arg": {"COMMIT=abc"}})
+	if err != nil {
+		t.Fatal("withQuery() =", err)
+	}
+	if _, err := shared.withQuery(url.Values{"build-arg": {"COMMIT=def"}}); err != nil {
+		t.Fatal("withQuery() =", err)
+	}
+	if diff := cmp.Diff([]string{"VERSION=1.2.3", "COMMIT=abc"}, first.BuildArgs); diff != "" {
+		t.Errorf("BuildArgs (-want, +got): %s", diff)
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []string
+		want    []v1.Platform
+		wantErr bool
+	}{{
+		name:  "none",
+		input: nil,
+		want:  []v1.Platform{},
+	}, {
+		name:  "several",
+		input: []string{"linux/amd64", "linux/arm64/v8", "linux/arm/v7"},
+		want: []v1.Platform{
+			{OS: "linux", Architecture: "amd64"},
+			{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}, {
+		name:  "variants are distinct",
+		input: []string{"linux/arm/v6", "linux/arm/v7"},
+		want: []v1.Platform{
+			{OS: "linux", Architecture: "arm", Variant: "v6"},
+			{OS: "linux", Architecture: "arm", Variant: "v7"},
+		},
+	}, {
+		name:    "duplicate",
+		input:   []string{"linux/amd64", "linux/arm64", "linux/amd64"},
+		wantErr: true,
+	}, {
+		name:    "malformed",
+		input:   []string{"linux/arm/v7/extra"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePlatforms(test.input)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parsePlatforms() = %v, wanted error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("parsePlatforms() =", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("parsePlatforms (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestPlatformTag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		platform v1.Platform
+		want     string
+	}{{
+		tag:      "ghcr.io/mattmoor/image:latest",
+		platform: v1.Platform{OS: "linux", Architecture: "amd64"},
+		want:     "ghcr.io/mattmoor/image:latest-linux-amd64",
+	}, {
+		tag:      "ghcr.io/mattmoor/image:v1.2",
+		platform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		want:     "ghcr.io/mattmoor/image:v1.2-linux-arm-v7",
+	}, {
+		tag:      "localhost:5000/image:dev",
+		platform: v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1879"},
+		want:     "localhost:5000/image:dev-windows-amd64-10.0.17763.1879",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			tag, err := name.NewTag(test.tag)
+			if err != nil {
+				t.Fatal("name.NewTag() =", err)
+			}
+			if got := platformTag(tag, test.platform); got.String() != test.want {
+				t.Errorf("platformTag() = %s, wanted %s", got, test.want)
+			}
+			if _, err := name.NewTag(test.want); err != nil {
+				t.Errorf("name.NewTag(%s) = %v", test.want, err)
+			}
+		})
+	}
+}
+
+// fakeBuilds replaces runBuild and combine for the duration of the test,
+// recording the TaskRuns that would have run, and the digests combined and
+// the tag to which they were combined.
+type fakeBuilds struct {
+	m        sync.Mutex
+	runs     []*tknv1beta1.TaskRun
+	fail     string
+	combined map[string]name.Digest
+	tag      string
+}
+
+func newFakeBuilds(t *testing.T) *fakeBuilds {
+	fb := &fakeBuilds{}
+	oldRun, oldCombine := runBuild, combine
+	t.Cleanup(func() {
+		runBuild, combine = oldRun, oldCombine
+	})
+
+	runBuild = func(_ context.Context, image string, tr *tknv1beta1.TaskRun, opt *options.LogOptions, _ ...builds.CancelableTaskOption) (name.Digest, error) {
+		fb.m.Lock()
+		defer fb.m.Unlock()
+		fb.runs = append(fb.runs, tr)
+
+		arch := tr.Spec.PodTemplate.NodeSelector[corev1.LabelArchStable]
+		opt.Stream.Out.Write([]byte("building " + arch + "\n"))
+		if arch != "" && arch == fb.fail {
+			return name.Digest{}, errors.New("kaniko failed")
+		}
+		return name.NewDigest(image + "@" + fakeDigest(arch))
+	}
+	combine = func(_ context.Context, tag name.Tag, digests map[string]name.Digest) (name.Digest, error) {
+		fb.combined = digests
+		fb.tag = tag.Context().String() + ":" + tag.TagStr()
+		return name.NewDigest(tag.Context().String() + "@" + fakeDigest("index"))
+	}
+	return fb
+}
+
+// fakeDigest returns a digest that is distinct for each string.
+func fakeDigest(s string) string {
+	return "sha256:" + strings.Repeat("0", 64-len(s)) + strings.Map(func(r rune) rune {
+		return '0' + r%10
+	}, s)
+}
+
+// buildContext returns a context with a kubernetes client, which the options
+// of the builds require, but which the fake builds never use.
+func buildContext() context.Context {
+	return context.WithValue(context.Background(), kubeclient.Key{},
+		kubernetes.NewForConfigOrDie(&rest.Config{Host: "http://localhost"}))
+}
+
+func newBuildOptions(platforms ...v1.Platform) *BuildOptions {
+	return &BuildOptions{
+		BaseBuildOptions: BaseBuildOptions{
+			tmpl: template.Must(template.New("image").Parse("ghcr.io/mattmoor/image")),
+		},
+		dockerfileOptions: dockerfileOptions{
+			Dockerfile: "Dockerfile",
+			Platforms:  platforms,
+		},
+	}
+}
+
+func TestBuildSinglePlatform(t *testing.T) {
+	fb := newFakeBuilds(t)
+	source, _ := name.NewDigest("ghcr.io/mattmoor/source@" + fakeDigest("source"))
+
+	got, err := newBuildOptions().build(buildContext(), source, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal("build() =", err)
+	}
+	if want := "ghcr.io/mattmoor/image@" + fakeDigest(""); got.String() != want {
+		t.Errorf("build() = %s, wanted %s", got, want)
+	}
+	if len(fb.runs) != 1 {
+		t.Fatalf("ran %d builds, wanted 1", len(fb.runs))
+	}
+	if got := fb.runs[0].Spec.PodTemplate.NodeSelector; got != nil {
+		t.Errorf("NodeSelector = %v, wanted none", got)
+	}
+	if fb.combined != nil {
+		t.Errorf("combined %v, wanted a single image", fb.combined)
+	}
+}
+
+func TestBuildMultiPlatform(t *testing.T) {
+	fb := newFakeBuilds(t)
+	source, _ := name.NewDigest("ghcr.io/mattmoor/source@" + fakeDigest("source"))
+	opts := newBuildOptions(
+		v1.Platform{OS: "linux", Architecture: "amd64"},
+		v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	)
+
+	buf := &bytes.Buffer{}
+	got, err := opts.build(buildContext(), source, buf)
+	if err != nil {
+		t.Fatal("build() =", err)
+	}
+	if want := "ghcr.io/mattmoor/image@" + fakeDigest("index"); got.String() != want {
+		t.Errorf("build() = %s, wanted %s", got, want)
+	}
+
+	// Each platform is built on a node of that platform.
+	var selectors []string
+	for _, tr := range fb.runs {
+		ns := tr.Spec.PodTemplate.NodeSelector
+		selectors = append(selectors, ns[corev1.LabelOSStable]+"/"+ns[corev1.LabelArchStable])
+	}
+	sort.Strings(selectors)
+	if diff := cmp.Diff([]string{"linux/amd64", "linux/arm64"}, selectors); diff != "" {
+		t.Errorf("NodeSelectors (-want, +got): %s", diff)
+	}
+
+	// Each platform is published to its own tag, leaving the tag to the
+	// image index.
+	var targets []string
+	for _, tr := range fb.runs {
+		for _, p := range tr.Spec.Params {
+			if p.Name == "dev.mink.images.target" {
+				targets = append(targets, p.Value.StringVal)
+			}
+		}
+	}
+	sort.Strings(targets)
+	if diff := cmp.Diff([]string{
+		"ghcr.io/mattmoor/image:latest-linux-amd64",
+		"ghcr.io/mattmoor/image:latest-linux-arm64-v8",
+	}, targets); diff != "" {
+		t.Errorf("targets (-want, +got): %s", diff)
+	}
+	if want := "ghcr.io/mattmoor/image:latest"; fb.tag != want {
+		t.Errorf("combined to %s, wanted %s", fb.tag, want)
+	}
+
+	// The results are combined by platform.
+	want := map[string]name.Digest{}
+	for k, tag := range map[string]string{"linux/amd64": "latest-linux-amd64", "linux/arm64/v8": "latest-linux-arm64-v8"} {
+		arch := strings.Split(k, "/")[1]
+		want[k], _ = name.NewDigest("ghcr.io/mattmoor/image:" + tag + "@" + fakeDigest(arch))
+	}
+	if diff := cmp.Diff(want, fb.combined, cmp.Comparer(func(a, b name.Digest) bool {
+		return a.String() == b.String()
+	})); diff != "" {
+		t.Errorf("combine (-want, +got): %s", diff)
+	}
+
+	// The logs of each build are kept together.
+	for platform, arch := range map[string]string{"linux/amd64": "amd64", "linux/arm64/v8": "arm64"} {
+		if want := "Build for " + platform + ":\nbuilding " + arch + "\n"; !strings.Contains(buf.String(), want) {
+			t.Errorf("logs = %q, wanted the logs of %s together", buf.String(), platform)
+		}
+	}
+}
+
+func TestBuildMultiPlatformFailure(t *testing.T) {
+	fb := newFakeBuilds(t)
+	fb.fail = "arm64"
+	source, _ := name.NewDigest("ghcr.io/mattmoor/source@" + fakeDigest("source"))
+	opts := newBuildOptions(
+		v1.Platform{OS: "linux", Architecture: "amd64"},
+		v1.Platform{OS: "linux", Architecture: "arm64"},
+	)
+
+	_, err := opts.build(buildContext(), source, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "build for linux/arm64 failed") {
+		t.Fatalf("build() = %v, wanted the failure of linux/arm64", err)
+	}
+	if fb.combined != nil {
+		t.Errorf("combined %v, wanted nothing after a failure", fb.combined)
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"text/template"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/cache"
 	minkcli "github.com/mattmoor/mink/pkg/cli"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -40,6 +42,10 @@ type BaseBuildOptions struct {
 	// ServiceAccount is the name of the service account *as* which to run the build.
 	ServiceAccount string
 
+	// cache is where the results of bundles and builds are recorded, so that
+	// they may be reused when their inputs are unchanged (see --local-cache).
+	cache *cache.Cache
+
 	// tmpl is the template used to instantiate image names.
 	tmpl *template.Template
 }
@@ -59,6 +65,9 @@ func (opts *BaseBuildOptions) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().String("as", "default",
 		"The name of the ServiceAccount as which to run the build, pass --as=me to "+
 			"temporarily create a new ServiceAccount to push with your local credentials.")
+	cmd.Flags().Bool("local-cache", false,
+		"Record the bundles and images produced under the user's cache directory (e.g. ~/.cache/mink), "+
+			"and reuse them instead of publishing or building again when nothing has changed.")
 }
 
 // Validate implements Interface
@@ -67,6 +76,10 @@ func (opts *BaseBuildOptions) Validate(cmd *cobra.Command, args []string) error
 	if err := opts.BundleOptions.Validate(cmd, args); err != nil {
 		return err
 	}
+	// Builds run on the cluster, so they need the bundle in a registry.
+	if _, ok := opts.target.(*bundles.RegistryTarget); !ok {
+		return minkcli.ErrInvalidValue("bundle", "must be an image tag when building, but got: %s", opts.BundleOptions.ImageName)
+	}
 
 	opts.ImageName = viper.GetString("image")
 	if opts.ImageName == "" {
@@ -90,6 +103,15 @@ func (opts *BaseBuildOptions) Validate(cmd *cobra.Command, args []string) error
 		return minkcli.ErrMissingFlag("as")
 	}
 
+	opts.cache = nil
+	if viper.GetBool("local-cache") {
+		c, err := cache.Default()
+		if err != nil {
+			return minkcli.ErrInvalidValue("local-cache", err.Error())
+		}
+		opts.cache = c
+	}
+
 	return nil
 }
 
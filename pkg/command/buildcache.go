@@ -0,0 +1,70 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"strings"
+
+	"github.com/mattmoor/mink/pkg/builds"
+	minkcli "github.com/mattmoor/mink/pkg/cli"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type buildCacheOptions struct {
+	// CacheClaim is the name of the PersistentVolumeClaim in which builds
+	// persist their caches, or empty when they start from scratch.
+	CacheClaim string
+}
+
+// AddFlags implements Interface
+func (opts *buildCacheOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cache", "",
+		"Persist the caches of builds (e.g. Go modules, image layers) across builds in a "+
+			"PersistentVolumeClaim, which is created on first use: pvc:NAME for the named claim, "+
+			"or auto for a claim named "+builds.AutoCacheClaim+".")
+}
+
+// Validate implements Interface
+func (opts *buildCacheOptions) Validate(cmd *cobra.Command, args []string) error {
+	switch cache := viper.GetString("cache"); {
+	case cache == "":
+		opts.CacheClaim = ""
+	case cache == "auto":
+		opts.CacheClaim = builds.AutoCacheClaim
+	case strings.HasPrefix(cache, "pvc:"):
+		opts.CacheClaim = strings.TrimPrefix(cache, "pvc:")
+		if errs := validation.IsDNS1123Subdomain(opts.CacheClaim); len(errs) > 0 {
+			return minkcli.ErrInvalidValue("cache", "invalid claim name %q: %s", opts.CacheClaim, strings.Join(errs, ", "))
+		}
+	default:
+		return minkcli.ErrInvalidValue("cache", "must be pvc:NAME or auto, got: %s", cache)
+	}
+	return nil
+}
+
+// parallelism returns how many of n builds may run at once.  The claim is
+// ReadWriteOnce, so builds that share it are run one at a time, since those
+// scheduled on other nodes (e.g. of other platforms) can't mount it until
+// the builds using it finish.
+func (opts *buildCacheOptions) parallelism(n int) int {
+	if opts.CacheClaim != "" {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import "testing"
+
+func TestBuildCacheParallelism(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		n     int
+		want  int
+	}{{
+		name: "no cache",
+		n:    20,
+		want: 20,
+	}, {
+		name:  "cache",
+		claim: "mink-build-cache",
+		n:     20,
+		want:  1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := &buildCacheOptions{CacheClaim: test.claim}
+			if got := opts.parallelism(test.n); got != test.want {
+				t.Errorf("parallelism(%d) = %d, wanted %d", test.n, got, test.want)
+			}
+		})
+	}
+}
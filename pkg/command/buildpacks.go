@@ -17,12 +17,15 @@ limitations under the License.
 package command
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/mattmoor/mink/pkg/builds"
@@ -48,6 +51,14 @@ var buildpacksExample = fmt.Sprintf(`
   # As the first, but uses a different builder image.
   %[1]s buildpack --builder=cloudfoundry/cnb:bionic --image ghcr.io/mattmoor/bundle:latest
 
+  # As the first, but runs the listed buildpacks (in order) with the given
+  # build-time environment, and sets the default process of the image.
+  %[1]s buildpack --buildpack=paketo-buildpacks/go --env=BP_GO_TARGETS=./cmd/server --default-process=web --image ghcr.io/mattmoor/bundle:latest
+
+  # As the first, but persists the build cache in a registry image, so that
+  # subsequent builds are warm.
+  %[1]s buildpack --cache-image=ghcr.io/mattmoor/bundle:cache --image ghcr.io/mattmoor/bundle:latest
+
   # As the first, but executes the build as a temporary ServiceAccount
   # that is configured with the user's local credentials.
   # WARNING: This temporarily places your registry credentials in a Secret
@@ -79,6 +90,22 @@ type buildpackOptions struct {
 
 	// DescriptorFile holds the name of the project descriptor file (aka project.toml).
 	DescriptorFile string
+
+	// Buildpacks holds the buildpacks (as ID[@VERSION]) to run in place of
+	// the builder's default order.
+	Buildpacks []string
+
+	// Env holds the build-time environment variables (as NAME=VALUE).
+	Env []string
+
+	// RunImage overrides the run image of the builder.
+	RunImage string
+
+	// CacheImage is the image in which to persist the build cache.
+	CacheImage string
+
+	// DefaultProcess is the default process type of the resulting image.
+	DefaultProcess string
 }
 
 // AddFlags implements Interface
@@ -88,6 +115,81 @@ func (opts *buildpackOptions) AddFlags(cmd *cobra.Command) {
 
 	cmd.Flags().String("descriptor", "project.toml",
 		"The file from which to read the project descriptor (aka project.toml).")
+
+	cmd.Flags().StringSlice("buildpack", nil,
+		"The buildpacks (as ID[@VERSION]) to run in order, in place of the builder's default order.")
+
+	cmd.Flags().StringArray("env", nil,
+		"Build-time environment variables as NAME=VALUE, or NAME to take the value from the local environment.")
+
+	cmd.Flags().StringSlice("env-file", nil,
+		"Files from which to read build-time environment variables, one NAME=VALUE per line.")
+
+	cmd.Flags().String("run-image", "",
+		"The run image on which to base the resulting image (defaults to that of the builder).")
+
+	cmd.Flags().String("cache-image", "",
+		"An image in which to persist the build cache between builds.")
+
+	cmd.Flags().String("default-process", "",
+		"The default process type of the resulting image.")
+}
+
+// addBuildpacks adds the given buildpacks.
+func (opts *buildpackOptions) addBuildpacks(bps []string) error {
+	for _, bp := range bps {
+		if strings.HasPrefix(bp, "@") || strings.HasSuffix(bp, "@") || strings.ContainsAny(bp, " \t\n") {
+			return fmt.Errorf("%q is not of the form ID[@VERSION]", bp)
+		}
+		opts.Buildpacks = append(opts.Buildpacks, bp)
+	}
+	return nil
+}
+
+// addEnv adds the given environment variables, taking the values of bare
+// names from the local environment.
+func (opts *buildpackOptions) addEnv(env []string) error {
+	for _, e := range env {
+		if strings.HasPrefix(e, "=") {
+			return fmt.Errorf("%q is not of the form NAME[=VALUE]", e)
+		}
+		if !strings.Contains(e, "=") {
+			v, ok := os.LookupEnv(e)
+			if !ok {
+				continue
+			}
+			e += "=" + v
+		}
+		opts.Env = append(opts.Env, e)
+	}
+	return nil
+}
+
+// addEnvFile adds the environment variables in the given file, ignoring
+// blank lines and comments.
+func (opts *buildpackOptions) addEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var env []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env = append(env, line)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if err := opts.addEnv(env); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
 }
 
 // Validate implements Interface
@@ -101,6 +203,39 @@ func (opts *buildpackOptions) Validate(cmd *cobra.Command, args []string) error
 	if opts.DescriptorFile == "" {
 		return minkcli.ErrMissingFlag("descriptor")
 	}
+
+	opts.Buildpacks = nil
+	if err := opts.addBuildpacks(viper.GetStringSlice("buildpack")); err != nil {
+		return minkcli.ErrInvalidValue("buildpack", err.Error())
+	}
+
+	// Variables from the --env flags follow those from the files, so that
+	// they take precedence.
+	opts.Env = nil
+	for _, path := range viper.GetStringSlice("env-file") {
+		if err := opts.addEnvFile(path); err != nil {
+			return minkcli.ErrInvalidValue("env-file", err.Error())
+		}
+	}
+	if err := opts.addEnv(viper.GetStringSlice("env")); err != nil {
+		return minkcli.ErrInvalidValue("env", err.Error())
+	}
+
+	opts.RunImage = viper.GetString("run-image")
+	if opts.RunImage != "" {
+		if _, err := name.ParseReference(opts.RunImage); err != nil {
+			return minkcli.ErrInvalidValue("run-image", err.Error())
+		}
+	}
+
+	opts.CacheImage = viper.GetString("cache-image")
+	if opts.CacheImage != "" {
+		if _, err := name.NewTag(opts.CacheImage); err != nil {
+			return minkcli.ErrInvalidValue("cache-image", err.Error())
+		}
+	}
+
+	opts.DefaultProcess = viper.GetString("default-process")
 	return nil
 }
 
@@ -110,6 +245,8 @@ type BuildpackOptions struct {
 	BaseBuildOptions
 
 	buildpackOptions
+
+	buildCacheOptions
 }
 
 // BuildpackOptions implements Interface
@@ -121,6 +258,7 @@ func (opts *BuildpackOptions) AddFlags(cmd *cobra.Command) {
 	opts.BaseBuildOptions.AddFlags(cmd)
 
 	opts.buildpackOptions.AddFlags(cmd)
+	opts.buildCacheOptions.AddFlags(cmd)
 }
 
 // Validate implements Interface
@@ -129,6 +267,9 @@ func (opts *BuildpackOptions) Validate(cmd *cobra.Command, args []string) error
 	if err := opts.BaseBuildOptions.Validate(cmd, args); err != nil {
 		return err
 	}
+	if err := opts.buildCacheOptions.Validate(cmd, args); err != nil {
+		return err
+	}
 
 	return opts.buildpackOptions.Validate(cmd, args)
 }
@@ -172,6 +313,12 @@ func (opts *BuildpackOptions) build(ctx context.Context, sourceDigest name.Diges
 	tr := buildpacks.Build(ctx, sourceDigest, tag, buildpacks.Options{
 		Builder:        opts.Builder,
 		DescriptorFile: opts.DescriptorFile,
+		Buildpacks:     opts.Buildpacks,
+		Env:            opts.Env,
+		RunImage:       opts.RunImage,
+		CacheImage:     opts.CacheImage,
+		ProcessType:    opts.DefaultProcess,
+		CacheClaim:     opts.CacheClaim,
 	})
 	tr.Namespace = Namespace()
 
@@ -186,5 +333,5 @@ func (opts *BuildpackOptions) build(ctx context.Context, sourceDigest name.Diges
 			Err: w,
 		},
 		Follow: true,
-	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest))
+	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest), builds.WithSourceProvenance(sourceDigest), builds.WithCacheClaim(ctx))
 }
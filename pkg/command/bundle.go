@@ -20,14 +20,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/bundles"
 	"github.com/mattmoor/mink/pkg/bundles/git"
 	"github.com/mattmoor/mink/pkg/bundles/kontext"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
 	minkcli "github.com/mattmoor/mink/pkg/cli"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -39,13 +48,17 @@ const (
 	UnknownMode BundleMode = iota
 	KontextMode
 	GitMode
+	GitWorktreeMode
 )
 
 // String implements fmt.Stringer
 func (m BundleMode) String() string {
-	return [...]string{"Unknown", "Kontext", "Git"}[m]
+	return [...]string{"Unknown", "Kontext", "Git", "GitWorktree"}[m]
 }
 
+// commitRegexp matches full commit SHAs, which may be passed to --git-ref.
+var commitRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
 // BundleOptions implements Interface for the `kn im bundle` command.
 type BundleOptions struct {
 	// TODO(mattmoor): Remove once https://github.com/tektoncd/cli/pull/1268 lands
@@ -54,18 +67,69 @@ type BundleOptions struct {
 	// ImageName is the string name of the bundle image to which we should publish things.
 	ImageName string
 
-	// tag is the processed version of ImageName that is populated while validating it.
-	tag name.Tag
+	// target is the processed version of ImageName that is populated while validating it.
+	target bundles.Target
+
+	// base is the image on which bundles are based, which is populated from
+	// --bundle-base while validating.
+	base bundles.Base
+
+	// Compression is how the layers of the bundle are compressed.
+	Compression bundles.Compression
+
+	// Platforms restricts the bundle to these platforms of the base image.
+	Platforms []v1.Platform
+
+	// Secrets is how the bundle is scanned for secrets before it is published.
+	Secrets *secrets.Policy
+
+	// Size bounds the size of the bundle before it is published.
+	Size *kontext.SizeBudget
+
+	// dryRun indicates that the bundle should be checked (and its size
+	// reported), but not published.  It is set by `kn im bundle --dry-run`.
+	dryRun bool
 
 	// Directory is the string containing the directory to bundle.
 	// This option signals "kontext mode".
 	Directory string
 
+	// Includes holds additional directories that are merged into the
+	// bundle of Directory in "kontext mode".
+	Includes []kontext.Include
+
+	// IgnoreFiles holds the names of the gitignore-style files that are
+	// consulted when walking Directory in "kontext mode".
+	IgnoreFiles []string
+	// MaxLayers is the maximum number of layers into which a "kontext mode"
+	// bundle is split to make pushes incremental.
+	MaxLayers int
+	// Fidelity indicates that "kontext mode" bundles should preserve file
+	// modes and symlinks.
+	Fidelity bool
+
 	// GitURL is the URL of the git repository to clone.
 	// This option signals "git mode".
 	GitURL string
 	// GitRef is the ref to check out within the above repository.
 	GitRef plumbing.ReferenceName
+	// GitCommit is the commit to check out within the above repository,
+	// which is populated instead of GitRef when --git-ref is a commit SHA.
+	GitCommit plumbing.Hash
+	// GitDepth limits the depth of the clone, when non-zero.
+	GitDepth int
+	// GitSubmodules indicates whether to check out submodules recursively.
+	GitSubmodules bool
+	// GitToken is a token with which to authenticate clones over http(s).
+	GitToken string
+	// GitSSHKey is the path to a private key with which to authenticate
+	// clones over ssh.
+	GitSSHKey string
+
+	// GitWorktree is a directory within the working tree of a local git
+	// repository whose tracked files should be bundled.
+	// This option signals "git worktree mode".
+	GitWorktree string
 
 	// mode holds the type of bundling we are performing.
 	mode BundleMode
@@ -81,25 +145,97 @@ func (opts *BundleOptions) GetContext(cmd *cobra.Command) context.Context {
 
 // AddFlags implements Interface
 func (opts *BundleOptions) AddFlags(cmd *cobra.Command) {
-	cmd.Flags().String("bundle", "", "Where to publish the bundle.")
+	cmd.Flags().String("bundle", "", "Where to publish the bundle, either an image tag, an OCI image "+
+		"layout on disk (oci:/path/to/layout) or a tarball on disk (tarball:/path/to/bundle.tar).")
+	cmd.Flags().String("bundle-base", kontext.BaseImageString,
+		"The self-extracting image on which to base the bundle, either an image reference or "+
+			"an OCI image layout on disk (oci:/path/to/layout) for bundling without network access.")
+	cmd.Flags().String("bundle-compression", string(bundles.GzipCompression),
+		fmt.Sprintf("How to compress the layers of the bundle, one of %v (estargz and zstd "+
+			"allow runtimes that support them to start expanding the bundle sooner).", bundles.Compressions))
+
+	cmd.Flags().StringSlice("bundle-platforms", nil,
+		"The platforms of the base image for which to produce the bundle (e.g. linux/amd64), "+
+			"by default every platform of the base image is bundled.")
+
+	cmd.Flags().String("secret-scan", string(secrets.ErrorMode),
+		fmt.Sprintf("What to do when the bundle appears to hold secrets (e.g. private keys, tokens or .env files), "+
+			"one of %v.", secrets.Modes))
+	cmd.Flags().StringSlice("secret-scan-allow", nil,
+		"Patterns of files (e.g. testdata/*.pem) to exclude from --secret-scan, optionally prefixed by the "+
+			"rule to which they apply (e.g. high-entropy:fixtures/*.json).")
+
+	cmd.Flags().String("max-bundle-size", "",
+		"The largest total size of the files in the bundle (e.g. 500Mi or 2Gi), beyond which it is not "+
+			"published and the largest files and directories are reported instead, by default it is unlimited.")
 
 	// KontextMode options
 	cmd.Flags().String("directory", "", "The directory to bundle up.")
+	cmd.Flags().StringSlice("include", nil,
+		"Additional directories to merge into the bundle of --directory, as SRC[:DEST] where DEST "+
+			"(which defaults to the base name of SRC) is where SRC is placed relative to the root of the bundle.  "+
+			"Later includes take precedence over earlier ones (and --directory) where they overlap.")
+	cmd.Flags().StringSlice("ignore-file", kontext.DefaultIgnoreFiles,
+		"The names of gitignore-style files to consult in each directory when bundling up --directory, "+
+			"later files take precedence over earlier ones.")
+	cmd.Flags().Int("bundle-layers", kontext.DefaultMaxLayers,
+		"The maximum number of layers into which to split the bundle of --directory, so that "+
+			"unchanged parts of the tree need not be uploaded again (1 produces a single layer).")
+	cmd.Flags().Bool("fidelity", false,
+		"Preserve the modes of files (e.g. executable bits) and bundle symlinks as symlinks "+
+			"instead of following them when bundling up --directory.")
 
 	// GitMode options
 	cmd.Flags().String("git-url", "", "The git repository to bundle.")
-	cmd.Flags().String("git-ref", "", "The ref within the git repository to bundle.")
+	cmd.Flags().String("git-ref", "", "The ref (e.g. refs/heads/main or refs/tags/v1.0) or commit SHA within the git repository to bundle.")
+	cmd.Flags().Int("git-depth", 0, "The number of commits to which to limit the clone of --git-url (0 clones everything).")
+	cmd.Flags().Bool("git-submodules", false, "Whether to check out (and bundle) the submodules of --git-url recursively.")
+	cmd.Flags().String("git-token", "",
+		"A token with which to authenticate the clone of --git-url over http(s), by default the "+
+			"credentials stored by git's configured credential helpers are used.")
+	cmd.Flags().String("git-ssh-key", "",
+		"The path to a private key with which to authenticate the clone of --git-url over ssh, "+
+			"by default ssh-agent is used.")
+
+	// GitWorktreeMode options
+	cmd.Flags().String("git-worktree", "",
+		"A directory within a local git repository whose tracked files (including uncommitted changes) "+
+			"should be bundled, defaults to the current directory when passed without a value.")
+	cmd.Flags().Lookup("git-worktree").NoOptDefVal = "."
 }
 
 // Validate implements Interface
 func (opts *BundleOptions) Validate(cmd *cobra.Command, args []string) error {
-	viper.BindPFlags(cmd.Flags())
+	if err := opts.validateSource(cmd); err != nil {
+		return err
+	}
+
 	opts.ImageName = viper.GetString("bundle")
+	if opts.ImageName == "" {
+		return minkcli.ErrMissingFlag("bundle")
+	} else if target, err := bundles.ParseTarget(opts.ImageName); err != nil {
+		return minkcli.ErrInvalidValue("bundle", err.Error())
+	} else {
+		opts.target = target
+	}
+
+	if base, err := bundles.ParseBase(viper.GetString("bundle-base")); err != nil {
+		return minkcli.ErrInvalidValue("bundle-base", err.Error())
+	} else {
+		opts.base = base
+	}
+	return nil
+}
+
+// validateSource validates the options that determine what is bundled,
+// but not where it is published.
+func (opts *BundleOptions) validateSource(cmd *cobra.Command) error {
+	viper.BindPFlags(cmd.Flags())
 	opts.mode = UnknownMode
 
 	// Check for multiple mode-determining options.
 	seen := sets.NewString()
-	for _, key := range []string{"directory", "get-url"} {
+	for _, key := range []string{"directory", "git-url", "git-worktree"} {
 		if viper.GetString(key) != "" {
 			seen.Insert(key)
 		}
@@ -113,14 +249,80 @@ func (opts *BundleOptions) Validate(cmd *cobra.Command, args []string) error {
 	if opts.Directory != "" {
 		opts.mode = KontextMode
 	}
+	opts.Includes = nil
+	for _, s := range viper.GetStringSlice("include") {
+		inc, err := kontext.ParseInclude(s)
+		if err != nil {
+			return minkcli.ErrInvalidValue("include", err.Error())
+		}
+		opts.Includes = append(opts.Includes, inc)
+	}
+	opts.IgnoreFiles = viper.GetStringSlice("ignore-file")
+	opts.Fidelity = viper.GetBool("fidelity")
+	opts.MaxLayers = viper.GetInt("bundle-layers")
+	if opts.MaxLayers <= 0 {
+		return minkcli.ErrInvalidValue("bundle-layers",
+			"must be greater than 0, but got: %d", opts.MaxLayers)
+	}
+	opts.Platforms = nil
+	for _, s := range viper.GetStringSlice("bundle-platforms") {
+		p, err := v1.ParsePlatform(s)
+		if err != nil {
+			return minkcli.ErrInvalidValue("bundle-platforms", err.Error())
+		}
+		opts.Platforms = append(opts.Platforms, *p)
+	}
+	if mode, err := secrets.ParseMode(viper.GetString("secret-scan")); err != nil {
+		return minkcli.ErrInvalidValue("secret-scan", err.Error())
+	} else {
+		opts.Secrets = &secrets.Policy{
+			Mode:     mode,
+			Allow:    viper.GetStringSlice("secret-scan-allow"),
+			Warnings: os.Stderr,
+		}
+		if err := opts.Secrets.Validate(); err != nil {
+			return minkcli.ErrInvalidValue("secret-scan-allow", err.Error())
+		}
+	}
+	opts.Size = &kontext.SizeBudget{}
+	if s := viper.GetString("max-bundle-size"); s != "" {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return minkcli.ErrInvalidValue("max-bundle-size", err.Error())
+		} else if q.Sign() <= 0 {
+			return minkcli.ErrInvalidValue("max-bundle-size", "must be greater than 0, but got: %s", s)
+		}
+		opts.Size.Max = q.Value()
+	}
+	if c, err := bundles.ParseCompression(viper.GetString("bundle-compression")); err != nil {
+		return minkcli.ErrInvalidValue("bundle-compression", err.Error())
+	} else {
+		opts.Compression = c
+	}
 
 	// See if we're in "git mode"
 	opts.GitURL = viper.GetString("git-url")
 	if opts.GitURL != "" {
 		opts.mode = GitMode
-		if ref := viper.GetString("git-ref"); ref != "" {
+		if ref := viper.GetString("git-ref"); commitRegexp.MatchString(ref) {
+			opts.GitCommit = plumbing.NewHash(ref)
+		} else if ref != "" {
 			opts.GitRef = plumbing.ReferenceName(ref)
 		}
+		opts.GitDepth = viper.GetInt("git-depth")
+		if opts.GitDepth < 0 {
+			return minkcli.ErrInvalidValue("git-depth",
+				"must not be negative, but got: %d", opts.GitDepth)
+		}
+		opts.GitSubmodules = viper.GetBool("git-submodules")
+		opts.GitToken = viper.GetString("git-token")
+		opts.GitSSHKey = viper.GetString("git-ssh-key")
+	}
+
+	// See if we're in "git worktree mode"
+	opts.GitWorktree = viper.GetString("git-worktree")
+	if opts.GitWorktree != "" {
+		opts.mode = GitWorktreeMode
 	}
 
 	// When all else fails, fallback on the current directory and context mode.
@@ -128,13 +330,8 @@ func (opts *BundleOptions) Validate(cmd *cobra.Command, args []string) error {
 		opts.mode = KontextMode
 		opts.Directory = "."
 	}
-
-	if opts.ImageName == "" {
-		return minkcli.ErrMissingFlag("bundle")
-	} else if tag, err := name.NewTag(opts.ImageName, name.WeakValidation); err != nil {
-		return minkcli.ErrInvalidValue("bundle", err.Error())
-	} else {
-		opts.tag = tag
+	if len(opts.Includes) > 0 && opts.mode != KontextMode {
+		return minkcli.ErrInvalidValue("include", "is only supported when bundling a --directory")
 	}
 	return nil
 }
@@ -145,38 +342,248 @@ func (opts *BundleOptions) Execute(cmd *cobra.Command, args []string) error {
 		return errors.New("'im bundle' does not take any arguments")
 	}
 
-	digest, err := opts.bundle(opts.GetContext(cmd))
+	ref, err := opts.publish(opts.GetContext(cmd))
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", ref)
 	return nil
 }
 
+// bundle publishes the bundle to the registry, and returns its digest.
 func (opts *BundleOptions) bundle(ctx context.Context) (name.Digest, error) {
+	ref, err := opts.publish(ctx)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return name.NewDigest(ref)
+}
+
+// publish writes the bundle to the configured target, and returns a
+// reference to it.
+func (opts *BundleOptions) publish(ctx context.Context) (string, error) {
+	var mopts []bundles.MapOption
+	if len(opts.Platforms) > 0 {
+		mopts = append(mopts, bundles.WithPlatforms(opts.Platforms...))
+	}
+
 	switch opts.mode {
 	case KontextMode:
-		return kontext.Bundle(ctx, opts.Directory, opts.tag)
+		return kontext.Bundle(ctx, opts.kontextOptions(), opts.base, opts.target, mopts...)
 	case GitMode:
 		return git.Bundle(ctx, git.Options{
-			URL: opts.GitURL,
-			Ref: opts.GitRef,
-		}, opts.tag)
+			URL:        opts.GitURL,
+			Ref:        opts.GitRef,
+			Commit:     opts.GitCommit,
+			Depth:      opts.GitDepth,
+			Submodules: opts.GitSubmodules,
+			Token:      opts.GitToken,
+			SSHKey:     opts.GitSSHKey,
+
+			Compression: opts.Compression,
+			Secrets:     opts.Secrets,
+			Size:        opts.Size,
+			DryRun:      opts.dryRun,
+		}, opts.base, opts.target, mopts...)
+	case GitWorktreeMode:
+		return git.BundleWorktree(ctx, git.WorktreeOptions{
+			Path:        opts.GitWorktree,
+			Compression: opts.Compression,
+			Secrets:     opts.Secrets,
+			Size:        opts.Size,
+			DryRun:      opts.dryRun,
+		}, opts.base, opts.target, mopts...)
 	default:
-		return name.Digest{}, fmt.Errorf("unsupported mode %v", opts.mode)
+		return "", fmt.Errorf("unsupported mode %v", opts.mode)
 	}
 }
 
+func (opts *BundleOptions) kontextOptions() kontext.Options {
+	return kontext.Options{
+		Directory:   opts.Directory,
+		Includes:    opts.Includes,
+		IgnoreFiles: opts.IgnoreFiles,
+		MaxLayers:   opts.MaxLayers,
+		Fidelity:    opts.Fidelity,
+		Compression: opts.Compression,
+		Secrets:     opts.Secrets,
+		Size:        opts.Size,
+		DryRun:      opts.dryRun,
+	}
+}
+
+// BundleCommandOptions implements Interface for the `kn im bundle` command,
+// extending BundleOptions with flags that only make sense for that command.
+type BundleCommandOptions struct {
+	// Inherit all of the bundle options.
+	BundleOptions
+
+	// ListFiles indicates that instead of publishing a bundle we should
+	// print the files that it would contain.
+	ListFiles bool
+
+	// VerifyReproducible indicates that we should produce the bundle twice
+	// and check that the resulting digests match.
+	VerifyReproducible bool
+
+	// DryRun indicates that instead of publishing a bundle we should
+	// report its size (after checking it as we would before publishing it).
+	DryRun bool
+}
+
+// BundleCommandOptions implements Interface
+var _ Interface = (*BundleCommandOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *BundleCommandOptions) AddFlags(cmd *cobra.Command) {
+	opts.BundleOptions.AddFlags(cmd)
+
+	cmd.Flags().Bool("list-files", false, "Print the files that would be bundled instead of publishing a bundle.")
+	cmd.Flags().Bool("verify-reproducible", false, "Produce the bundle twice and fail if the digests differ, before publishing it.")
+	cmd.Flags().Bool("dry-run", false,
+		"Print the size of the bundle and its largest files and directories instead of publishing it.")
+}
+
+// Validate implements Interface
+func (opts *BundleCommandOptions) Validate(cmd *cobra.Command, args []string) error {
+	viper.BindPFlags(cmd.Flags())
+	opts.ListFiles = viper.GetBool("list-files")
+	opts.VerifyReproducible = viper.GetBool("verify-reproducible")
+	opts.DryRun = viper.GetBool("dry-run")
+	if opts.ListFiles && opts.DryRun {
+		return errors.New("--list-files and --dry-run are mutually exclusive")
+	}
+	if !opts.ListFiles && !opts.DryRun {
+		return opts.BundleOptions.Validate(cmd, args)
+	}
+
+	// We don't need anywhere to publish things when listing files or
+	// doing a dry run.
+	if err := opts.validateSource(cmd); err != nil {
+		return err
+	}
+	if opts.DryRun {
+		opts.dryRun = true
+		opts.Size.Report = cmd.OutOrStdout()
+		return nil
+	}
+	if opts.mode != KontextMode {
+		return minkcli.ErrInvalidValue("list-files", "is only supported when bundling a --directory")
+	}
+	return nil
+}
+
+// Execute implements Interface
+func (opts *BundleCommandOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errors.New("'im bundle' does not take any arguments")
+	}
+	if opts.DryRun {
+		_, err := opts.publish(opts.GetContext(cmd))
+		return err
+	}
+	if !opts.ListFiles {
+		if !opts.VerifyReproducible {
+			return opts.BundleOptions.Execute(cmd, args)
+		}
+		return opts.verifyReproducible(cmd)
+	}
+
+	files, err := kontext.Files(opts.kontextOptions())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Fprintln(cmd.OutOrStdout(), f)
+	}
+	return nil
+}
+
+// verifyReproducible produces the bundle twice, and checks that the digests
+// match before publishing it and printing its digest.  Both are produced in
+// throwaway OCI image layouts, so that a bundle that isn't reproducible is
+// never published.
+func (opts *BundleCommandOptions) verifyReproducible(cmd *cobra.Command) error {
+	ctx := opts.GetContext(cmd)
+	dir, err := ioutil.TempDir("", "verify-reproducible-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	refs := make([]string, 0, 2)
+	for _, name := range []string{"first", "second"} {
+		local := opts.BundleOptions
+		if local.target, err = bundles.ParseTarget(bundles.LayoutPrefix + filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		ref, err := local.publish(ctx)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	first := refs[0][strings.LastIndex(refs[0], "@")+1:]
+	second := refs[1][strings.LastIndex(refs[1], "@")+1:]
+	if first != second {
+		return fmt.Errorf("bundle is not reproducible, got %s and %s", first, second)
+	}
+
+	base, err := bundles.ParseBase(refs[0])
+	if err != nil {
+		return err
+	}
+	ref, err := bundles.Copy(ctx, base, opts.target)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", ref)
+	return nil
+}
+
 var bundleExample = fmt.Sprintf(`
   # Create a self-extracting bundle of the current directory.
   %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest
 
   # Create a self-extracting bundle of a sub-directory.
-  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --directory subdir/`, ExamplePrefix())
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --directory subdir/
+
+  # Create a self-extracting bundle of the files tracked by git (including
+  # uncommitted changes) in the repository containing the current directory.
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --git-worktree
+
+  # Create a self-extracting bundle of a commit in a private git repository,
+  # authenticating with the token in $MINK_GIT_TOKEN.
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --git-url https://github.com/mattmoor/private.git \
+     --git-ref 4b825dc642cb6eb9a060e54bf8d69288fbee4904 --git-submodules
+
+  # Create a self-extracting bundle of the current directory in a local OCI
+  # image layout, without pushing anything to a registry.
+  %[1]s bundle --bundle oci:./bundle --bundle-base oci:./kontext-expander
+
+  # As the first, but also bundle the shared proto/ and libs/ directories
+  # next to the current directory as proto/ and third_party/libs/.
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --include ../proto --include ../libs:third_party/libs
+
+  # As the first, but only consult .minkignore files to exclude things.
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --ignore-file=.minkignore
+
+  # As the first, but check that producing the bundle is reproducible.
+  %[1]s bundle --bundle ghcr.io/mattmoor/bundle:latest --verify-reproducible
+
+  # Print the files that would be bundled from the current directory.
+  %[1]s bundle --list-files
+
+  # Print the size of the bundle of the current directory, and its largest
+  # files and directories, without publishing it.
+  %[1]s bundle --dry-run
+
+  # Print the files within a published bundle (see also extract and diff).
+  %[1]s bundle inspect ghcr.io/mattmoor/bundle:latest`, ExamplePrefix())
 
 // NewBundleCommand implements 'kn-im bundle' command
 func NewBundleCommand(ctx context.Context) *cobra.Command {
-	opts := &BundleOptions{ctx: ctx}
+	opts := &BundleCommandOptions{BundleOptions: BundleOptions{ctx: ctx}}
 
 	cmd := &cobra.Command{
 		Use:     "bundle --bundle IMAGE",
@@ -188,5 +595,9 @@ func NewBundleCommand(ctx context.Context) *cobra.Command {
 
 	opts.AddFlags(cmd)
 
+	cmd.AddCommand(NewBundleInspectCommand(ctx))
+	cmd.AddCommand(NewBundleExtractCommand(ctx))
+	cmd.AddCommand(NewBundleDiffCommand(ctx))
+
 	return cmd
 }
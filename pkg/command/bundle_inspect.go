@@ -0,0 +1,262 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/kontext"
+	"github.com/spf13/cobra"
+)
+
+var bundleInspectExample = fmt.Sprintf(`
+  # List the files and annotations of a bundle in a registry.
+  %[1]s bundle inspect ghcr.io/mattmoor/bundle:latest
+
+  # List the files and annotations of a bundle in a local OCI image layout.
+  %[1]s bundle inspect oci:./bundle`, ExamplePrefix())
+
+var bundleExtractExample = fmt.Sprintf(`
+  # Lay down the files of a bundle as its build would see them.
+  %[1]s bundle extract ghcr.io/mattmoor/bundle:latest ./extracted`, ExamplePrefix())
+
+var bundleDiffExample = fmt.Sprintf(`
+  # Compare the files of two bundles.
+  %[1]s bundle diff ghcr.io/mattmoor/bundle@sha256:abc... ghcr.io/mattmoor/bundle:latest`, ExamplePrefix())
+
+// NewBundleInspectCommand implements 'kn-im bundle inspect' command
+func NewBundleInspectCommand(ctx context.Context) *cobra.Command {
+	opts := &BundleInspectOptions{ctx: ctx}
+
+	cmd := &cobra.Command{
+		Use:     "inspect REF",
+		Short:   "Lists the files and annotations of a bundle",
+		Example: bundleInspectExample,
+		Args:    cobra.ExactArgs(1),
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+// NewBundleExtractCommand implements 'kn-im bundle extract' command
+func NewBundleExtractCommand(ctx context.Context) *cobra.Command {
+	opts := &BundleExtractOptions{ctx: ctx}
+
+	cmd := &cobra.Command{
+		Use:     "extract REF DIR",
+		Short:   "Lays down the files of a bundle in a local directory, as its build would see them",
+		Example: bundleExtractExample,
+		Args:    cobra.ExactArgs(2),
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+// NewBundleDiffCommand implements 'kn-im bundle diff' command
+func NewBundleDiffCommand(ctx context.Context) *cobra.Command {
+	opts := &BundleDiffOptions{ctx: ctx}
+
+	cmd := &cobra.Command{
+		Use:     "diff A B",
+		Short:   "Compares the files of two bundles",
+		Example: bundleDiffExample,
+		Args:    cobra.ExactArgs(2),
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+// BundleInspectOptions implements Interface for the `kn im bundle inspect` command.
+type BundleInspectOptions struct {
+	// TODO(mattmoor): Remove once https://github.com/tektoncd/cli/pull/1268 lands
+	ctx context.Context
+}
+
+// BundleInspectOptions implements Interface
+var _ Interface = (*BundleInspectOptions)(nil)
+
+// GetContext implements Interface
+func (opts *BundleInspectOptions) GetContext(cmd *cobra.Command) context.Context {
+	return opts.ctx
+}
+
+// AddFlags implements Interface
+func (opts *BundleInspectOptions) AddFlags(cmd *cobra.Command) {}
+
+// Validate implements Interface
+func (opts *BundleInspectOptions) Validate(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
+// Execute implements Interface
+func (opts *BundleInspectOptions) Execute(cmd *cobra.Command, args []string) error {
+	img, err := bundles.Load(opts.GetContext(cmd), args[0])
+	if err != nil {
+		return err
+	}
+	anns, err := annotations(img)
+	if err != nil {
+		return err
+	}
+	entries, err := kontext.Contents(img)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintln(out, "Annotations:")
+	keys := make([]string, 0, len(anns))
+	for k := range anns {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %s: %s\n", k, anns[k])
+	}
+
+	fmt.Fprintln(out, "Files:")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for _, e := range entries {
+		name := e.Path
+		if e.Mode&os.ModeSymlink != 0 {
+			name += " -> " + e.Linkname
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t  %s\n", e.Mode, e.Size, name)
+	}
+	return tw.Flush()
+}
+
+// annotations returns the annotations of the given bundle, falling back on
+// the labels that record the same things for bundles whose manifests lost
+// their annotations (e.g. those written to tarballs).
+func annotations(img v1.Image) (map[string]string, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Annotations) > 0 {
+		return m.Annotations, nil
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return cf.Config.Labels, nil
+}
+
+// BundleExtractOptions implements Interface for the `kn im bundle extract` command.
+type BundleExtractOptions struct {
+	// TODO(mattmoor): Remove once https://github.com/tektoncd/cli/pull/1268 lands
+	ctx context.Context
+}
+
+// BundleExtractOptions implements Interface
+var _ Interface = (*BundleExtractOptions)(nil)
+
+// GetContext implements Interface
+func (opts *BundleExtractOptions) GetContext(cmd *cobra.Command) context.Context {
+	return opts.ctx
+}
+
+// AddFlags implements Interface
+func (opts *BundleExtractOptions) AddFlags(cmd *cobra.Command) {}
+
+// Validate implements Interface
+func (opts *BundleExtractOptions) Validate(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
+// Execute implements Interface
+func (opts *BundleExtractOptions) Execute(cmd *cobra.Command, args []string) error {
+	img, err := bundles.Load(opts.GetContext(cmd), args[0])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(args[1], os.ModePerm); err != nil {
+		return err
+	}
+	return kontext.Extract(img, args[1])
+}
+
+// BundleDiffOptions implements Interface for the `kn im bundle diff` command.
+type BundleDiffOptions struct {
+	// TODO(mattmoor): Remove once https://github.com/tektoncd/cli/pull/1268 lands
+	ctx context.Context
+}
+
+// BundleDiffOptions implements Interface
+var _ Interface = (*BundleDiffOptions)(nil)
+
+// GetContext implements Interface
+func (opts *BundleDiffOptions) GetContext(cmd *cobra.Command) context.Context {
+	return opts.ctx
+}
+
+// AddFlags implements Interface
+func (opts *BundleDiffOptions) AddFlags(cmd *cobra.Command) {}
+
+// Validate implements Interface
+func (opts *BundleDiffOptions) Validate(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
+// Execute implements Interface
+func (opts *BundleDiffOptions) Execute(cmd *cobra.Command, args []string) error {
+	ctx := opts.GetContext(cmd)
+	var contents [2][]kontext.Entry
+	for i, ref := range args {
+		img, err := bundles.Load(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if contents[i], err = kontext.Contents(img); err != nil {
+			return err
+		}
+	}
+
+	// Print the differences in the style of `git diff --name-status`.
+	out := cmd.OutOrStdout()
+	for _, d := range kontext.Diff(contents[0], contents[1]) {
+		switch {
+		case d.Before == nil:
+			fmt.Fprintf(out, "A\t%s\n", d.Path)
+		case d.After == nil:
+			fmt.Fprintf(out, "D\t%s\n", d.Path)
+		default:
+			fmt.Fprintf(out, "M\t%s\n", d.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/secrets"
+	"github.com/spf13/cobra"
+)
+
+func TestSecretScanDefault(t *testing.T) {
+	cmd := &cobra.Command{}
+	(&BundleOptions{}).AddFlags(cmd)
+
+	// Bundles that appear to hold secrets are not published unless the
+	// user opts down to warn or off.
+	f := cmd.Flags().Lookup("secret-scan")
+	if f == nil {
+		t.Fatal("Lookup(secret-scan) = nil")
+	}
+	if got, want := f.DefValue, string(secrets.ErrorMode); got != want {
+		t.Errorf("--secret-scan default = %q, wanted %q", got, want)
+	}
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Bundle a directory onto a base in an OCI image layout.
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "cmd"), os.ModePerm); err != nil {
+		t.Fatal("os.MkdirAll() =", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "cmd", "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal("os.WriteFile() =", err)
+	}
+	basePath := filepath.Join(dir, "base")
+	p, err := layout.Write(basePath, empty.Index)
+	if err != nil {
+		t.Fatal("layout.Write() =", err)
+	}
+	img, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatal("random.Image() =", err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		t.Fatal("AppendImage() =", err)
+	}
+	base, err := bundles.ParseBase(bundles.LayoutPrefix + basePath)
+	if err != nil {
+		t.Fatal("ParseBase() =", err)
+	}
+	outPath := filepath.Join(dir, "out")
+	target, err := bundles.ParseTarget(bundles.LayoutPrefix + outPath)
+	if err != nil {
+		t.Fatal("ParseTarget() =", err)
+	}
+
+	opts := &BundleCommandOptions{
+		BundleOptions: BundleOptions{
+			ctx:         ctx,
+			mode:        KontextMode,
+			Directory:   src,
+			base:        base,
+			target:      target,
+			Compression: bundles.GzipCompression,
+		},
+		VerifyReproducible: true,
+	}
+	cmd := &cobra.Command{}
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	if err := opts.verifyReproducible(cmd); err != nil {
+		t.Fatal("verifyReproducible() =", err)
+	}
+
+	// The verified bundle is published to the target, once.
+	ref := strings.TrimSpace(out.String())
+	if !strings.HasPrefix(ref, bundles.LayoutPrefix+outPath+"@sha256:") {
+		t.Errorf("verifyReproducible() = %s, wanted a digest within %s", ref, outPath)
+	}
+	ii, err := layout.ImageIndexFromPath(outPath)
+	if err != nil {
+		t.Fatal("ImageIndexFromPath() =", err)
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatal("IndexManifest() =", err)
+	}
+	if len(im.Manifests) != 1 || !strings.HasSuffix(ref, "@"+im.Manifests[0].Digest.String()) {
+		t.Errorf("Manifests = %v, wanted just %s", im.Manifests, ref)
+	}
+}
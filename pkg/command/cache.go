@@ -0,0 +1,195 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/bundles"
+	"github.com/mattmoor/mink/pkg/bundles/kontext"
+	"github.com/mattmoor/mink/pkg/cache"
+)
+
+// bundle publishes the bundle to the registry, and returns its digest.
+// With --local-cache, a bundle of the same inputs that was published
+// before is reused instead (so long as it is still in the registry).
+func (opts *BaseBuildOptions) bundle(ctx context.Context) (name.Digest, error) {
+	key, err := opts.bundleKey(ctx)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	if key != "" {
+		if digest, ok := opts.cached(ctx, cache.Bundles, key); ok {
+			return digest, nil
+		}
+	}
+
+	digest, err := opts.BundleOptions.bundle(ctx)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	if key != "" {
+		if err := opts.cache.Put(cache.Bundles, key, digest.String()); err != nil {
+			return name.Digest{}, err
+		}
+	}
+	return digest, nil
+}
+
+// bundleKey returns the key under which the bundle is cached, or the empty
+// string when it isn't.  Only bundles of local directories are cached, since
+// checking whether anything has changed is cheap for them.
+func (opts *BaseBuildOptions) bundleKey(ctx context.Context) (string, error) {
+	if opts.cache == nil || opts.mode != KontextMode {
+		return "", nil
+	}
+
+	kopts := opts.kontextOptions()
+	tree, err := kontext.Fingerprint(kopts)
+	if err != nil {
+		return "", err
+	}
+	prov, err := bundles.LocalProvenance("kontext", kopts.Directory)
+	if err != nil {
+		return "", err
+	}
+	mtime, err := bundles.SourceDateEpoch()
+	if err != nil {
+		return "", err
+	}
+	// Resolve the base, so that we notice when its tag moves.
+	base, err := bundles.BaseDigest(ctx, opts.base)
+	if err != nil {
+		return "", err
+	}
+
+	return cache.Key(
+		tree.String(),
+		base.String(),
+		opts.target.String(),
+		string(kopts.Compression),
+		strconv.Itoa(kopts.MaxLayers),
+		fmt.Sprint(opts.Platforms),
+		mtime.UTC().Format(time.RFC3339),
+		prov.Source,
+		prov.Revision,
+		strconv.FormatBool(prov.Dirty),
+		prov.Created.UTC().Format(time.RFC3339),
+		prov.Directory,
+	), nil
+}
+
+// cached returns the digest recorded in the cache under the given kind and
+// key, if there is one and it is still in the registry.
+func (opts *BaseBuildOptions) cached(ctx context.Context, kind, key string) (name.Digest, bool) {
+	value, ok, err := opts.cache.Get(kind, key)
+	if err != nil {
+		log.Printf("Ignoring the local cache: %v", err)
+		return name.Digest{}, false
+	} else if !ok {
+		return name.Digest{}, false
+	}
+
+	digest, err := name.NewDigest(value)
+	if err == nil {
+		_, err = remote.Head(digest, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+	if err != nil {
+		// The entry is stale (e.g. the image has since been deleted).
+		if err := opts.cache.Delete(kind, key); err != nil {
+			log.Printf("Ignoring the local cache: %v", err)
+		}
+		return name.Digest{}, false
+	}
+	return digest, true
+}
+
+// buildKey returns the key under which the result of the build of u from
+// source is cached, or the empty string when it isn't.  Only the builds of
+// builders whose results are determined by the source, their configuration
+// and the definition of the task that they run are cached (see
+// builds.KeyedBuilder), and e.g. not task:// builds, whose definitions live
+// on the cluster.  Keying on the task definition means that upgrading mink
+// (and so the images the tasks run) invalidates the cache.
+func (opts *ResolveOptions) buildKey(ctx context.Context, b builds.Builder, source name.Digest, u *url.URL, bo builds.BuilderOptions) (string, error) {
+	if opts.cache == nil {
+		return "", nil
+	}
+	kb, ok := b.(builds.KeyedBuilder)
+	if !ok {
+		return "", nil
+	}
+	config, task, err := kb.Key(ctx, u, bo)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	t, err := json.Marshal(task)
+	if err != nil {
+		return "", err
+	}
+	return cache.Key(source.String(), u.String(), bo.Tag.String(), string(c), string(t)), nil
+}
+
+// cachedBuild runs the given builder, unless the result of an identical
+// build is recorded in the local cache.  The output of the build is
+// displayed when it fails.
+func (opts *ResolveOptions) cachedBuild(ctx context.Context, b builds.Builder, source name.Digest, u *url.URL) (name.Digest, error) {
+	ctx = context.WithValue(ctx, resolveOptionsKey{}, opts)
+	bo, err := opts.builderOptions(u)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	key, err := opts.buildKey(ctx, b, source, u, bo)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	if key != "" {
+		if digest, ok := opts.cached(ctx, cache.Builds, key); ok {
+			return digest, nil
+		}
+	}
+
+	// Buffer the output, so we can display it on failures.
+	buf := &bytes.Buffer{}
+	bo.Output = buf
+	digest, err := b.Build(ctx, source, u, bo)
+	if err != nil {
+		log.Print(buf.String())
+		return name.Digest{}, err
+	}
+	if key != "" {
+		if err := opts.cache.Put(cache.Builds, key, digest.String()); err != nil {
+			return name.Digest{}, err
+		}
+	}
+	return digest, nil
+}
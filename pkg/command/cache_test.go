@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"text/template"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
+	"github.com/mattmoor/mink/pkg/builds/ko"
+	"github.com/mattmoor/mink/pkg/cache"
+)
+
+func TestBuildKey(t *testing.T) {
+	source, err := name.NewDigest("ghcr.io/mattmoor/source@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+	if err != nil {
+		t.Fatal("name.NewDigest() =", err)
+	}
+	newOptions := func() *ResolveOptions {
+		return &ResolveOptions{
+			BaseBuildOptions: BaseBuildOptions{
+				cache: cache.New(t.TempDir()),
+				tmpl:  template.Must(template.New("image").Parse("ghcr.io/mattmoor/image")),
+			},
+		}
+	}
+	key := func(opts *ResolveOptions, ref string) string {
+		t.Helper()
+		u, err := url.Parse(ref)
+		if err != nil {
+			t.Fatal("url.Parse() =", err)
+		}
+		b, _ := builds.Lookup(u.Scheme)
+		bo, err := opts.builderOptions(u)
+		if err != nil {
+			t.Fatal("builderOptions() =", err)
+		}
+		ctx := context.WithValue(context.Background(), resolveOptionsKey{}, opts)
+		k, err := opts.buildKey(ctx, b, source, u, bo)
+		if err != nil {
+			t.Fatal("buildKey() =", err)
+		}
+		return k
+	}
+
+	opts := newOptions()
+	for _, ref := range []string{"task://foo", "pipeline://bar", "unknown:///baz"} {
+		if got := key(opts, ref); got != "" {
+			t.Errorf("buildKey(%q) = %q, wanted no key", ref, got)
+		}
+	}
+	noCache := newOptions()
+	noCache.cache = nil
+	if got := key(noCache, "dockerfile:///"); got != "" {
+		t.Errorf("buildKey() without a cache = %q, wanted no key", got)
+	}
+
+	base := key(opts, "dockerfile:///")
+	if base == "" {
+		t.Fatal("buildKey(dockerfile) = \"\", wanted a key")
+	}
+	if got := key(newOptions(), "dockerfile:///"); got != base {
+		t.Errorf("buildKey() = %q, wanted the same key %q", got, base)
+	}
+	if got := key(opts, "dockerfile:///sub"); got == base {
+		t.Error("buildKey() didn't change with the reference")
+	}
+
+	opts.Dockerfile = "Dockerfile.prod"
+	if got := key(opts, "dockerfile:///"); got == base {
+		t.Error("buildKey() didn't change with the builder's options")
+	}
+	opts.Dockerfile = ""
+
+	// Changing the images that the task runs (e.g. by upgrading mink)
+	// changes the key.
+	step := &dockerfile.KanikoTask.Spec.Steps[len(dockerfile.KanikoTask.Spec.Steps)-1]
+	image := step.Image
+	step.Image = "gcr.io/kaniko-project/executor:newer"
+	got := key(opts, "dockerfile:///")
+	step.Image = image
+	if got == base {
+		t.Error("buildKey() didn't change with the kaniko image")
+	}
+
+	koBase := key(opts, "ko://github.com/mattmoor/mink/cmd/mink")
+	ko.KoImageString, image = "ghcr.io/mattmoor/ko:newer", ko.KoImageString
+	got = key(opts, "ko://github.com/mattmoor/mink/cmd/mink")
+	ko.KoImageString = image
+	if got == koBase {
+		t.Error("buildKey() didn't change with the ko image")
+	}
+}
@@ -18,8 +18,10 @@ package command
 
 import (
 	"context"
+	"sort"
 	"time"
 
+	"github.com/mattmoor/mink/pkg/builds"
 	"github.com/spf13/cobra"
 )
 
@@ -41,3 +43,31 @@ type Interface interface {
 
 // activityTimeout is the amount of time to wait for a run to show activity before timing out.
 const activityTimeout = 30 * time.Second
+
+// builderCommands holds the constructors of the commands of the builders
+// registered with registerBuilder, keyed by their scheme.
+var builderCommands = map[string]func(context.Context) *cobra.Command{}
+
+// registerBuilder registers a builder that mink provides for references of
+// the scheme (see builds.Register), along with the constructor of the command
+// that runs it directly (e.g. mink jib).
+func registerBuilder(scheme string, b builds.Builder, newCommand func(context.Context) *cobra.Command) {
+	builds.Register(scheme, b)
+	builderCommands[scheme] = newCommand
+}
+
+// NewBuilderCommands returns the commands of the builders registered with
+// registerBuilder, in the order of their schemes.
+func NewBuilderCommands(ctx context.Context) []*cobra.Command {
+	schemes := make([]string, 0, len(builderCommands))
+	for scheme := range builderCommands {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	cmds := make([]*cobra.Command, 0, len(schemes))
+	for _, scheme := range schemes {
+		cmds = append(cmds, builderCommands[scheme](ctx))
+	}
+	return cmds
+}
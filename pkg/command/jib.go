@@ -0,0 +1,253 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/jib"
+	minkcli "github.com/mattmoor/mink/pkg/cli"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tektoncd/cli/pkg/cli"
+	"github.com/tektoncd/cli/pkg/options"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var jibExample = fmt.Sprintf(`
+  # Build the Java project in the current directory with Jib, and publish
+  # it as the provided image name.
+  %[1]s jib --image ghcr.io/mattmoor/app:latest
+
+  # As the first, but builds the project in the services/api directory.
+  %[1]s jib services/api --image ghcr.io/mattmoor/api:latest
+
+  # As the first, but builds with Gradle, passing it extra arguments.
+  %[1]s jib --jib-tool=gradle --jib-args=-PskipTests --image ghcr.io/mattmoor/app:latest`, ExamplePrefix())
+
+func init() {
+	registerBuilder("jib", cachedBuiltin{
+		builtin: (*ResolveOptions).jib,
+		key: func(opts *ResolveOptions) (interface{}, interface{}) {
+			return opts.jibOptions, jib.JibTask.Spec
+		},
+	}, NewJibCommand)
+}
+
+// NewJibCommand implements 'kn-im jib' command
+func NewJibCommand(ctx context.Context) *cobra.Command {
+	opts := &JibOptions{
+		BaseBuildOptions: BaseBuildOptions{BundleOptions: BundleOptions{ctx: ctx}},
+	}
+
+	cmd := &cobra.Command{
+		Use:     "jib [DIRECTORY] --image IMAGE",
+		Short:   "Build an image from a Java project with Jib.",
+		Example: jibExample,
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+type jibOptions struct {
+	// Tool is the build tool with which to run Jib (see jib.Tools).
+	Tool string
+
+	// JibArgs holds extra arguments for Maven or Gradle.
+	JibArgs []string
+}
+
+// AddFlags implements Interface
+func (opts *jibOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("jib-tool", "auto",
+		fmt.Sprintf("The build tool with which to run Jib, one of %v (where auto picks the one the project uses).", jib.Tools))
+	cmd.Flags().StringArray("jib-args", nil, "Extra arguments to supply to Maven or Gradle for Jib builds.")
+}
+
+// Validate implements Interface
+func (opts *jibOptions) Validate(cmd *cobra.Command, args []string) error {
+	if err := opts.setTool(viper.GetString("jib-tool")); err != nil {
+		return minkcli.ErrInvalidValue("jib-tool", err.Error())
+	}
+	opts.JibArgs = viper.GetStringSlice("jib-args")
+	return nil
+}
+
+// setTool sets the build tool with which to run Jib.
+func (opts *jibOptions) setTool(tool string) error {
+	if !sets.NewString(jib.Tools...).Has(tool) {
+		return fmt.Errorf("must be one of %v, got: %s", jib.Tools, tool)
+	}
+	opts.Tool = tool
+	return nil
+}
+
+// withQuery returns a copy of the options, amended by the query of a jib:///
+// reference (e.g. jib:///app?jib-tool=maven), whose parameters are named
+// after the flags they override.
+func (opts jibOptions) withQuery(q url.Values) (jibOptions, error) {
+	for k, vs := range q {
+		var err error
+		switch k {
+		case "jib-tool":
+			err = opts.setTool(vs[len(vs)-1])
+		case "jib-args":
+			opts.JibArgs = append([]string(nil), vs...)
+		default:
+			err = errors.New("unsupported parameter")
+		}
+		if err != nil {
+			return jibOptions{}, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return opts, nil
+}
+
+// JibOptions implements Interface for the `kn im jib` command.
+type JibOptions struct {
+	// Inherit all of the base build options.
+	BaseBuildOptions
+
+	jibOptions
+
+	buildCacheOptions
+}
+
+// JibOptions implements Interface
+var _ Interface = (*JibOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *JibOptions) AddFlags(cmd *cobra.Command) {
+	// Add the bundle flags to our surface.
+	opts.BaseBuildOptions.AddFlags(cmd)
+
+	opts.jibOptions.AddFlags(cmd)
+	opts.buildCacheOptions.AddFlags(cmd)
+}
+
+// Validate implements Interface
+func (opts *JibOptions) Validate(cmd *cobra.Command, args []string) error {
+	// Validate the bundle arguments.
+	if err := opts.BaseBuildOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.buildCacheOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+
+	return opts.jibOptions.Validate(cmd, args)
+}
+
+// Execute implements Interface
+func (opts *JibOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return errors.New("'im jib' takes at most one directory")
+	}
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	p := path.Clean(dir)
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("directory %q must be a relative path within the bundle", dir)
+	}
+
+	// Handle ctrl+C
+	ctx := opts.GetContext(cmd)
+
+	// Bundle up the source context in an image.
+	sourceDigest, err := opts.bundle(ctx)
+	if err != nil {
+		return err
+	}
+
+	digest, err := opts.build(ctx, sourceDigest, &url.URL{Scheme: "jib", Path: path.Join("/", p)}, cmd.OutOrStderr())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	return nil
+}
+
+func (opts *JibOptions) build(ctx context.Context, sourceDigest name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	tag, err := opts.tag(imageNameContext{
+		URL: *u,
+	})
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	// Create a Build definition for turning the source into an image with Jib.
+	tr := jib.Build(ctx, sourceDigest, tag, jib.Options{
+		Path:       path.Join(".", u.Path),
+		Tool:       opts.Tool,
+		JibArgs:    opts.JibArgs,
+		CacheClaim: opts.CacheClaim,
+	})
+	tr.Namespace = Namespace()
+
+	// Run the produced Build definition to completion, streaming logs to stdout, and
+	// returning the digest of the produced image.
+	return builds.Run(ctx, tag.String(), tr, &options.LogOptions{
+		ActivityTimeout: activityTimeout,
+		Params:          &cli.TektonParams{},
+		Stream: &cli.Stream{
+			// Send Out to stderr so we can capture the digest for composition.
+			Out: w,
+			Err: w,
+		},
+		Follow: true,
+	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest), builds.WithSourceProvenance(sourceDigest), builds.WithCacheClaim(ctx))
+}
+
+func (opts *ResolveOptions) jib(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	if u.Host != "" {
+		return name.Digest{}, fmt.Errorf(
+			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
+			u.Scheme, u.Host, u.Scheme, u.Scheme)
+	}
+
+	// Create the equivalent `mink jib` invocation.
+	jo, err := opts.jibOptions.withQuery(u.Query())
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("invalid query in %q reference %s: %w", u.Scheme, u, err)
+	}
+	jib := JibOptions{
+		BaseBuildOptions:  opts.BaseBuildOptions,
+		jibOptions:        jo,
+		buildCacheOptions: opts.buildCacheOptions,
+	}
+	p := *u
+	p.RawQuery = ""
+
+	// Run the produced Build definition to completion, streaming logs to w, and
+	// returning the digest of the produced image.
+	return jib.build(ctx, source, &p, w)
+}
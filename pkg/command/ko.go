@@ -0,0 +1,357 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/ko"
+	"github.com/mattmoor/mink/pkg/bundles"
+	minkcli "github.com/mattmoor/mink/pkg/cli"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tektoncd/cli/pkg/cli"
+	"github.com/tektoncd/cli/pkg/options"
+)
+
+var koExample = fmt.Sprintf(`
+  # Build ./cmd/app from the context in the current directory with ko, and
+  # publish it as the provided image name.
+  %[1]s ko ./cmd/app --image ghcr.io/mattmoor/app:latest
+
+  # As the first, but for both amd64 and arm64, publishing an image index.
+  %[1]s ko ./cmd/app --ko-platform=linux/amd64,linux/arm64 --image ghcr.io/mattmoor/app:latest
+
+  # As the first, but on a different base image, and with the version
+  # stamped into the binary.
+  %[1]s ko ./cmd/app --ko-base-image=gcr.io/distroless/base:nonroot \
+     --ko-ldflags="-s -w -X main.version=1.2.3" --image ghcr.io/mattmoor/app:latest
+
+  # As the first, but reads the .ko.yaml from the hack/ directory of the bundle.
+  %[1]s ko ./cmd/app --ko-config=hack --image ghcr.io/mattmoor/app:latest`, ExamplePrefix())
+
+// NewKoCommand implements 'kn-im ko' command
+func NewKoCommand(ctx context.Context) *cobra.Command {
+	opts := &KoOptions{
+		BaseBuildOptions: BaseBuildOptions{BundleOptions: BundleOptions{ctx: ctx}},
+	}
+
+	cmd := &cobra.Command{
+		Use:     "ko IMPORTPATH --image IMAGE",
+		Short:   "Build an image from a Go import path with ko.",
+		Example: koExample,
+		PreRunE: opts.Validate,
+		RunE:    opts.Execute,
+	}
+
+	opts.AddFlags(cmd)
+
+	return cmd
+}
+
+type koOptions struct {
+	// Compression is how the layers of images built by ko are compressed.
+	Compression bundles.Compression
+
+	// Platforms holds the platforms for which ko builds (or all).
+	Platforms []string
+
+	// BaseImage overrides the default base image of .ko.yaml.
+	BaseImage string
+
+	// ConfigPath is the directory within the bundle holding the .ko.yaml.
+	ConfigPath string
+
+	// Ldflags holds the flags to pass to the linker.
+	Ldflags string
+
+	// GoFlags holds flags for the go command (as with GOFLAGS).
+	GoFlags string
+
+	// Tags holds the tags to publish, by default the tag of the image.
+	Tags []string
+
+	// SBOM is the kind of SBOM for ko to produce, when set.
+	SBOM string
+}
+
+// AddFlags implements Interface
+func (opts *koOptions) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("ko-compression", string(bundles.GzipCompression),
+		"How to compress the layers of images built by ko, one of [gzip estargz].")
+	cmd.Flags().StringSlice("ko-platform", nil,
+		"The platforms (e.g. linux/amd64,linux/arm64, or all for those of the base image) for which ko builds, "+
+			"publishing an image index when there are several.  By default ko builds for the platform of the node "+
+			"on which it runs.")
+	cmd.Flags().String("ko-base-image", "",
+		"The base image for ko builds, which takes precedence over the defaultBaseImage of .ko.yaml.")
+	cmd.Flags().String("ko-config", "",
+		"The directory within the bundle holding the .ko.yaml for ko builds, by default its root.")
+	cmd.Flags().String("ko-ldflags", "", "Flags to pass to the linker for ko builds (as with go build -ldflags).")
+	cmd.Flags().String("ko-goflags", "", "Flags for the go command for ko builds (as with GOFLAGS).")
+	cmd.Flags().StringSlice("ko-tags", nil, "The tags to which ko publishes, by default the tag of the image.")
+	cmd.Flags().String("ko-sbom", "",
+		"The kind of SBOM (e.g. spdx) for ko to produce, which requires a version of ko that supports --sbom.")
+}
+
+// Validate implements Interface
+func (opts *koOptions) Validate(cmd *cobra.Command, args []string) error {
+	switch c, err := bundles.ParseCompression(viper.GetString("ko-compression")); {
+	case err != nil:
+		return minkcli.ErrInvalidValue("ko-compression", err.Error())
+	case c == bundles.ZstdCompression:
+		return minkcli.ErrInvalidValue("ko-compression", "ko does not support %s", c)
+	default:
+		opts.Compression = c
+	}
+
+	if err := opts.setPlatforms(viper.GetStringSlice("ko-platform")); err != nil {
+		return minkcli.ErrInvalidValue("ko-platform", err.Error())
+	}
+	if err := opts.setBaseImage(viper.GetString("ko-base-image")); err != nil {
+		return minkcli.ErrInvalidValue("ko-base-image", err.Error())
+	}
+	if err := opts.setConfigPath(viper.GetString("ko-config")); err != nil {
+		return minkcli.ErrInvalidValue("ko-config", err.Error())
+	}
+	opts.Ldflags = viper.GetString("ko-ldflags")
+	opts.GoFlags = viper.GetString("ko-goflags")
+	if err := opts.setTags(viper.GetStringSlice("ko-tags")); err != nil {
+		return minkcli.ErrInvalidValue("ko-tags", err.Error())
+	}
+	opts.SBOM = viper.GetString("ko-sbom")
+	return nil
+}
+
+// setPlatforms sets the platforms for which ko builds.
+func (opts *koOptions) setPlatforms(ps []string) error {
+	opts.Platforms = nil
+	for _, p := range ps {
+		if p == "all" {
+			if len(ps) != 1 {
+				return errors.New("all may not be combined with other platforms")
+			}
+		} else if _, err := v1.ParsePlatform(p); err != nil {
+			return err
+		}
+		opts.Platforms = append(opts.Platforms, p)
+	}
+	return nil
+}
+
+// setBaseImage sets the default base image for ko builds.
+func (opts *koOptions) setBaseImage(s string) error {
+	if s != "" {
+		if _, err := name.ParseReference(s); err != nil {
+			return err
+		}
+	}
+	opts.BaseImage = s
+	return nil
+}
+
+// setConfigPath sets the directory within the bundle holding the .ko.yaml.
+func (opts *koOptions) setConfigPath(s string) error {
+	if s != "" {
+		s = path.Clean(s)
+		if path.IsAbs(s) || s == ".." || strings.HasPrefix(s, "../") {
+			return fmt.Errorf("%q must be a relative path within the bundle", s)
+		}
+	}
+	opts.ConfigPath = s
+	return nil
+}
+
+// setTags sets the tags to which ko publishes.
+func (opts *koOptions) setTags(tags []string) error {
+	opts.Tags = nil
+	for _, t := range tags {
+		if _, err := name.NewTag("example.com/repo:"+t, name.StrictValidation); err != nil {
+			return fmt.Errorf("invalid tag %q", t)
+		}
+		opts.Tags = append(opts.Tags, t)
+	}
+	return nil
+}
+
+// withQuery returns a copy of the options, amended by the query of a ko://
+// reference (e.g. ko://github.com/mattmoor/app?ko-platform=all), whose
+// parameters are named after the flags they override.
+func (opts koOptions) withQuery(q url.Values) (koOptions, error) {
+	for k, vs := range q {
+		v := vs[len(vs)-1]
+		var err error
+		switch k {
+		case "ko-platform":
+			err = opts.setPlatforms(strings.Split(strings.Join(vs, ","), ","))
+		case "ko-base-image":
+			err = opts.setBaseImage(v)
+		case "ko-config":
+			err = opts.setConfigPath(v)
+		case "ko-ldflags":
+			opts.Ldflags = v
+		case "ko-goflags":
+			opts.GoFlags = v
+		case "ko-tags":
+			err = opts.setTags(strings.Split(strings.Join(vs, ","), ","))
+		case "ko-sbom":
+			opts.SBOM = v
+		default:
+			err = errors.New("unsupported parameter")
+		}
+		if err != nil {
+			return koOptions{}, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return opts, nil
+}
+
+// KoOptions implements Interface for the `kn im ko` command.
+type KoOptions struct {
+	// Inherit all of the base build options.
+	BaseBuildOptions
+
+	koOptions
+
+	buildCacheOptions
+}
+
+// KoOptions implements Interface
+var _ Interface = (*KoOptions)(nil)
+
+// AddFlags implements Interface
+func (opts *KoOptions) AddFlags(cmd *cobra.Command) {
+	// Add the bundle flags to our surface.
+	opts.BaseBuildOptions.AddFlags(cmd)
+
+	opts.koOptions.AddFlags(cmd)
+	opts.buildCacheOptions.AddFlags(cmd)
+}
+
+// Validate implements Interface
+func (opts *KoOptions) Validate(cmd *cobra.Command, args []string) error {
+	// Validate the bundle arguments.
+	if err := opts.BaseBuildOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.buildCacheOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+
+	return opts.koOptions.Validate(cmd, args)
+}
+
+// Execute implements Interface
+func (opts *KoOptions) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("'im ko' takes exactly one import path")
+	}
+	u, err := importPathURL(args[0])
+	if err != nil {
+		return err
+	}
+
+	// Handle ctrl+C
+	ctx := opts.GetContext(cmd)
+
+	// Bundle up the source context in an image.
+	sourceDigest, err := opts.bundle(ctx)
+	if err != nil {
+		return err
+	}
+
+	digest, err := opts.build(ctx, sourceDigest, u, cmd.OutOrStderr())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s\n", digest.String())
+	return nil
+}
+
+// importPathURL returns the ko:// URL for the given import path, which may
+// be relative to the bundle (e.g. ./cmd/app).
+func importPathURL(ip string) (*url.URL, error) {
+	switch {
+	case strings.HasPrefix(ip, "ko://"):
+		return url.Parse(ip)
+	case strings.HasPrefix(ip, "."):
+		p := path.Clean(ip)
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("import path %q is outside of the bundle", ip)
+		}
+		// ko resolves relative import paths within the bundle.
+		return &url.URL{Scheme: "ko", Path: "./" + strings.TrimPrefix(p, "./")}, nil
+	default:
+		return url.Parse("ko://" + ip)
+	}
+}
+
+func (opts *KoOptions) build(ctx context.Context, sourceDigest name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	tag, err := opts.tag(imageNameContext{
+		URL: *u,
+	})
+	if err != nil {
+		return name.Digest{}, err
+	}
+
+	importPath := u.String()
+	if u.Host == "" {
+		// Relative import paths are passed to ko as they are.
+		importPath = u.Path
+	}
+
+	// Create a Build definition for turning the source into an image with ko.
+	tr, err := ko.Build(ctx, sourceDigest, tag, ko.Options{
+		ImportPath: importPath,
+		Estargz:    opts.koOptions.Compression == bundles.EstargzCompression,
+		Platforms:  opts.Platforms,
+		BaseImage:  opts.BaseImage,
+		ConfigPath: opts.ConfigPath,
+		Ldflags:    opts.Ldflags,
+		GoFlags:    opts.GoFlags,
+		Tags:       opts.Tags,
+		SBOM:       opts.SBOM,
+		CacheClaim: opts.CacheClaim,
+	})
+	if err != nil {
+		return name.Digest{}, err
+	}
+	tr.Namespace = Namespace()
+
+	// Run the produced Build definition to completion, streaming logs to stdout, and
+	// returning the digest of the produced image.
+	return builds.Run(ctx, tag.String(), tr, &options.LogOptions{
+		ActivityTimeout: activityTimeout,
+		Params:          &cli.TektonParams{},
+		Stream: &cli.Stream{
+			// Send Out to stderr so we can capture the digest for composition.
+			Out: w,
+			Err: w,
+		},
+		Follow: true,
+	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, sourceDigest), builds.WithSourceProvenance(sourceDigest), builds.WithCacheClaim(ctx))
+}
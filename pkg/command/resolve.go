@@ -26,6 +26,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -33,13 +34,13 @@ import (
 	"github.com/dprotaso/go-yit"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/mattmoor/mink/pkg/builds"
+	"github.com/mattmoor/mink/pkg/builds/buildpacks"
+	"github.com/mattmoor/mink/pkg/builds/dockerfile"
 	"github.com/mattmoor/mink/pkg/builds/ko"
 	minkcli "github.com/mattmoor/mink/pkg/cli"
 	"github.com/mattmoor/mink/pkg/constants"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/tektoncd/cli/pkg/cli"
-	"github.com/tektoncd/cli/pkg/options"
 	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -61,7 +62,11 @@ var resolveExample = fmt.Sprintf(`
   %[1]s resolve -f config/ --overrides another-name.toml
 
   # Customize the name of Dockerfiles to use for dockerfile:/// builds
-  %[1]s resolve -f config/ --dockerfile Dockerfile.production`, ExamplePrefix())
+  %[1]s resolve -f config/ --dockerfile Dockerfile.production
+
+  # Pass a build-time variable to all dockerfile:/// builds (individual
+  # references may add their own, e.g. dockerfile:///app?build-arg=VERSION=1.2.3)
+  %[1]s resolve -f config/ --build-arg=VERSION=1.2.3`, ExamplePrefix())
 
 // NewResolveCommand implements 'kn-im resolve' command
 func NewResolveCommand(ctx context.Context) *cobra.Command {
@@ -82,23 +87,114 @@ func NewResolveCommand(ctx context.Context) *cobra.Command {
 	return cmd
 }
 
-type builder func(context.Context, name.Digest, *url.URL) (name.Digest, error)
+func init() {
+	// Register the builders that mink provides, which build with the
+	// options of the resolve (or apply) running them.
+	for scheme, b := range map[string]builds.Builder{
+		"dockerfile": cachedBuiltin{
+			builtin: (*ResolveOptions).db,
+			key: func(opts *ResolveOptions) (interface{}, interface{}) {
+				return opts.dockerfileOptions, dockerfile.KanikoTask.Spec
+			},
+		},
+		"buildpack": cachedBuiltin{
+			builtin: (*ResolveOptions).bp,
+			key: func(opts *ResolveOptions) (interface{}, interface{}) {
+				return opts.buildpackOptions, buildpacks.BuildpackTask.Spec
+			},
+		},
+		"ko": cachedBuiltin{
+			builtin: (*ResolveOptions).ko,
+			key: func(opts *ResolveOptions) (interface{}, interface{}) {
+				// The ko task is assembled in code, around the ko image.
+				return opts.koOptions, ko.KoImageString
+			},
+		},
+		"task":     namedBuiltin{(*ResolveOptions).task},
+		"pipeline": namedBuiltin{(*ResolveOptions).pipeline},
+	} {
+		builds.Register(scheme, b)
+	}
+}
+
+// builtin adapts a builder that mink provides, which is a method of
+// ResolveOptions, to builds.Builder.
+type builtin func(opts *ResolveOptions, ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error)
+
+// Build implements builds.Builder
+func (b builtin) Build(ctx context.Context, source name.Digest, u *url.URL, opt builds.BuilderOptions) (name.Digest, error) {
+	opts, err := resolveOptions(ctx)
+	if err != nil {
+		return name.Digest{}, err
+	}
+	return b(opts, ctx, source, u, opt.Output)
+}
+
+// cachedBuiltin is a builtin whose builds are keyed on the options of the
+// builder and the task it runs (see --local-cache).
+type cachedBuiltin struct {
+	builtin
+	key func(*ResolveOptions) (config, task interface{})
+}
+
+// Key implements builds.KeyedBuilder
+func (b cachedBuiltin) Key(ctx context.Context, u *url.URL, opt builds.BuilderOptions) (interface{}, interface{}, error) {
+	opts, err := resolveOptions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, task := b.key(opts)
+	return config, task, nil
+}
+
+// namedBuiltin is a builtin for references that name a resource (e.g.
+// task://name), which take no path.
+type namedBuiltin struct {
+	builtin
+}
+
+// Named implements builds.NamedBuilder
+func (namedBuiltin) Named() {}
+
+// resolveOptionsKey is the key of the ResolveOptions in the context of
+// the builds that they run.
+type resolveOptionsKey struct{}
+
+// resolveOptions returns the options of the resolve (or apply) that is
+// running the builds of the given context.
+func resolveOptions(ctx context.Context) (*ResolveOptions, error) {
+	opts, ok := ctx.Value(resolveOptionsKey{}).(*ResolveOptions)
+	if !ok {
+		return nil, errors.New("mink's builders only run within mink resolve or mink apply")
+	}
+	return opts, nil
+}
 
 // ResolveOptions implements Interface for the `kn im resolve` command.
 type ResolveOptions struct {
 	// Inherit all of the base build options.
 	BaseBuildOptions
 
-	// Inherit the dockerfile and buildpack options.
+	// Inherit the dockerfile, buildpack, ko, jib and apko options.
 	dockerfileOptions
 	buildpackOptions
+	koOptions
+	jibOptions
+	apkoOptions
+
+	buildCacheOptions
 
 	Filenames []string
 	Recursive bool
 
 	Parallelism int
 
-	builders map[string]builder
+	// builders holds the builders of the schemes (see builds.Register).
+	builders map[string]builds.Builder
+
+	// aliases holds the URLs to which the references of the aliased
+	// schemes expand (see the schemes configuration).
+	aliases map[string]*url.URL
 }
 
 // ResolveOptions implements Interface
@@ -110,13 +206,18 @@ func (opts *ResolveOptions) AddFlags(cmd *cobra.Command) {
 	opts.BaseBuildOptions.AddFlags(cmd)
 	opts.dockerfileOptions.AddFlags(cmd)
 	opts.buildpackOptions.AddFlags(cmd)
+	opts.koOptions.AddFlags(cmd)
+	opts.jibOptions.AddFlags(cmd)
+	opts.apkoOptions.AddFlags(cmd)
+	opts.buildCacheOptions.AddFlags(cmd)
 
 	// Based on the same flags in kubectl / ko
 	cmd.Flags().StringSliceP("filename", "f", nil,
 		"Filename, directory, or URL to files to use to create the resource")
 	cmd.Flags().BoolP("recursive", "R", false,
 		"Process the directory used in -f, --filename recursively. Useful when you want to manage related manifests organized within the same directory.")
-	cmd.Flags().IntP("parallelism", "P", 20, "How many parallel builds to run at once.")
+	cmd.Flags().IntP("parallelism", "P", 20,
+		"How many parallel builds to run at once.  Builds run one at a time with --cache, since they share its claim.")
 }
 
 // Validate implements Interface
@@ -128,9 +229,24 @@ func (opts *ResolveOptions) Validate(cmd *cobra.Command, args []string) error {
 	if err := opts.dockerfileOptions.Validate(cmd, args); err != nil {
 		return err
 	}
+	if err := opts.dockerfileOptions.validatePlatforms(opts.BundleOptions.Platforms); err != nil {
+		return err
+	}
 	if err := opts.buildpackOptions.Validate(cmd, args); err != nil {
 		return err
 	}
+	if err := opts.koOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.jibOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.apkoOptions.Validate(cmd, args); err != nil {
+		return err
+	}
+	if err := opts.buildCacheOptions.Validate(cmd, args); err != nil {
+		return err
+	}
 
 	opts.Filenames = viper.GetStringSlice("filename")
 	if len(opts.Filenames) == 0 {
@@ -144,17 +260,71 @@ func (opts *ResolveOptions) Validate(cmd *cobra.Command, args []string) error {
 			"must be greater than 0, but got: %d", opts.Parallelism)
 	}
 
-	opts.builders = map[string]builder{
-		"dockerfile": opts.db,
-		"buildpack":  opts.bp,
-		"ko":         opts.ko,
-		"task":       opts.task,
-		"pipeline":   opts.pipeline,
+	opts.builders = make(map[string]builds.Builder)
+	for _, scheme := range builds.Schemes() {
+		opts.builders[scheme], _ = builds.Lookup(scheme)
+	}
+
+	opts.aliases = make(map[string]*url.URL)
+	for alias, target := range viper.GetStringMapString("schemes") {
+		if u, err := url.Parse(alias + "://"); err != nil || u.Scheme != alias {
+			return fmt.Errorf("invalid scheme alias %q", alias)
+		}
+		if _, ok := opts.builders[alias]; ok {
+			return fmt.Errorf("invalid scheme alias %q: the scheme has a builder", alias)
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf("invalid scheme alias %q: %w", alias, err)
+		}
+		if _, ok := opts.builders[u.Scheme]; !ok {
+			return fmt.Errorf("invalid scheme alias %q: %q has no builder for its scheme", alias, target)
+		}
+		opts.aliases[alias] = u
 	}
 
 	return nil
 }
 
+// builderOptions returns the options of the build of the reference u.
+func (opts *ResolveOptions) builderOptions(u *url.URL) (builds.BuilderOptions, error) {
+	tag, err := opts.tag(imageNameContext{URL: *u})
+	if err != nil {
+		return builds.BuilderOptions{}, err
+	}
+	return builds.BuilderOptions{
+		Tag:            tag,
+		Namespace:      Namespace(),
+		ServiceAccount: opts.ServiceAccount,
+		CacheClaim:     opts.CacheClaim,
+	}, nil
+}
+
+// expand returns the URL to which a reference of an aliased scheme expands:
+// the URL of the alias, with the query of the reference overriding its
+// query.  The host and path of the reference are appended to the path of
+// the alias, unless the builder of its scheme names what it builds by host
+// alone (see builds.NamedBuilder), e.g. task://name.  Other references are
+// returned as they are.
+func (opts *ResolveOptions) expand(u *url.URL) *url.URL {
+	alias, ok := opts.aliases[u.Scheme]
+	if !ok {
+		return u
+	}
+	target := *alias
+	if _, named := opts.builders[alias.Scheme].(builds.NamedBuilder); !named {
+		if rest := path.Join(u.Host, u.Path); rest != "" {
+			target.Path = path.Join("/", alias.Path, rest)
+		}
+	}
+	query := alias.Query()
+	for k, vs := range u.Query() {
+		query[k] = vs
+	}
+	target.RawQuery = query.Encode()
+	return &target
+}
+
 // Execute implements Interface
 func (opts *ResolveOptions) Execute(cmd *cobra.Command, args []string) error {
 	if len(args) != 0 {
@@ -296,7 +466,8 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, docs []*yaml.
 		}
 	}
 
-	errg, ctx := pool.NewWithContext(ctx, opts.Parallelism, opts.Parallelism)
+	parallelism := opts.buildCacheOptions.parallelism(opts.Parallelism)
+	errg, ctx := pool.NewWithContext(ctx, parallelism, parallelism)
 
 	// Next, perform parallel builds for each of the supported references.
 	var sm sync.Map
@@ -309,13 +480,14 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, docs []*yaml.
 		if err != nil {
 			return err
 		}
+		u = opts.expand(u)
 		builder, ok := opts.builders[u.Scheme]
 		if !ok {
 			continue
 		}
 
 		errg.Go(func() error {
-			digest, err := builder(ctx, source, u)
+			digest, err := opts.cachedBuild(ctx, builder, source, u)
 			if err != nil {
 				return err
 			}
@@ -342,7 +514,7 @@ func (opts *ResolveOptions) ResolveReferences(ctx context.Context, docs []*yaml.
 	return nil
 }
 
-func (opts *ResolveOptions) db(ctx context.Context, source name.Digest, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) db(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
 	if u.Host != "" {
 		return name.Digest{}, fmt.Errorf(
 			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
@@ -350,26 +522,23 @@ func (opts *ResolveOptions) db(ctx context.Context, source name.Digest, u *url.U
 	}
 
 	// Create the equivalent `mink build` invocation.
+	dfo, err := opts.dockerfileOptions.withQuery(u.Query())
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("invalid query in %q reference %s: %w", u.Scheme, u, err)
+	}
 	bo := BuildOptions{
 		BaseBuildOptions:  opts.BaseBuildOptions,
-		dockerfileOptions: opts.dockerfileOptions,
+		dockerfileOptions: dfo,
+		buildCacheOptions: opts.buildCacheOptions,
 	}
 	bo.Dockerfile = filepath.Join(u.Path, opts.Dockerfile)
 
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
+	// Run the produced Build definition to completion, streaming logs to w, and
 	// returning the digest of the produced image.
-	digest, err := bo.build(ctx, source, buf)
-	if err != nil {
-		log.Print(buf.String())
-		return name.Digest{}, err
-	}
-	return digest, nil
+	return bo.build(ctx, source, w)
 }
 
-func (opts *ResolveOptions) bp(ctx context.Context, source name.Digest, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) bp(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
 	if u.Host != "" {
 		return name.Digest{}, fmt.Errorf(
 			"unexpected host in %q reference, got: %s (did you mean %s:/// instead of %s://?)",
@@ -378,23 +547,16 @@ func (opts *ResolveOptions) bp(ctx context.Context, source name.Digest, u *url.U
 
 	// Create the equivalent `mink buildpack` invocation.
 	bpo := BuildpackOptions{
-		BaseBuildOptions: opts.BaseBuildOptions,
-		buildpackOptions: opts.buildpackOptions,
+		BaseBuildOptions:  opts.BaseBuildOptions,
+		buildpackOptions:  opts.buildpackOptions,
+		buildCacheOptions: opts.buildCacheOptions,
 	}
 	bpo.DescriptorFile = filepath.Join(u.Path, opts.DescriptorFile)
 
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-
-	digest, err := bpo.build(ctx, source, buf)
-	if err != nil {
-		log.Print(buf.String())
-		return name.Digest{}, err
-	}
-	return digest, nil
+	return bpo.build(ctx, source, w)
 }
 
-func (opts *ResolveOptions) task(ctx context.Context, source name.Digest, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) task(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
 	// Create the equivalent `mink build` invocation.
 	bo := &RunTaskOptions{
 		RunOptions: RunOptions{
@@ -403,10 +565,10 @@ func (opts *ResolveOptions) task(ctx context.Context, source name.Digest, u *url
 		},
 	}
 
-	return opts.run(ctx, source, u, &bo.RunOptions, bo.buildCmd)
+	return opts.run(ctx, source, u, &bo.RunOptions, bo.buildCmd, w)
 }
 
-func (opts *ResolveOptions) pipeline(ctx context.Context, source name.Digest, u *url.URL) (name.Digest, error) {
+func (opts *ResolveOptions) pipeline(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
 	// Create the equivalent `mink build` invocation.
 	bo := &RunPipelineOptions{
 		RunOptions: RunOptions{
@@ -415,12 +577,12 @@ func (opts *ResolveOptions) pipeline(ctx context.Context, source name.Digest, u
 		},
 	}
 
-	return opts.run(ctx, source, u, &bo.RunOptions, bo.buildCmd)
+	return opts.run(ctx, source, u, &bo.RunOptions, bo.buildCmd, w)
 }
 
 type buildCommander func(context.Context, string, signatureDetector) (*cobra.Command, error)
 
-func (opts *ResolveOptions) run(ctx context.Context, source name.Digest, u *url.URL, bo *RunOptions, bc buildCommander) (name.Digest, error) {
+func (opts *ResolveOptions) run(ctx context.Context, source name.Digest, u *url.URL, bo *RunOptions, bc buildCommander, w io.Writer) (name.Digest, error) {
 	// TODO(mattmoor): Introduce an optional duck for this?
 	if u.Path != "" {
 		return name.Digest{}, fmt.Errorf(
@@ -509,51 +671,31 @@ func (opts *ResolveOptions) run(ctx context.Context, source name.Digest, u *url.
 	}
 	taskCmd.SetArgs(args)
 
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-	taskCmd.SetOutput(buf)
-
+	taskCmd.SetOutput(w)
 	if err := taskCmd.Execute(); err != nil {
-		log.Print(buf.String())
 		return name.Digest{}, err
 	}
 
 	return digest, nil
 }
 
-func (opts *ResolveOptions) ko(ctx context.Context, source name.Digest, u *url.URL) (name.Digest, error) {
-	tag, err := opts.tag(imageNameContext{
-		URL: *u,
-	})
+func (opts *ResolveOptions) ko(ctx context.Context, source name.Digest, u *url.URL, w io.Writer) (name.Digest, error) {
+	// Create the equivalent `mink ko` invocation.
+	kopts, err := opts.koOptions.withQuery(u.Query())
 	if err != nil {
-		return name.Digest{}, err
+		return name.Digest{}, fmt.Errorf("invalid query in %q reference %s: %w", u.Scheme, u, err)
 	}
+	ko := KoOptions{
+		BaseBuildOptions:  opts.BaseBuildOptions,
+		koOptions:         kopts,
+		buildCacheOptions: opts.buildCacheOptions,
+	}
+	ip := *u
+	ip.RawQuery = ""
 
-	tr := ko.Build(ctx, source, tag, ko.Options{
-		ImportPath: u.String(),
-	})
-	tr.Namespace = Namespace()
-
-	// Buffer the output, so we can display it on failures.
-	buf := &bytes.Buffer{}
-
-	// Run the produced Build definition to completion, streaming logs to stdout, and
+	// Run the produced Build definition to completion, streaming logs to w, and
 	// returning the digest of the produced image.
-	digest, err := builds.Run(ctx, tag.String(), tr, &options.LogOptions{
-		ActivityTimeout: activityTimeout,
-		Params:          &cli.TektonParams{},
-		Stream: &cli.Stream{
-			// Send Out to stderr so we can capture the digest for composition.
-			Out: buf,
-			Err: buf,
-		},
-		Follow: true,
-	}, builds.WithTaskServiceAccount(ctx, opts.ServiceAccount, tag, source))
-	if err != nil {
-		log.Print(buf.String())
-		return name.Digest{}, err
-	}
-	return digest, nil
+	return ko.build(ctx, source, &ip, w)
 }
 
 func (opts *ResolveOptions) refsFromDoc(doc *yaml.Node) yit.Iterator {
@@ -562,6 +704,9 @@ func (opts *ResolveOptions) refsFromDoc(doc *yaml.Node) yit.Iterator {
 	for k := range opts.builders {
 		ps = append(ps, yit.WithPrefix(k+"://"))
 	}
+	for k := range opts.aliases {
+		ps = append(ps, yit.WithPrefix(k+"://"))
+	}
 
 	return yit.FromNode(doc).
 		RecurseNodes().
@@ -0,0 +1,154 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mattmoor/mink/pkg/builds"
+)
+
+func TestBuiltinsRegistered(t *testing.T) {
+	for _, test := range []struct {
+		scheme string
+		keyed  bool
+		named  bool
+	}{
+		{scheme: "dockerfile", keyed: true},
+		{scheme: "buildpack", keyed: true},
+		{scheme: "ko", keyed: true},
+		{scheme: "jib", keyed: true},
+		{scheme: "apko", keyed: true},
+		{scheme: "task", named: true},
+		{scheme: "pipeline", named: true},
+	} {
+		t.Run(test.scheme, func(t *testing.T) {
+			b, ok := builds.Lookup(test.scheme)
+			if !ok {
+				t.Fatalf("Lookup(%s) = false, wanted a builder", test.scheme)
+			}
+			if _, keyed := b.(builds.KeyedBuilder); keyed != test.keyed {
+				t.Errorf("KeyedBuilder = %v, wanted %v", keyed, test.keyed)
+			}
+			if _, named := b.(builds.NamedBuilder); named != test.named {
+				t.Errorf("NamedBuilder = %v, wanted %v", named, test.named)
+			}
+
+			// The builtins need the options of a resolve.
+			u := &url.URL{Scheme: test.scheme, Path: "/"}
+			if _, err := b.Build(context.Background(), name.Digest{}, u, builds.BuilderOptions{}); err == nil {
+				t.Error("Build() = nil, wanted error outside of resolve")
+			}
+		})
+	}
+}
+
+func TestNewBuilderCommands(t *testing.T) {
+	got := make([]string, 0, len(builderCommands))
+	for _, cmd := range NewBuilderCommands(context.Background()) {
+		got = append(got, cmd.Name())
+	}
+	// Builders that register a command are also registered for their scheme.
+	for _, scheme := range got {
+		if _, ok := builds.Lookup(scheme); !ok {
+			t.Errorf("Lookup(%s) = false, wanted a builder", scheme)
+		}
+	}
+	if diff := cmp.Diff([]string{"apko", "jib"}, got); diff != "" {
+		t.Errorf("NewBuilderCommands (-want, +got): %s", diff)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	opts := &ResolveOptions{
+		builders: make(map[string]builds.Builder),
+		aliases:  make(map[string]*url.URL),
+	}
+	for _, scheme := range builds.Schemes() {
+		opts.builders[scheme], _ = builds.Lookup(scheme)
+	}
+	for alias, target := range map[string]string{
+		"nodejs": "task://node-build?version=18",
+		"deploy": "pipeline://deploy",
+		"svc":    "ko://github.com/mattmoor/repo/cmd",
+		"web":    "dockerfile:///web?target=prod&build-arg=A=1",
+	} {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal("url.Parse() =", err)
+		}
+		opts.aliases[alias] = u
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{{
+		// Other schemes are left alone.
+		ref:  "ko://github.com/mattmoor/repo/cmd/server?platform=all",
+		want: "ko://github.com/mattmoor/repo/cmd/server?platform=all",
+	}, {
+		ref:  "nodejs://",
+		want: "task://node-build?version=18",
+	}, {
+		// Named targets take no path.
+		ref:  "nodejs:///app",
+		want: "task://node-build?version=18",
+	}, {
+		ref:  "nodejs://app/sub",
+		want: "task://node-build?version=18",
+	}, {
+		// The query of the reference overrides that of the alias.
+		ref:  "nodejs:///app?version=20",
+		want: "task://node-build?version=20",
+	}, {
+		ref:  "deploy://?env=prod",
+		want: "pipeline://deploy?env=prod",
+	}, {
+		// The host and path are appended to the paths of other targets.
+		ref:  "svc://server",
+		want: "ko://github.com/mattmoor/repo/cmd/server",
+	}, {
+		ref:  "svc:///server/v2",
+		want: "ko://github.com/mattmoor/repo/cmd/server/v2",
+	}, {
+		ref:  "svc://",
+		want: "ko://github.com/mattmoor/repo/cmd",
+	}, {
+		ref:  "web:///app?target=dev",
+		want: "dockerfile:///web/app?build-arg=A%3D1&target=dev",
+	}, {
+		ref:  "web://app?build-arg=B=2",
+		want: "dockerfile:///web/app?build-arg=B%3D2&target=prod",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			u, err := url.Parse(test.ref)
+			if err != nil {
+				t.Fatal("url.Parse() =", err)
+			}
+			if got := opts.expand(u).String(); got != test.want {
+				t.Errorf("expand() = %s, wanted %s", got, test.want)
+			}
+		})
+	}
+}
@@ -30,4 +30,11 @@ const (
 	// with an @:
 	//   ghcr.io/mattmoor/mink-images:latest@sha256:deadbeef
 	ImageDigestResult = "dev.mink.images.digest"
+
+	// ImageSBOMResult is the name of the Tekton result that surfaces the
+	// fully qualified reference of the software bill of materials (SBOM)
+	// of the image, for tasks that produce one, e.g.
+	//   ghcr.io/mattmoor/mink-images:sha256-deadbeef.sbom
+	// It is empty when the task didn't produce an SBOM after all.
+	ImageSBOMResult = "dev.mink.images.sbom"
 )